@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Права персональных API-токенов
+const (
+	scopePlaylistRead  = "playlist:read"
+	scopePlaylistWrite = "playlist:write"
+	scopePlayback      = "playback:control"
+	scopeAdmin         = "admin"
+
+	// По префиксу токен легко узнать в конфигах и логах
+	apiTokenPrefix = "md_"
+)
+
+var allScopes = []string{scopePlaylistRead, scopePlaylistWrite, scopePlayback, scopeAdmin}
+
+var (
+	errTokenNameRequired = errors.New("token name is required")
+	errUnknownScope      = errors.New("unknown scope")
+	errAdminScope        = errors.New("only administrators can create tokens with the admin scope")
+)
+
+// APIToken - персональный токен для скриптов и интеграций
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// HasScope - admin включает в себя все остальные права
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func createAPITokensTable(tx *sql.Tx) error {
+	// Как и у сессий, в базе лежит только хэш токена
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP
+	);`)
+	return err
+}
+
+// parseScopes проверяет список прав и убирает повторы
+func parseScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		known := false
+		for _, s := range allScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %s", errUnknownScope, scope)
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return result, nil
+}
+
+// createAPIToken возвращает сам токен - он показывается пользователю один раз
+func createAPIToken(db *sql.DB, user *User, name string, scopes []string) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errTokenNameRequired
+	}
+	scopes, err := parseScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, scope := range scopes {
+		if scope == scopeAdmin && !user.IsAdmin {
+			return "", nil, errAdminScope
+		}
+	}
+
+	secret, err := generateSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + secret
+
+	result, err := db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, scopes) VALUES (?, ?, ?, ?)",
+		user.ID, name, hashSessionToken(token), strings.Join(scopes, ","))
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &APIToken{
+		ID:        int(id),
+		UserID:    user.ID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// tokenCreateError - ключ сообщения и код ответа для ошибки createAPIToken
+func tokenCreateError(err error) (string, int) {
+	switch {
+	case errors.Is(err, errTokenNameRequired):
+		return "http.token_name_required", http.StatusBadRequest
+	case errors.Is(err, errUnknownScope):
+		return "http.unknown_scope", http.StatusBadRequest
+	case errors.Is(err, errAdminScope):
+		return "http.admin_scope", http.StatusForbidden
+	default:
+		return "http.token_create_error", http.StatusInternalServerError
+	}
+}
+
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var lastUsed sql.NullTime
+	if err := scanner.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	return &token, nil
+}
+
+func listAPITokens(db *sql.DB, userID int) ([]APIToken, error) {
+	rows, err := db.Query(`
+        SELECT id, user_id, name, scopes, created_at, last_used_at
+        FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// revokeAPIToken удаляет токен. Пользователь может отозвать только свои токены.
+func revokeAPIToken(db *sql.DB, userID, id int) (bool, error) {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// lookupAPIToken находит токен и его владельца, отмечая время использования
+func lookupAPIToken(db *sql.DB, token string) (*APIToken, *User, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil, nil
+	}
+
+	tokenHash := hashSessionToken(token)
+	apiToken, err := scanAPIToken(db.QueryRow(`
+        SELECT id, user_id, name, scopes, created_at, last_used_at
+        FROM api_tokens WHERE token_hash = ?`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := getUserByID(db, apiToken.UserID)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := db.Exec("UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", apiToken.ID); err != nil {
+		log.Printf("Warning: failed to update token usage: %v", err)
+	}
+	return apiToken, user, nil
+}
+
+// bearerToken достает токен из заголовка Authorization: Bearer <token>
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// authenticateBearer проверяет API-токен и кладет его и владельца в контекст запроса
+func authenticateBearer(r *http.Request, token string) (*http.Request, bool, error) {
+	apiToken, user, err := lookupAPIToken(db, token)
+	if err != nil || apiToken == nil {
+		return r, false, err
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, apiTokenContextKey, apiToken)
+	return r.WithContext(ctx), true, nil
+}
+
+// currentAPIToken - токен, которым авторизован запрос, или nil для сессии
+func currentAPIToken(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(apiTokenContextKey).(*APIToken)
+	return token
+}
+
+// requireScope ограничивает запросы по API-токену его правами.
+// Вход по паролю и сессии Telegram проверяются отдельно.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := currentAPIToken(r); token != nil && !token.HasScope(scope) {
+			httpError(w, r, "http.insufficient_scope", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Список токенов текущего пользователя
+func apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := listAPITokens(db, user.ID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	var requestData struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	token, apiToken, err := createAPIToken(db, user, requestData.Name, requestData.Scopes)
+	if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		key, code := tokenCreateError(err)
+		httpError(w, r, key, code)
+		return
+	}
+	log.Printf("User %q created API token %q (%s)", user.Username, apiToken.Name, strings.Join(apiToken.Scopes, ","))
+
+	response := struct {
+		*APIToken
+		Token string `json:"token"`
+	}{
+		APIToken: apiToken,
+		Token:    token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	var requestData struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := revokeAPIToken(db, user.ID, requestData.ID)
+	if err != nil {
+		log.Printf("Error revoking API token: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, r, "http.token_not_found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Управление плеером из скриптов: те же команды, что /next, /prev и /pause в боте
+func playerControlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		Action   string `json:"action"`
+		RoomCode string `json:"room_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := authorizeRoomAction(w, r, roomCodeParam(r, requestData.RoomCode), roomActionPlayback); !ok {
+		return
+	}
+
+	switch requestData.Action {
+	case "next", "prev", "pause", "now":
+		wsSend(map[string]string{
+			"type": requestData.Action,
+		})
+	default:
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Страница управления токенами
+func accountTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := struct {
+		Tokens   []APIToken
+		Scopes   []string
+		IsAdmin  bool
+		NewToken string
+		Error    string
+	}{
+		Scopes:  allScopes,
+		IsAdmin: user.IsAdmin,
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			r.ParseForm()
+			token, apiToken, err := createAPIToken(db, user, r.FormValue("name"), r.Form["scopes"])
+			if err != nil {
+				log.Printf("Error creating API token: %v", err)
+				key, _ := tokenCreateError(err)
+				data.Error = tr(r, key)
+				break
+			}
+			log.Printf("User %q created API token %q (%s)", user.Username, apiToken.Name, strings.Join(apiToken.Scopes, ","))
+			data.NewToken = token
+
+		case "revoke":
+			id, err := strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+				return
+			}
+			if _, err := revokeAPIToken(db, user.ID, id); err != nil {
+				log.Printf("Error revoking API token: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/account/tokens", http.StatusFound)
+			return
+
+		default:
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tokens, err := listAPITokens(db, user.ID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	data.Tokens = tokens
+
+	loadTemplate(w, r, "tokens.html", data)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// API v1: ресурсы в пути, методы HTTP по назначению и единый формат ошибок
+//
+//	{"error": {"code": "room_not_found", "message": "Комната не найдена"}}
+//
+// code не зависит от языка и подходит для обработки в клиентах, message
+// переведен на язык пользователя. Старые адреса /api/... остаются и
+// используют те же функции, но отвечают в прежнем формате.
+const apiV1Prefix = "/api/v1/"
+
+func isAPIv1(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiV1Prefix)
+}
+
+type apiErrorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeAPIError отвечает ошибкой в формате v1. Код ошибки - ключ сообщения
+// без префикса "http.".
+func writeAPIError(w http.ResponseWriter, r *http.Request, key string, status int) {
+	var body apiErrorBody
+	body.Error.Code = strings.TrimPrefix(key, "http.")
+	body.Error.Message = tr(r, key)
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// roomCodeParam - код комнаты из пути /api/v1/rooms/{code}/..., а для
+// старых адресов - из тела или строки запроса
+func roomCodeParam(r *http.Request, fallback string) string {
+	if code := r.PathValue("code"); code != "" {
+		return code
+	}
+	return fallback
+}
+
+// addRoomTrack добавляет трек по ссылке или ID в плейлист комнаты. Ссылка
+// на альбом, исполнителя или плейлист разворачивается в треки: тогда
+// возвращается итог добавления, а ID трека - 0.
+// При ошибке сам отвечает клиенту и возвращает false.
+func addRoomTrack(w http.ResponseWriter, r *http.Request, roomCode, trackURL string) (int, *bulkAddResult, bool) {
+	roomID, ok := authorizeRoomAction(w, r, roomCode, roomActionAdd)
+	if !ok {
+		return 0, nil, false
+	}
+
+	link, err := parseMusicLink(trackURL)
+	if err != nil {
+		log.Printf("Error extracting track ID: %v", err)
+		httpError(w, r, "http.invalid_track_url", http.StatusBadRequest)
+		return 0, nil, false
+	}
+	if link.Kind != musicLinkTrack {
+		result, ok := addRoomCollection(w, r, roomID, link)
+		return 0, result, ok
+	}
+	trackID := link.ID
+
+	// Трек может быть только в одном плейлисте
+	exists, err := checkTrackExists(trackID, db)
+	if err != nil {
+		log.Printf("Error checking track existence: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	if exists {
+		httpError(w, r, "http.track_exists", http.StatusConflict)
+		return 0, nil, false
+	}
+
+	api, ok := roomYandexAPI(w, r, roomID)
+	if !ok {
+		return 0, nil, false
+	}
+	tracks, err := api.Tracks(r.Context(), []int{trackID})
+	if err != nil {
+		log.Printf("Error getting track %d: %v", trackID, err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return 0, nil, false
+	}
+	// Неизвестный трек Яндекс.Музыка возвращает без названия
+	if tracks[0].Title == "" {
+		httpError(w, r, "http.not_found", http.StatusNotFound)
+		return 0, nil, false
+	}
+
+	if err := addTrackToPlaylist(r.Context(), &tracks[0], roomID, requestAddedBy(r), requestSource(r), db); err != nil {
+		log.Printf("Error adding track to playlist: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	return trackID, nil, true
+}
+
+// addRoomCollection добавляет в комнату треки альбома, исполнителя или плейлиста
+func addRoomCollection(w http.ResponseWriter, r *http.Request, roomID int, link *musicLink) (*bulkAddResult, bool) {
+	api := yandexAPIForRoom(roomID)
+	if api == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	title, tracks, err := expandMusicLink(r.Context(), api, link)
+	if isYandexNotFound(err) {
+		httpError(w, r, "http.link_not_found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error expanding %s %d: %v", link.Kind, link.ID, err)
+		httpError(w, r, "http.track_info", http.StatusBadGateway)
+		return nil, false
+	}
+
+	result, err := bulkInsertTracks(r.Context(), roomID, title, tracks, requestAddedBy(r), requestSource(r))
+	if err != nil {
+		log.Printf("Error adding tracks to playlist: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return nil, false
+	}
+	log.Printf("Room %d: added %d of %d tracks from %s %d", roomID, len(result.Added), len(tracks), link.Kind, link.ID)
+	return result, true
+}
+
+// moveRoomTrack меняет позицию трека в плейлисте комнаты
+func moveRoomTrack(w http.ResponseWriter, r *http.Request, roomCode string, trackID, position int) bool {
+	roomID, ok := authorizeRoomAction(w, r, roomCode, roomActionReorder)
+	if !ok {
+		return false
+	}
+
+	result, err := db.Exec("UPDATE playlist SET position = ? WHERE track_id = ? AND room_id = ?", position, trackID, roomID)
+	if err != nil {
+		log.Printf("Error updating track position: %v", err)
+		httpError(w, r, "http.update_position", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "http.track_not_found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// deleteRoomTrack удаляет трек из плейлиста комнаты
+func deleteRoomTrack(w http.ResponseWriter, r *http.Request, roomCode string, trackID int) bool {
+	roomID, ok := authorizeRoomAction(w, r, roomCode, roomActionRemove)
+	if !ok {
+		return false
+	}
+
+	result, err := db.Exec("DELETE FROM playlist WHERE track_id = ? AND room_id = ?", trackID, roomID)
+	if err != nil {
+		log.Printf("Error deleting track: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		log.Printf("Track %d not found in room %d", trackID, roomID)
+		httpError(w, r, "http.track_not_found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// pathTrackID - ID трека из пути запроса
+func pathTrackID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	trackID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || trackID <= 0 {
+		httpError(w, r, "http.invalid_track_id", http.StatusBadRequest)
+		return 0, false
+	}
+	return trackID, true
+}
+
+// GET /api/v1/rooms/{code}/tracks - страница треков комнаты, по умолчанию
+// 50. Фильтры и сортировка - как у /api/tracks (parseTrackQuery).
+func apiV1ListRoomTracks(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionView)
+	if !ok {
+		return
+	}
+	q, ok := trackQueryForRequest(w, r, defaultTrackPageSize)
+	if !ok {
+		return
+	}
+
+	client := clientForRoom(roomID)
+	page, err := queryPlaylist(r.Context(), q)
+	if err != nil {
+		log.Printf("Error getting room tracks: %v", err)
+		httpError(w, r, "http.room_tracks", http.StatusInternalServerError)
+		return
+	}
+	if err := fillPlaylistMetadata(r.Context(), page.Entries, yandexAPIForRoom(roomID)); err != nil {
+		log.Printf("Error filling track metadata: %v", err)
+	}
+
+	response := map[string]interface{}{
+		"tracks": pageTracks(r.Context(), page.Entries, client),
+		"total":  page.Total,
+	}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// POST /api/v1/rooms/{code}/tracks {"track_url": "..."}
+func apiV1AddRoomTrack(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		TrackURL string `json:"track_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := r.PathValue("code")
+	trackID, result, ok := addRoomTrack(w, r, code, requestData.TrackURL)
+	if !ok {
+		return
+	}
+	if result != nil {
+		writeJSON(w, http.StatusCreated, result)
+		return
+	}
+
+	// Данные трека - по возможности; сам трек уже добавлен
+	track := &TrackInfo{TrackID: trackID}
+	if info, err := getTrackInfo(r.Context(), trackID, clientForRoomCode(code)); err == nil {
+		track = info
+	} else {
+		log.Printf("Error getting track info: %v", err)
+	}
+
+	w.Header().Set("Location", apiV1Prefix+"rooms/"+code+"/tracks/"+strconv.Itoa(trackID))
+	writeJSON(w, http.StatusCreated, track)
+}
+
+// PATCH /api/v1/rooms/{code}/tracks/{id} {"position": 3}
+func apiV1MoveRoomTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, ok := pathTrackID(w, r)
+	if !ok {
+		return
+	}
+
+	var requestData struct {
+		Position *int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.Position == nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	if !moveRoomTrack(w, r, r.PathValue("code"), trackID, *requestData.Position) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"track_id": trackID, "position": *requestData.Position})
+}
+
+// DELETE /api/v1/rooms/{code}/tracks/{id}
+func apiV1DeleteRoomTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, ok := pathTrackID(w, r)
+	if !ok {
+		return
+	}
+	if !deleteRoomTrack(w, r, r.PathValue("code"), trackID) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/tracks/{id}[?room_code=ABCDE] - данные трека из Яндекс.Музыки
+func apiV1GetTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, ok := pathTrackID(w, r)
+	if !ok {
+		return
+	}
+
+	client := clientForRoomCode(r.URL.Query().Get("room_code"))
+	if client == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	track, err := getTrackInfo(r.Context(), trackID, client)
+	if err != nil {
+		log.Printf("Error getting track info: %v", err)
+		if strings.Contains(err.Error(), "no track information found") {
+			httpError(w, r, "http.not_found", http.StatusNotFound)
+		} else {
+			httpError(w, r, "http.track_info", http.StatusBadGateway)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, track)
+}
+
+// POST /api/v1/rooms
+func apiV1CreateRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, code, ok := createRoom(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Location", apiV1Prefix+"rooms/"+code)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": roomID, "code": code})
+}
+
+// PUT /api/v1/rooms/{code}/members/{type}/{id} {"role": "dj"} - смена роли,
+// DELETE - исключение из комнаты
+func apiV1RoomMember(w http.ResponseWriter, r *http.Request) {
+	memberID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	target := roomMember{Type: r.PathValue("type"), ID: memberID}
+
+	role := roomRoleKicked
+	if r.Method != http.MethodDelete {
+		var requestData struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+		if role, err = parseRoomRole(requestData.Role); err != nil {
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionManage)
+	if !ok {
+		return
+	}
+
+	errKey, err := changeMemberRole(r, roomID, target, role)
+	if err != nil {
+		log.Printf("Error changing room role: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if errKey != "" {
+		httpError(w, r, errKey, memberRoleErrorStatus(errKey))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"member_type": target.Type,
+		"member_id":   target.ID,
+		"role":        role.String(),
+	})
+}
+
+// DELETE /api/v1/tokens/{id}
+func apiV1RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "http.token_not_found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := revokeAPIToken(db, user.ID, id)
+	if err != nil {
+		log.Printf("Error revoking API token: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, r, "http.token_not_found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Неизвестный адрес внутри /api/v1/ - ошибка в формате v1, а не главная страница
+func apiV1NotFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, "http.not_found", http.StatusNotFound)
+}
+
+// registerAPIv1 регистрирует маршруты v1 с теми же правами, что у старых адресов
+func registerAPIv1(mux *routeMux) {
+	mux.HandleFunc("/api/v1/", apiV1NotFound)
+
+	mux.HandleFunc("GET /api/v1/tracks/{id}", requireScope(scopePlaylistRead, apiV1GetTrack))
+	mux.HandleFunc("GET /api/v1/tracks/{id}/stream", requireScope(scopePlaylistRead, apiV1StreamTrack))
+
+	mux.HandleFunc("POST /api/v1/rooms", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, requireTOTP(apiV1CreateRoom))))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/join", requireScope(scopePlaylistRead, joinRoomHandler))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/tracks", requireScope(scopePlaylistRead, apiV1ListRoomTracks))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/tracks", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1AddRoomTrack)))
+	mux.HandleFunc("PATCH /api/v1/rooms/{code}/tracks/{id}", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1MoveRoomTrack)))
+	mux.HandleFunc("DELETE /api/v1/rooms/{code}/tracks/{id}", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1DeleteRoomTrack)))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/members", requireScope(scopePlaylistRead, roomMembersHandler))
+	mux.HandleFunc("PUT /api/v1/rooms/{code}/members/{type}/{id}", requireScope(scopePlaylistWrite, apiV1RoomMember))
+	mux.HandleFunc("DELETE /api/v1/rooms/{code}/members/{type}/{id}", requireScope(scopePlaylistWrite, apiV1RoomMember))
+	mux.HandleFunc("PUT /api/v1/rooms/{code}/account", requireScope(scopePlaylistWrite, requireTOTP(roomAccountHandler)))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/yandex/playlists", requireScope(scopePlaylistRead, apiV1YandexPlaylists))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/imports", requireScope(scopePlaylistRead, apiV1ListImports))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/imports", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1ImportPlaylist)))
+	mux.HandleFunc("DELETE /api/v1/rooms/{code}/imports/{id}", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1DeleteImport)))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/export", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1ExportRoom)))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/uploads", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1UploadPlaylist)))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/uploads/{id}", requireScope(scopePlaylistRead, apiV1GetUpload))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/uploads/{id}/confirm", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1ConfirmUpload)))
+	mux.HandleFunc("DELETE /api/v1/rooms/{code}/uploads/{id}", requireScope(scopePlaylistWrite, apiV1DeleteUpload))
+	mux.HandleFunc("GET /api/v1/rooms/{code}/export/{format}", requireScope(scopePlaylistRead, apiV1RoomPlaylistFile))
+	mux.HandleFunc("POST /api/v1/rooms/{code}/player", requireScope(scopePlayback, requireBotRole(roleDJ, playerControlHandler)))
+
+	mux.HandleFunc("GET /api/v1/playlists", requireScope(scopePlaylistRead, apiV1ListPlaylists))
+	mux.HandleFunc("POST /api/v1/playlists", requireScope(scopePlaylistWrite, apiV1CreatePlaylist))
+	mux.HandleFunc("GET /api/v1/playlists/{id}", requireScope(scopePlaylistRead, apiV1GetPlaylist))
+	mux.HandleFunc("PATCH /api/v1/playlists/{id}", requireScope(scopePlaylistWrite, apiV1UpdatePlaylist))
+	mux.HandleFunc("DELETE /api/v1/playlists/{id}", requireScope(scopePlaylistWrite, apiV1DeletePlaylist))
+	mux.HandleFunc("POST /api/v1/playlists/{id}/tracks", requireScope(scopePlaylistWrite, apiV1AddPlaylistTracks))
+	mux.HandleFunc("PATCH /api/v1/playlists/{id}/tracks/{track_id}", requireScope(scopePlaylistWrite, apiV1MovePlaylistTrack))
+	mux.HandleFunc("DELETE /api/v1/playlists/{id}/tracks/{track_id}", requireScope(scopePlaylistWrite, apiV1DeletePlaylistTrack))
+	mux.HandleFunc("GET /api/v1/playlists/{id}/export/{format}", requireScope(scopePlaylistRead, apiV1SavedPlaylistFile))
+	mux.HandleFunc("POST /api/v1/playlists/{id}/queue", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, apiV1QueuePlaylist)))
+
+	mux.HandleFunc("GET /api/v1/accounts", requireScope(scopePlaylistRead, yandexAccountsHandler))
+
+	mux.HandleFunc("GET /api/v1/tokens", requireScope(scopeAdmin, apiTokensHandler))
+	mux.HandleFunc("POST /api/v1/tokens", requireScope(scopeAdmin, requireTOTP(createAPITokenHandler)))
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", requireScope(scopeAdmin, apiV1RevokeToken))
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "session"
+	sessionTTL        = 30 * 24 * time.Hour
+
+	// Параметры PBKDF2-HMAC-SHA256 для хранения паролей
+	passwordIterations = 210000
+	passwordSaltLen    = 16
+	passwordKeyLen     = 32
+	minPasswordLen     = 8
+)
+
+// User - учетная запись веб-интерфейса
+type User struct {
+	ID        int
+	Username  string
+	IsAdmin   bool
+	CreatedAt time.Time
+	// Заполняется только в listUsers
+	TOTPEnabled bool
+}
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	apiTokenContextKey
+	botRoleContextKey
+)
+
+func createUsersTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		is_admin INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// В базе хранится только хэш токена сессии, сам токен есть только в cookie
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// pbkdf2SHA256 - PBKDF2 (RFC 8018) с HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// hashPassword возвращает строку вида pbkdf2-sha256$<итерации>$<соль>$<хэш>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, passwordKeyLen)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2SHA256([]byte(password), salt, iterations, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func countUsers(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+func createUser(db *sql.DB, username, password string, isAdmin bool) (int64, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return 0, fmt.Errorf("username is required")
+	}
+	if len(password) < minPasswordLen {
+		return 0, fmt.Errorf("password must be at least %d characters", minPasswordLen)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec("INSERT INTO users (username, password_hash, is_admin) VALUES (?, ?, ?)",
+		username, hash, isAdmin)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func getUserByID(db *sql.DB, id int) (*User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, username, is_admin, created_at FROM users WHERE id = ?", id).
+		Scan(&user.ID, &user.Username, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func listUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query(`
+        SELECT u.id, u.username, u.is_admin, u.created_at, COALESCE(t.enabled, 0)
+        FROM users u LEFT JOIN user_totp t ON t.user_id = u.id
+        ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.IsAdmin, &user.CreatedAt, &user.TOTPEnabled); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func deleteUser(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM api_tokens WHERE user_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// authenticate проверяет логин и пароль. Для неизвестного пользователя
+// хэш все равно считается, чтобы время ответа не выдавало существование логина.
+func authenticate(db *sql.DB, username, password string) (*User, error) {
+	var id int
+	var hash string
+	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", strings.TrimSpace(username)).
+		Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		checkPassword(password, dummyPasswordHash)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !checkPassword(password, hash) {
+		return nil, nil
+	}
+	return getUserByID(db, id)
+}
+
+var dummyPasswordHash, _ = hashPassword("dummy password")
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createSession(db *sql.DB, userID int) (string, time.Time, error) {
+	return insertSession(db, userID, sessionTTL, false)
+}
+
+// createPendingSession - короткая сессия до проверки второго фактора,
+// с ней доступна только страница ввода кода
+func createPendingSession(db *sql.DB, userID int) (string, time.Time, error) {
+	return insertSession(db, userID, mfaPendingTTL, true)
+}
+
+func insertSession(db *sql.DB, userID int, ttl time.Duration, pending bool) (string, time.Time, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expires := time.Now().Add(ttl).UTC()
+	_, err = db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at, pending) VALUES (?, ?, ?, ?)",
+		hashSessionToken(token), userID, expires, pending)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// sessionUser возвращает пользователя по cookie сессии
+func sessionUser(r *http.Request) (*User, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	var userID int
+	var expires time.Time
+	err = db.QueryRow("SELECT user_id, expires_at FROM sessions WHERE token_hash = ? AND pending = 0", hashSessionToken(cookie.Value)).
+		Scan(&userID, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expires) {
+		return nil, nil
+	}
+
+	user, err := getUserByID(db, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentUser - пользователь, которого requireLogin положил в контекст запроса
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// Адреса, доступные без входа
+func isPublicPath(path string) bool {
+	switch path {
+	case "/login", "/login/2fa", "/lang", "/setup", "/tg/app", "/api/telegram/auth", "/api/openapi.json", "/api/docs":
+		return true
+	}
+	return strings.HasPrefix(path, "/static/")
+}
+
+// Адреса, доступные сессии Mini App: плееру нужны только API и WebSocket
+func isMiniAppPath(path string) bool {
+	return path == "/ws" || path == "/add-track" || strings.HasPrefix(path, "/api/")
+}
+
+// requireLogin пропускает только вошедших пользователей. Mini App из Telegram
+// авторизуется своей сессией и проверяется по ролям бота, скрипты - API-токеном.
+func requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Ссылки из файлов плейлистов открываются в плеерах без сессии
+		if isSignedStreamRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Скрипты и интеграции обращаются к API с персональным токеном
+		if token, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			authed, valid, err := authenticateBearer(r, token)
+			if err != nil {
+				log.Printf("Error checking API token: %v", err)
+				httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+				return
+			}
+			if !valid {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				httpError(w, r, "http.unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, authed)
+			return
+		}
+
+		user, err := sessionUser(r)
+		if err != nil {
+			log.Printf("Error loading session: %v", err)
+			httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+			return
+		}
+
+		// Сессия Mini App не открывает страницы настроек, отладки и
+		// администрирования - только плеер и только пока у пользователя
+		// есть роль в боте
+		if _, ok := telegramSessionUser(r); ok {
+			if !isMiniAppPath(r.URL.Path) {
+				httpError(w, r, "http.forbidden", http.StatusForbidden)
+				return
+			}
+			requireBotRole(roleListener, next.ServeHTTP)(w, r)
+			return
+		}
+
+		// API и WebSocket получают 401, страницы - перенаправление на вход
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/ws" || r.Method != http.MethodGet {
+			httpError(w, r, "http.unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	})
+}
+
+// requireAdmin - только для администраторов веб-интерфейса
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if user == nil || !user.IsAdmin {
+			httpError(w, r, "http.forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// safeRedirect разрешает возврат только на локальные адреса
+func safeRedirect(next string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	// Первого администратора создает мастер настройки
+	users, err := countUsers(db)
+	if err != nil {
+		log.Printf("Error counting users: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if users == 0 {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+
+	data := struct {
+		Next  string
+		Error string
+	}{
+		Next: safeRedirect(r.FormValue("next")),
+	}
+
+	if r.Method != http.MethodPost {
+		loadTemplate(w, r, "login.html", data)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	user, err := authenticate(db, username, password)
+	if err != nil {
+		log.Printf("Error authenticating user: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		log.Printf("Failed login attempt for %q from %s", username, r.RemoteAddr)
+		data.Error = tr(r, "page.login_failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		loadTemplate(w, r, "login.html", data)
+		return
+	}
+
+	enabled, err := totpEnabled(db, user.ID)
+	if err != nil {
+		log.Printf("Error reading 2FA status: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		token, expires, err := createPendingSession(db, user.ID)
+		if err != nil {
+			log.Printf("Error creating session: %v", err)
+			httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, r, token, expires)
+		http.Redirect(w, r, "/login/2fa?next="+url.QueryEscape(data.Next), http.StatusFound)
+		return
+	}
+
+	token, expires, err := createSession(db, user.ID)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, token, expires)
+
+	http.Redirect(w, r, data.Next, http.StatusFound)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashSessionToken(cookie.Value)); err != nil {
+			log.Printf("Error deleting session: %v", err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// Управление пользователями (только для администраторов)
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Users      []User
+		Current    *User
+		Require2FA bool
+		Error      string
+	}{
+		Current: currentUser(r),
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			_, err := createUser(db, r.FormValue("username"), r.FormValue("password"), r.FormValue("is_admin") == "on")
+			if err != nil {
+				log.Printf("Error creating user: %v", err)
+				data.Error = tr(r, "page.user_create_error", err)
+			}
+
+		case "require_2fa":
+			value := "0"
+			if r.FormValue("require_2fa") == "on" {
+				value = "1"
+			}
+			if err := setAuthSetting(db, settingRequire2FA, value); err != nil {
+				log.Printf("Error saving 2FA settings: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+
+		case "reset_2fa":
+			// Для пользователя, потерявшего и телефон, и коды восстановления
+			id, err := strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+				return
+			}
+			if err := disableTOTP(db, id); err != nil {
+				log.Printf("Error resetting 2FA: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Admin %q reset 2FA for user %d", data.Current.Username, id)
+
+		case "delete":
+			id, err := strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+				return
+			}
+			if id == data.Current.ID {
+				data.Error = tr(r, "page.cannot_delete_self")
+				break
+			}
+			if err := deleteUser(db, id); err != nil {
+				log.Printf("Error deleting user: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+
+		default:
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+
+		if data.Error == "" {
+			http.Redirect(w, r, "/users", http.StatusFound)
+			return
+		}
+	}
+
+	users, err := listUsers(db)
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	data.Users = users
+
+	data.Require2FA, err = require2FA(db)
+	if err != nil {
+		log.Printf("Error reading 2FA settings: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	loadTemplate(w, r, "users.html", data)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botRole - уровень доступа к Telegram-боту. Роли упорядочены:
+// каждая следующая включает права предыдущей.
+type botRole int
+
+const (
+	roleNone botRole = iota
+	roleListener
+	roleDJ
+	roleOwner
+)
+
+func (r botRole) String() string {
+	switch r {
+	case roleListener:
+		return "listener"
+	case roleDJ:
+		return "dj"
+	case roleOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+func parseBotRole(s string) (botRole, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "listener":
+		return roleListener, nil
+	case "dj":
+		return roleDJ, nil
+	case "owner":
+		return roleOwner, nil
+	default:
+		return roleNone, fmt.Errorf("unknown role: %s", s)
+	}
+}
+
+// Тип субъекта в списке доступа: отдельный пользователь или целый чат
+const (
+	accessSubjectUser = "user"
+	accessSubjectChat = "chat"
+)
+
+// Минимальная роль, необходимая для выполнения команды.
+// Команды, которых нет в списке, требуют роли listener.
+var commandRoles = map[string]botRole{
+	"start":      roleListener,
+	"help":       roleListener,
+	"playlist":   roleListener,
+	"now":        roleListener,
+	"join":       roleListener,
+	"send":       roleListener,
+	"next":       roleDJ,
+	"prev":       roleDJ,
+	"pause":      roleDJ,
+	"notify":     roleDJ,
+	"nowplaying": roleDJ,
+	"import":     roleDJ,
+	"grant":      roleOwner,
+	"revoke":     roleOwner,
+	"export":     roleOwner,
+}
+
+func requiredRole(command string) botRole {
+	if role, ok := commandRoles[command]; ok {
+		return role
+	}
+	return roleListener
+}
+
+func createTelegramAccessTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS telegram_access (
+		id INTEGER PRIMARY KEY,
+		subject_type TEXT NOT NULL,
+		subject_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		granted_by INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (subject_type, subject_id)
+	);`)
+	return err
+}
+
+func getAccessRole(db *sql.DB, subjectType string, subjectID int64) (botRole, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM telegram_access WHERE subject_type = ? AND subject_id = ?",
+		subjectType, subjectID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return roleNone, nil
+	}
+	if err != nil {
+		return roleNone, err
+	}
+	return parseBotRole(role)
+}
+
+// resolveBotRole возвращает действующую роль автора сообщения:
+// наибольшую из роли пользователя и роли чата, в котором он пишет.
+func resolveBotRole(db *sql.DB, message *tgbotapi.Message) (botRole, error) {
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	return resolveRole(db, userID, message.Chat.ID)
+}
+
+func resolveRole(db *sql.DB, userID, chatID int64) (botRole, error) {
+	role := roleNone
+
+	if userID != 0 {
+		userRole, err := getAccessRole(db, accessSubjectUser, userID)
+		if err != nil {
+			return roleNone, err
+		}
+		role = userRole
+	}
+
+	chatRole, err := getAccessRole(db, accessSubjectChat, chatID)
+	if err != nil {
+		return roleNone, err
+	}
+	if chatRole > role {
+		role = chatRole
+	}
+
+	return role, nil
+}
+
+func grantAccess(db *sql.DB, subjectType string, subjectID int64, role botRole, grantedBy int64) error {
+	_, err := db.Exec(`
+        INSERT INTO telegram_access (subject_type, subject_id, role, granted_by)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (subject_type, subject_id) DO UPDATE SET role = excluded.role, granted_by = excluded.granted_by`,
+		subjectType, subjectID, role.String(), grantedBy)
+	return err
+}
+
+func revokeAccess(db *sql.DB, subjectType string, subjectID int64) (bool, error) {
+	result, err := db.Exec("DELETE FROM telegram_access WHERE subject_type = ? AND subject_id = ?",
+		subjectType, subjectID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func countOwners(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM telegram_access WHERE subject_type = ? AND role = ?",
+		accessSubjectUser, roleOwner.String()).Scan(&n)
+	return n, err
+}
+
+// ensureBotOwner добавляет владельца из конфигурации, чтобы бот
+// не оказался без единого пользователя, способного выдавать доступ.
+// Роль этого владельца задает только конфигурация: /grant и /revoke ее не
+// меняют, иначе при следующем запуске она молча вернулась бы.
+func ensureBotOwner(db *sql.DB, ownerID int64) error {
+	if ownerID == 0 {
+		return nil
+	}
+	return grantAccess(db, accessSubjectUser, ownerID, roleOwner, 0)
+}
+
+// parseAccessArgs разбирает аргументы /grant и /revoke:
+// "[chat] <id> [role]". Без "chat" id считается ID пользователя.
+func parseAccessArgs(args string, withRole bool) (subjectType string, subjectID int64, role botRole, err error) {
+	fields := strings.Fields(args)
+	subjectType = accessSubjectUser
+	if len(fields) > 0 && (fields[0] == accessSubjectChat || fields[0] == accessSubjectUser) {
+		subjectType = fields[0]
+		fields = fields[1:]
+	}
+
+	want := 1
+	if withRole {
+		want = 2
+	}
+	if len(fields) != want {
+		return "", 0, roleNone, fmt.Errorf("wrong number of arguments")
+	}
+
+	subjectID, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", 0, roleNone, fmt.Errorf("invalid id: %s", fields[0])
+	}
+
+	if withRole {
+		role, err = parseBotRole(fields[1])
+		if err != nil {
+			return "", 0, roleNone, err
+		}
+	}
+
+	return subjectType, subjectID, role, nil
+}
+
+func handleGrantCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	subjectType, subjectID, role, err := parseAccessArgs(message.CommandArguments(), true)
+	if err != nil {
+		return T(lang, "bot.grant_usage")
+	}
+
+	// Роль владельца дается только конкретным людям, а не всем участникам чата
+	if subjectType == accessSubjectChat && role > roleDJ {
+		return T(lang, "bot.chat_owner")
+	}
+	if role < roleOwner && isConfiguredOwner(cfg, subjectType, subjectID) {
+		return T(lang, "bot.config_owner")
+	}
+	// Понижение последнего владельца оставило бы бота без владельца
+	if role < roleOwner {
+		last, err := isLastOwner(cfg.Database, subjectType, subjectID)
+		if err != nil {
+			return T(lang, "bot.access_error", err)
+		}
+		if last {
+			return T(lang, "bot.last_owner")
+		}
+	}
+
+	var grantedBy int64
+	if message.From != nil {
+		grantedBy = message.From.ID
+	}
+
+	if err := grantAccess(cfg.Database, subjectType, subjectID, role, grantedBy); err != nil {
+		return T(lang, "bot.grant_error", err)
+	}
+
+	return T(lang, "bot.granted", role, subjectType, subjectID)
+}
+
+// isConfiguredOwner - субъект - владелец из telegram_owner_id
+func isConfiguredOwner(cfg *Config, subjectType string, subjectID int64) bool {
+	return subjectType == accessSubjectUser && cfg.OwnerID != 0 && subjectID == cfg.OwnerID
+}
+
+// isLastOwner - субъект единственный владелец бота
+func isLastOwner(db *sql.DB, subjectType string, subjectID int64) (bool, error) {
+	if subjectType != accessSubjectUser {
+		return false, nil
+	}
+	role, err := getAccessRole(db, subjectType, subjectID)
+	if err != nil || role != roleOwner {
+		return false, err
+	}
+	owners, err := countOwners(db)
+	return owners <= 1, err
+}
+
+func handleRevokeCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	subjectType, subjectID, _, err := parseAccessArgs(message.CommandArguments(), false)
+	if err != nil {
+		return T(lang, "bot.revoke_usage")
+	}
+
+	if isConfiguredOwner(cfg, subjectType, subjectID) {
+		return T(lang, "bot.config_owner")
+	}
+	// Не даем отозвать доступ у последнего владельца
+	last, err := isLastOwner(cfg.Database, subjectType, subjectID)
+	if err != nil {
+		return T(lang, "bot.access_error", err)
+	}
+	if last {
+		return T(lang, "bot.last_owner")
+	}
+
+	removed, err := revokeAccess(cfg.Database, subjectType, subjectID)
+	if err != nil {
+		return T(lang, "bot.revoke_error", err)
+	}
+	if !removed {
+		return T(lang, "bot.revoke_not_found", subjectType, subjectID)
+	}
+
+	return T(lang, "bot.revoked", subjectType, subjectID)
+}
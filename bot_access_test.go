@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func botCommand(text string) *tgbotapi.Message {
+	command, _, _ := strings.Cut(text, " ")
+	return &tgbotapi.Message{
+		Text:     text,
+		From:     &tgbotapi.User{ID: 1, LanguageCode: "en"},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+// Владелец из конфигурации не понижается и не отзывается командами бота:
+// при следующем запуске ensureBotOwner все равно вернул бы ему роль
+func TestConfiguredOwnerIsProtected(t *testing.T) {
+	openTestDB(t)
+	cfg := &Config{OwnerID: 42, Database: db}
+	if err := ensureBotOwner(db, cfg.OwnerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := grantAccess(db, accessSubjectUser, 7, roleOwner, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		run  func(*tgbotapi.Message, *Config) string
+		want string
+	}{
+		{"/grant 42 dj", handleGrantCommand, "bot.config_owner"},
+		{"/revoke 42", handleRevokeCommand, "bot.config_owner"},
+		{"/grant 42 owner", handleGrantCommand, "bot.granted"},
+		{"/grant 7 dj", handleGrantCommand, "bot.granted"},
+		{"/revoke 7", handleRevokeCommand, "bot.revoked"},
+	}
+	for _, tt := range tests {
+		got := tt.run(botCommand(tt.text), cfg)
+		want := catalogs["en"][tt.want]
+		if i := strings.Index(want, "%"); i >= 0 {
+			want = want[:i]
+		}
+		if !strings.HasPrefix(got, want) {
+			t.Errorf("%s = %q, want %s", tt.text, got, tt.want)
+		}
+	}
+
+	role, err := getAccessRole(db, accessSubjectUser, 42)
+	if err != nil || role != roleOwner {
+		t.Errorf("configured owner role = %v, %v; want owner", role, err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Bot API не принимает от ботов файлы больше 50 МБ
+	telegramUploadLimit = 50 << 20
+	// Миниатюра должна быть JPEG не больше 200 КБ и не больше 320x320
+	telegramThumbLimit = 200 << 10
+	telegramThumbSize  = "200x200"
+
+	sendCallbackPrefix = "send:"
+	// Telegram ограничивает количество кнопок в одной клавиатуре
+	maxTrackButtons = 50
+)
+
+var audioHTTPClient = &http.Client{Timeout: 2 * time.Minute}
+
+// maxAudioSends - сколько треков бот одновременно скачивает и загружает в Telegram
+const maxAudioSends = 3
+
+var audioSendSlots = make(chan struct{}, maxAudioSends)
+
+func createTelegramAudioCacheTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS telegram_audio_cache (
+		track_id INTEGER PRIMARY KEY,
+		file_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	return err
+}
+
+func getCachedAudioFileID(db *sql.DB, trackID int) (string, error) {
+	var fileID string
+	err := db.QueryRow("SELECT file_id FROM telegram_audio_cache WHERE track_id = ?", trackID).Scan(&fileID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return fileID, err
+}
+
+func cacheAudioFileID(db *sql.DB, trackID int, fileID string) error {
+	_, err := db.Exec(`
+        INSERT INTO telegram_audio_cache (track_id, file_id) VALUES (?, ?)
+        ON CONFLICT (track_id) DO UPDATE SET file_id = excluded.file_id, created_at = CURRENT_TIMESTAMP`,
+		trackID, fileID)
+	if err != nil || appConfig.AudioCacheEntries == 0 {
+		return err
+	}
+
+	// Размер кэша ограничен настройкой audio_cache_entries - удаляем самые старые
+	_, err = db.Exec(`
+        DELETE FROM telegram_audio_cache WHERE track_id NOT IN (
+            SELECT track_id FROM telegram_audio_cache ORDER BY created_at DESC LIMIT ?)`,
+		appConfig.AudioCacheEntries)
+	return err
+}
+
+func deleteCachedAudioFileID(db *sql.DB, trackID int) error {
+	_, err := db.Exec("DELETE FROM telegram_audio_cache WHERE track_id = ?", trackID)
+	return err
+}
+
+// sendTrackButton - кнопка под сообщением, по которой бот присылает аудиофайл трека
+func sendTrackButton(text string, trackID int) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData(text, sendCallbackPrefix+strconv.Itoa(trackID))
+}
+
+// playlistKeyboard строит клавиатуру с кнопкой отправки для каждого трека плейлиста
+func playlistKeyboard(tracks []Track) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, track := range tracks {
+		if i >= maxTrackButtons {
+			break
+		}
+		text := fmt.Sprintf("▶ %d. %s - %s", i+1, track.Artist, track.Title)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(sendTrackButton(text, track.TrackID)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func handleSendCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	args := strings.TrimSpace(message.CommandArguments())
+	if args == "" {
+		return T(lang, "bot.send_usage")
+	}
+
+	trackID, err := extractTrackID(args)
+	if err != nil {
+		return T(lang, "bot.bad_track_format")
+	}
+
+	sendTrackAudioAsync(bot, message.Chat.ID, trackID, lang, cfg)
+	return ""
+}
+
+func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, cfg *Config) {
+	lang := telegramLang(query.From)
+
+	if query.Message == nil || !strings.HasPrefix(query.Data, sendCallbackPrefix) {
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	var userID int64
+	if query.From != nil {
+		userID = query.From.ID
+	}
+	role, err := resolveRole(cfg.Database, userID, query.Message.Chat.ID)
+	if err != nil {
+		log.Printf("Error resolving bot role: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, T(lang, "bot.access_check_error")))
+		return
+	}
+	if role < requiredRole("send") {
+		bot.Request(tgbotapi.NewCallback(query.ID, T(lang, "bot.forbidden")))
+		return
+	}
+
+	trackID, err := strconv.Atoi(strings.TrimPrefix(query.Data, sendCallbackPrefix))
+	if err != nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, T(lang, "bot.bad_track_id")))
+		return
+	}
+
+	// Отвечаем сразу, чтобы у кнопки не крутился индикатор загрузки
+	bot.Request(tgbotapi.NewCallback(query.ID, T(lang, "bot.sending")))
+
+	sendTrackAudioAsync(bot, query.Message.Chat.ID, trackID, lang, cfg)
+}
+
+// sendTrackAudioAsync отправляет трек в фоне: скачивание файла до 50 МБ не
+// должно задерживать команды в других чатах. Одновременно идет не больше
+// maxAudioSends отправок, остальные ждут очереди. Ошибка сообщается в чат.
+// Вызывается из цикла бота, который сам учтен в wg.
+func sendTrackAudioAsync(bot *tgbotapi.BotAPI, chatID int64, trackID int, lang string, cfg *Config) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case audioSendSlots <- struct{}{}:
+		case <-shutdownCh:
+			return
+		}
+		defer func() { <-audioSendSlots }()
+
+		// При остановке сервера прерываем скачивание
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-shutdownCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := sendTrackAudio(ctx, bot, chatID, trackID, lang, cfg); err != nil {
+			log.Printf("Error sending track %d audio: %v", trackID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, T(lang, "bot.send_error", err)))
+		}
+	}()
+}
+
+// sendTrackAudio отправляет трек в чат аудиосообщением. Если трек уже
+// загружался в Telegram, используется сохраненный file_id без повторной загрузки.
+func sendTrackAudio(ctx context.Context, bot *tgbotapi.BotAPI, chatID int64, trackID int, lang string, cfg *Config) error {
+	info, err := getTrackInfo(ctx, trackID, defaultClient())
+	if err != nil {
+		return err
+	}
+
+	fileID, err := getCachedAudioFileID(cfg.Database, trackID)
+	if err != nil {
+		log.Printf("Warning: failed to read audio cache: %v", err)
+	}
+	if fileID != "" {
+		_, err := bot.Send(newTrackAudio(chatID, tgbotapi.FileID(fileID), info))
+		if err == nil {
+			return nil
+		}
+		// file_id мог устареть - забываем его и загружаем файл заново
+		log.Printf("Cached file_id for track %d rejected: %v", trackID, err)
+		if err := deleteCachedAudioFileID(cfg.Database, trackID); err != nil {
+			log.Printf("Warning: failed to drop audio cache: %v", err)
+		}
+	}
+
+	bot.Request(tgbotapi.NewChatAction(chatID, tgbotapi.ChatUploadDocument))
+
+	data, err := downloadLimited(ctx, info.TrackURL, telegramUploadLimit)
+	if err == errTooLarge {
+		// Файл не пролезает в лимит Bot API - даем ссылку на страницу трека.
+		// Ссылка на скачивание подписана токеном аккаунта, в чат ее не пишем.
+		msg := tgbotapi.NewMessage(chatID, T(lang, "bot.audio_too_large", info.Artist, info.Title, yandexTrackURL(trackID)))
+		_, err = bot.Send(msg)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download track: %w", err)
+	}
+
+	audio := newTrackAudio(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("%s - %s.mp3", info.Artist, info.Title),
+		Bytes: data,
+	}, info)
+
+	// Обложка не обязательна - без нее трек все равно отправится
+	if info.CoverURI != "" {
+		thumb, err := downloadLimited(ctx, "https://"+info.CoverURI+telegramThumbSize, telegramThumbLimit)
+		if err != nil {
+			log.Printf("Warning: failed to download cover for track %d: %v", trackID, err)
+		} else {
+			audio.Thumb = tgbotapi.FileBytes{Name: "cover.jpg", Bytes: thumb}
+		}
+	}
+
+	sent, err := bot.Send(audio)
+	if err != nil {
+		return fmt.Errorf("failed to upload audio: %w", err)
+	}
+
+	if sent.Audio != nil && sent.Audio.FileID != "" {
+		if err := cacheAudioFileID(cfg.Database, trackID, sent.Audio.FileID); err != nil {
+			log.Printf("Warning: failed to cache audio file_id: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func newTrackAudio(chatID int64, file tgbotapi.RequestFileData, info *TrackInfo) tgbotapi.AudioConfig {
+	audio := tgbotapi.NewAudio(chatID, file)
+	audio.Title = info.Title
+	audio.Performer = info.Artist
+	audio.Duration = info.DurationMs / 1000
+	return audio
+}
+
+var errTooLarge = fmt.Errorf("file exceeds size limit")
+
+// downloadLimited скачивает файл целиком в память, но не больше limit байт
+func downloadLimited(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := audioHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, errTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+
+	return data, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// playerEvent - событие плеера, пришедшее от веб-клиента по WebSocket
+type playerEvent struct {
+	Type     string
+	RoomCode string
+	TrackID  int
+}
+
+const playerEventNowPlaying = "nowPlaying"
+
+// Канал событий плеера. Его читает Telegram-бот; если бот не запущен,
+// события отбрасываются, чтобы не блокировать WebSocket-обработчик.
+var (
+	playerEvents     = make(chan playerEvent, 32)
+	announcerRunning atomic.Bool // канал читает runNowPlayingAnnouncer
+)
+
+func publishPlayerEvent(event playerEvent) {
+	// Без бота событие некому прочитать: очередь заполнилась бы навсегда
+	if !announcerRunning.Load() {
+		return
+	}
+	select {
+	case playerEvents <- event:
+	default:
+		log.Printf("Player event queue is full, dropping %s for room %s", event.Type, event.RoomCode)
+	}
+}
+
+func createNowPlayingTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS telegram_now_playing (
+		chat_id INTEGER PRIMARY KEY,
+		room_code TEXT NOT NULL,
+		message_id INTEGER DEFAULT 0,
+		track_id INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	return addColumnIfNotExists(tx, "telegram_now_playing", "lang", "TEXT DEFAULT 'ru'")
+}
+
+type nowPlayingSubscription struct {
+	ChatID    int64
+	MessageID int
+	TrackID   int
+	Lang      string
+}
+
+func subscribeNowPlaying(db *sql.DB, chatID int64, roomCode, lang string) error {
+	// При смене комнаты начинаем с нового сообщения
+	_, err := db.Exec(`
+        INSERT INTO telegram_now_playing (chat_id, room_code, lang) VALUES (?, ?, ?)
+        ON CONFLICT (chat_id) DO UPDATE SET room_code = excluded.room_code, lang = excluded.lang, message_id = 0, track_id = 0`,
+		chatID, roomCode, lang)
+	return err
+}
+
+func unsubscribeNowPlaying(db *sql.DB, chatID int64) (bool, error) {
+	result, err := db.Exec("DELETE FROM telegram_now_playing WHERE chat_id = ?", chatID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func getNowPlayingSubscriptions(db *sql.DB, roomCode string) ([]nowPlayingSubscription, error) {
+	rows, err := db.Query("SELECT chat_id, message_id, track_id, lang FROM telegram_now_playing WHERE room_code = ?", roomCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []nowPlayingSubscription
+	for rows.Next() {
+		var sub nowPlayingSubscription
+		if err := rows.Scan(&sub.ChatID, &sub.MessageID, &sub.TrackID, &sub.Lang); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func updateNowPlayingMessage(db *sql.DB, chatID int64, messageID, trackID int) error {
+	_, err := db.Exec("UPDATE telegram_now_playing SET message_id = ?, track_id = ? WHERE chat_id = ?",
+		messageID, trackID, chatID)
+	return err
+}
+
+// handleNowPlayingCommand включает и выключает анонсы для чата:
+// "/nowplaying <код комнаты>" или "/nowplaying off".
+func handleNowPlayingCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	switch strings.ToLower(arg) {
+	case "":
+		return T(lang, "bot.nowplaying_usage")
+
+	case "off":
+		removed, err := unsubscribeNowPlaying(cfg.Database, message.Chat.ID)
+		if err != nil {
+			return T(lang, "bot.nowplaying_off_err", err)
+		}
+		if !removed {
+			return T(lang, "bot.nowplaying_inactive")
+		}
+		return T(lang, "bot.nowplaying_off")
+	}
+
+	roomCode := strings.ToUpper(arg)
+	exists, err := isExistRoomCode(roomCode)
+	if err != nil {
+		return T(lang, "bot.room_check_error", err)
+	}
+	if !exists {
+		return T(lang, "bot.room_not_found", roomCode)
+	}
+
+	if err := subscribeNowPlaying(cfg.Database, message.Chat.ID, roomCode, lang); err != nil {
+		return T(lang, "bot.nowplaying_on_err", err)
+	}
+
+	return T(lang, "bot.nowplaying_on", roomCode)
+}
+
+// runNowPlayingAnnouncer слушает события плеера и обновляет
+// сообщение "Сейчас играет" в подписанных чатах.
+func runNowPlayingAnnouncer(bot *tgbotapi.BotAPI, cfg *Config) {
+	defer wg.Done()
+
+	announcerRunning.Store(true)
+	defer announcerRunning.Store(false)
+
+	for {
+		var event playerEvent
+		select {
+		case <-shutdownCh:
+			return
+		case event = <-playerEvents:
+		}
+
+		if event.Type != playerEventNowPlaying {
+			continue
+		}
+
+		subs, err := getNowPlayingSubscriptions(cfg.Database, event.RoomCode)
+		if err != nil {
+			log.Printf("Error loading now playing subscriptions: %v", err)
+			continue
+		}
+		if len(subs) == 0 {
+			continue
+		}
+
+		info, err := getTrackInfo(context.Background(), event.TrackID, clientForRoomCode(event.RoomCode))
+		if err != nil {
+			log.Printf("Error getting track info for announcement: %v", err)
+			continue
+		}
+
+		for _, sub := range subs {
+			// Одну и ту же комнату могут слушать несколько клиентов -
+			// не трогаем сообщение, если трек не сменился
+			if sub.TrackID == event.TrackID {
+				continue
+			}
+			if err := announceNowPlaying(bot, cfg.Database, sub, info); err != nil {
+				log.Printf("Error announcing now playing to chat %d: %v", sub.ChatID, err)
+			}
+		}
+	}
+}
+
+func announceNowPlaying(bot *tgbotapi.BotAPI, db *sql.DB, sub nowPlayingSubscription, info *TrackInfo) error {
+	caption := T(sub.Lang, "bot.now_playing", info.Artist, info.Title)
+	var cover tgbotapi.RequestFileData
+	if info.CoverURI != "" {
+		cover = tgbotapi.FileURL("https://" + info.CoverURI + "400x400")
+	}
+
+	// Сначала пробуем отредактировать прошлое сообщение, чтобы не засорять чат
+	if sub.MessageID != 0 {
+		var edits []tgbotapi.Chattable
+		if cover != nil {
+			media := tgbotapi.NewInputMediaPhoto(cover)
+			media.Caption = caption
+			edits = append(edits, tgbotapi.EditMessageMediaConfig{
+				BaseEdit: tgbotapi.BaseEdit{ChatID: sub.ChatID, MessageID: sub.MessageID},
+				Media:    media,
+			})
+		} else {
+			// Без обложки прошлое сообщение может быть как фото, так и текстом
+			edits = append(edits,
+				tgbotapi.NewEditMessageCaption(sub.ChatID, sub.MessageID, caption),
+				tgbotapi.NewEditMessageText(sub.ChatID, sub.MessageID, caption))
+		}
+
+		for _, edit := range edits {
+			if _, err := bot.Request(edit); err == nil {
+				return updateNowPlayingMessage(db, sub.ChatID, sub.MessageID, info.TrackID)
+			}
+		}
+		// Сообщение могли удалить - отправим новое
+		log.Printf("Failed to edit now playing message %d in chat %d, sending a new one", sub.MessageID, sub.ChatID)
+	}
+
+	var msg tgbotapi.Chattable
+	if cover != nil {
+		photo := tgbotapi.NewPhoto(sub.ChatID, cover)
+		photo.Caption = caption
+		photo.DisableNotification = true
+		msg = photo
+	} else {
+		text := tgbotapi.NewMessage(sub.ChatID, caption)
+		text.DisableNotification = true
+		msg = text
+	}
+
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	return updateNowPlayingMessage(db, sub.ChatID, sent.MessageID, info.TrackID)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Ссылки, которые можно добавить в плейлист: трек, альбом целиком,
+// популярные треки исполнителя и плейлист пользователя
+const (
+	musicLinkTrack    = "track"
+	musicLinkAlbum    = "album"
+	musicLinkArtist   = "artist"
+	musicLinkPlaylist = "playlist"
+)
+
+// Причины, по которым трек из альбома или плейлиста не добавлен
+const (
+	skipDuplicate   = "duplicate"
+	skipUnavailable = "unavailable"
+)
+
+var (
+	albumLinkRe    = regexp.MustCompile(`/album/(\d+)`)
+	artistLinkRe   = regexp.MustCompile(`/artist/(\d+)`)
+	playlistLinkRe = regexp.MustCompile(`/users/([^/?#\s]+)/playlists/(\d+)`)
+)
+
+type musicLink struct {
+	Kind  string
+	ID    int    // ID трека, альбома, исполнителя или номер (kind) плейлиста
+	Owner string // логин или UID владельца плейлиста
+}
+
+// parseMusicLink распознает ссылку Яндекс.Музыки или ID трека.
+// Ссылка на трек внутри альбома (/album/1/track/2) - это трек.
+func parseMusicLink(input string) (*musicLink, error) {
+	if trackID, err := extractTrackID(input); err == nil {
+		return &musicLink{Kind: musicLinkTrack, ID: trackID}, nil
+	}
+
+	if m := playlistLinkRe.FindStringSubmatch(input); m != nil {
+		kind, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return &musicLink{Kind: musicLinkPlaylist, ID: kind, Owner: m[1]}, nil
+	}
+	patterns := []struct {
+		kind string
+		re   *regexp.Regexp
+	}{
+		{musicLinkAlbum, albumLinkRe},
+		{musicLinkArtist, artistLinkRe},
+	}
+	for _, p := range patterns {
+		if m := p.re.FindStringSubmatch(input); m != nil {
+			id, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, err
+			}
+			return &musicLink{Kind: p.kind, ID: id}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported music link: %s", input)
+}
+
+// expandMusicLink возвращает название и треки альбома, исполнителя или плейлиста
+func expandMusicLink(ctx context.Context, api *yandexAPI, link *musicLink) (string, []yandexTrack, error) {
+	switch link.Kind {
+	case musicLinkAlbum:
+		return api.Album(ctx, link.ID)
+	case musicLinkArtist:
+		return api.ArtistTopTracks(ctx, link.ID)
+	case musicLinkPlaylist:
+		playlist, err := api.Playlist(ctx, link.Owner, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		return playlist.Title, playlist.TrackList(), nil
+	default:
+		return "", nil, fmt.Errorf("link kind %q has no track list", link.Kind)
+	}
+}
+
+type bulkAddTrack struct {
+	TrackID int    `json:"track_id"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// bulkAddResult - итог добавления альбома, исполнителя или плейлиста
+type bulkAddResult struct {
+	Title   string         `json:"title"`
+	Added   []bulkAddTrack `json:"added"`
+	Skipped []bulkAddTrack `json:"skipped"`
+}
+
+// skippedBy - число пропущенных треков по причине
+func (res *bulkAddResult) skippedBy(reason string) int {
+	n := 0
+	for _, t := range res.Skipped {
+		if t.Reason == reason {
+			n++
+		}
+	}
+	return n
+}
+
+// bulkInsertTracks добавляет треки в конец плейлиста комнаты одной
+// транзакцией. Треки, которые уже есть в плейлисте (или повторяются в
+// самом списке) и недоступные для прослушивания, пропускаются.
+func bulkInsertTracks(ctx context.Context, roomID int, title string, tracks []yandexTrack, addedBy, source string) (*bulkAddResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := bulkInsertTracksTx(ctx, tx, roomID, title, tracks, addedBy, source, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// bulkInsertTracksTx - то же внутри транзакции вызывающего. importID
+// помечает треки, добавленные синхронизацией (0 - без пометки). С roomOnly
+// дубликатом считается только трек, который уже есть в этой комнате: импорт
+// плейлиста переносит его целиком, даже если трек стоит в других комнатах.
+func bulkInsertTracksTx(ctx context.Context, tx *sql.Tx, roomID int, title string, tracks []yandexTrack, addedBy, source string, importID int, roomOnly bool) (*bulkAddResult, error) {
+	res := &bulkAddResult{Title: title, Added: []bulkAddTrack{}, Skipped: []bulkAddTrack{}}
+
+	seen := make(map[int]bool)
+	for _, track := range tracks {
+		entry := bulkAddTrack{TrackID: int(track.ID), Title: track.Title, Artist: track.ArtistName()}
+		if entry.TrackID == 0 || !track.IsAvailable() {
+			entry.Reason = skipUnavailable
+			res.Skipped = append(res.Skipped, entry)
+			continue
+		}
+
+		// Как и при добавлении по одному, трек может быть только в одном плейлисте
+		exists := seen[entry.TrackID]
+		if !exists {
+			query, args := "SELECT EXISTS(SELECT 1 FROM playlist WHERE track_id = ?)", []interface{}{entry.TrackID}
+			if roomOnly {
+				query, args = "SELECT EXISTS(SELECT 1 FROM playlist WHERE track_id = ? AND room_id = ?)", append(args, roomID)
+			}
+			if err := tx.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+				return nil, err
+			}
+		}
+		seen[entry.TrackID] = true
+		if exists {
+			entry.Reason = skipDuplicate
+			res.Skipped = append(res.Skipped, entry)
+			continue
+		}
+
+		if err := insertPlaylistTrack(ctx, tx, roomID, &track, addedBy, source, importID); err != nil {
+			return nil, err
+		}
+		res.Added = append(res.Added, entry)
+	}
+	return res, nil
+}
+
+// insertPlaylistTrack добавляет трек в конец плейлиста комнаты вместе с
+// названием и исполнителем, чтобы по ним сразу работали фильтры
+func insertPlaylistTrack(ctx context.Context, tx *sql.Tx, roomID int, track *yandexTrack, addedBy, source string, importID int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO playlist (track_id, room_id, position, added_by, source, title, artist, duration_ms, import_id)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist WHERE room_id = ?), NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, 0))`,
+		int(track.ID), roomID, roomID, addedBy, source, track.Title, track.ArtistName(), track.DurationMs, importID)
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseMusicLink(t *testing.T) {
+	tests := []struct {
+		input string
+		want  *musicLink // nil - ссылка не распознается
+	}{
+		{"12345", &musicLink{Kind: musicLinkTrack, ID: 12345}},
+		{" 12345 ", &musicLink{Kind: musicLinkTrack, ID: 12345}},
+		{"https://music.yandex.ru/track/777", &musicLink{Kind: musicLinkTrack, ID: 777}},
+		{"https://music.yandex.ru/album/10/track/20", &musicLink{Kind: musicLinkTrack, ID: 20}},
+		{"https://music.yandex.ru/album/10/track/20?utm_source=share", &musicLink{Kind: musicLinkTrack, ID: 20}},
+		{"https://music.yandex.ru/album/10", &musicLink{Kind: musicLinkAlbum, ID: 10}},
+		{"https://music.yandex.com/album/10?from=search", &musicLink{Kind: musicLinkAlbum, ID: 10}},
+		{"https://music.yandex.ru/artist/36800", &musicLink{Kind: musicLinkArtist, ID: 36800}},
+		{"https://music.yandex.ru/artist/36800/tracks", &musicLink{Kind: musicLinkArtist, ID: 36800}},
+		{"https://music.yandex.ru/users/music-blog/playlists/2567", &musicLink{Kind: musicLinkPlaylist, ID: 2567, Owner: "music-blog"}},
+		{"https://music.yandex.ru/users/123456/playlists/3?utm=x", &musicLink{Kind: musicLinkPlaylist, ID: 3, Owner: "123456"}},
+		{"", nil},
+		{"hello", nil},
+		{"https://music.yandex.ru/", nil},
+		{"https://music.yandex.ru/users/someone", nil},
+		{"https://music.yandex.ru/album/abc", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseMusicLink(tt.input)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseMusicLink(%q) = %+v, want error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMusicLink(%q) error: %v", tt.input, err)
+			continue
+		}
+		if *got != *tt.want {
+			t.Errorf("parseMusicLink(%q) = %+v, want %+v", tt.input, *got, *tt.want)
+		}
+	}
+}
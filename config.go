@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// ServerConfig - настройки сервера. Источники применяются по очереди, каждый
+// следующий перекрывает предыдущий: значения по умолчанию, файл конфигурации
+// (JSON), файл .env, переменные окружения, флаги командной строки.
+type ServerConfig struct {
+	Listen              string `json:"listen"`
+	DBPath              string `json:"db_path"`
+	TemplateDir         string `json:"template_dir"`
+	StaticDir           string `json:"static_dir"`
+	TLSCert             string `json:"tls_cert"`
+	TLSKey              string `json:"tls_key"`
+	HTTPRedirect        string `json:"http_redirect_listen"` // адрес HTTP, перенаправляющего на HTTPS
+	TelegramToken       string `json:"telegram_token"`
+	TelegramOwnerID     int64  `json:"telegram_owner_id"`
+	TelegramPollTimeout int    `json:"telegram_poll_timeout"` // секунды long polling
+	AudioCacheEntries   int    `json:"audio_cache_entries"`   // file_id аудио в Telegram, 0 - без ограничения
+	ShutdownTimeout     int    `json:"shutdown_timeout"`      // секунды на штатную остановку
+	PublicURL           string `json:"public_url"`            // внешний адрес для ссылок в файлах плейлистов
+}
+
+// appConfig - действующая конфигурация, загружается в начале main
+var appConfig = defaultServerConfig()
+
+func defaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Listen:              ":8080",
+		DBPath:              "settings.db",
+		TemplateDir:         "web",
+		StaticDir:           "static",
+		TelegramPollTimeout: 60,
+		ShutdownTimeout:     15,
+	}
+}
+
+// configOption связывает поле конфигурации с переменной окружения и флагом
+type configOption struct {
+	name   string // ключ в файле конфигурации
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  func(c *ServerConfig) interface{} // указатель на поле
+}
+
+var configOptions = []configOption{
+	{"listen", "LISTEN_ADDR", "listen", "address to listen on, e.g. :8080", false,
+		func(c *ServerConfig) interface{} { return &c.Listen }},
+	{"db_path", "DB_PATH", "db", "path to the SQLite database", false,
+		func(c *ServerConfig) interface{} { return &c.DBPath }},
+	{"template_dir", "TEMPLATE_DIR", "templates", "directory with HTML templates", false,
+		func(c *ServerConfig) interface{} { return &c.TemplateDir }},
+	{"static_dir", "STATIC_DIR", "static", "directory with static files", false,
+		func(c *ServerConfig) interface{} { return &c.StaticDir }},
+	{"tls_cert", "SSL_CERT", "tls-cert", "TLS certificate file", false,
+		func(c *ServerConfig) interface{} { return &c.TLSCert }},
+	{"tls_key", "SSL_KEY", "tls-key", "TLS private key file", false,
+		func(c *ServerConfig) interface{} { return &c.TLSKey }},
+	{"http_redirect_listen", "HTTP_REDIRECT_ADDR", "http-redirect", "plain HTTP address redirecting to HTTPS, e.g. :80", false,
+		func(c *ServerConfig) interface{} { return &c.HTTPRedirect }},
+	{"telegram_token", "TELEGRAM_TOKEN", "telegram-token", "Telegram bot token, empty disables the bot", true,
+		func(c *ServerConfig) interface{} { return &c.TelegramToken }},
+	{"telegram_owner_id", "TELEGRAM_OWNER_ID", "telegram-owner", "Telegram ID of the bot owner", false,
+		func(c *ServerConfig) interface{} { return &c.TelegramOwnerID }},
+	{"telegram_poll_timeout", "TELEGRAM_POLL_TIMEOUT", "telegram-poll-timeout", "Telegram long polling timeout in seconds", false,
+		func(c *ServerConfig) interface{} { return &c.TelegramPollTimeout }},
+	{"audio_cache_entries", "AUDIO_CACHE_ENTRIES", "audio-cache", "max cached Telegram audio file IDs, 0 for unlimited", false,
+		func(c *ServerConfig) interface{} { return &c.AudioCacheEntries }},
+	{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "seconds to wait for a graceful shutdown", false,
+		func(c *ServerConfig) interface{} { return &c.ShutdownTimeout }},
+	{"public_url", "PUBLIC_URL", "public-url", "external server URL for links in playlist files, e.g. https://music.example.com", false,
+		func(c *ServerConfig) interface{} { return &c.PublicURL }},
+}
+
+// setConfigValue записывает строковое значение в поле нужного типа
+func setConfigValue(ptr interface{}, value string) error {
+	value = strings.TrimSpace(value)
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = n
+	default:
+		return fmt.Errorf("unsupported config field type %T", ptr)
+	}
+	return nil
+}
+
+// loadServerConfig собирает конфигурацию из всех источников и проверяет ее
+func loadServerConfig(args []string) (*ServerConfig, error) {
+	fs := flag.NewFlagSet("musicdirect", flag.ContinueOnError)
+	configFile := fs.String("config", "", "JSON config file (default config.json, if present)")
+	envFile := fs.String("env-file", ".env", "file with environment variables")
+	flagValues := make(map[string]*string)
+	for _, opt := range configOptions {
+		flagValues[opt.flag] = fs.String(opt.flag, "", opt.usage+" (env "+opt.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultServerConfig()
+
+	// Файл конфигурации. Файл по умолчанию необязателен, указанный явно - обязателен.
+	path, explicit := *configFile, *configFile != ""
+	if !explicit {
+		path = os.Getenv("CONFIG_FILE")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = "config.json"
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	// .env не перекрывает уже заданные переменные окружения, поэтому после
+	// загрузки в окружении лежат значения обоих слоев с нужным приоритетом.
+	// Из .env читаются и переменные, которые не входят в ServerConfig (SECRET_KEY).
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", *envFile, err)
+	}
+
+	// Переменные окружения, затем флаги, заданные явно
+	for _, opt := range configOptions {
+		if value, ok := os.LookupEnv(opt.env); ok && value != "" {
+			if err := setConfigValue(opt.value(cfg), value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", opt.env, err)
+			}
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		value, ok := flagValues[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, opt := range configOptions {
+			if opt.flag == f.Name {
+				if err := setConfigValue(opt.value(cfg), *value); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate проверяет конфигурацию целиком и возвращает все ошибки сразу
+func (c *ServerConfig) Validate() error {
+	var errs []error
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		errs = append(errs, fmt.Errorf("listen: invalid port %q", port))
+	}
+
+	if strings.TrimSpace(c.DBPath) == "" {
+		errs = append(errs, errors.New("db_path must not be empty"))
+	}
+	for name, dir := range map[string]string{"template_dir": c.TemplateDir, "static_dir": c.StaticDir} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", name, dir))
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	for name, file := range map[string]string{"tls_cert": c.TLSCert, "tls_key": c.TLSKey} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	if c.HTTPRedirect != "" {
+		if c.TLSCert == "" {
+			errs = append(errs, errors.New("http_redirect_listen requires tls_cert and tls_key"))
+		}
+		if _, _, err := net.SplitHostPort(c.HTTPRedirect); err != nil {
+			errs = append(errs, fmt.Errorf("http_redirect_listen: %w", err))
+		}
+	}
+
+	if c.TelegramOwnerID < 0 {
+		errs = append(errs, errors.New("telegram_owner_id must not be negative"))
+	}
+	if c.TelegramPollTimeout <= 0 {
+		errs = append(errs, errors.New("telegram_poll_timeout must be positive"))
+	}
+	if c.AudioCacheEntries < 0 {
+		errs = append(errs, errors.New("audio_cache_entries must not be negative"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("public_url must be an absolute http or https URL"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted возвращает действующие значения для страницы /debug, секреты скрыты
+func (c *ServerConfig) Redacted() map[string]string {
+	values := make(map[string]string, len(configOptions))
+	for _, opt := range configOptions {
+		var value string
+		switch p := opt.value(c).(type) {
+		case *string:
+			value = *p
+		case *int:
+			value = strconv.Itoa(*p)
+		case *int64:
+			value = strconv.FormatInt(*p, 10)
+		}
+		if opt.secret && value != "" {
+			value = "[REDACTED]"
+		}
+		values[opt.name] = value
+	}
+	return values
+}
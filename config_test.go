@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearConfigEnv убирает переменные конфигурации на время теста.
+// .env записывает значения прямо в окружение, поэтому после теста
+// переменные восстанавливаются или удаляются.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG_FILE"}
+	for _, opt := range configOptions {
+		names = append(names, opt.env)
+	}
+	for _, name := range names {
+		old, had := os.LookupEnv(name)
+		os.Unsetenv(name)
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Каждый следующий источник перекрывает предыдущий:
+// значения по умолчанию, файл конфигурации, .env, окружение, флаги
+func TestLoadServerConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		dotenv  string
+		env     string
+		flag    string
+		want    string
+		wantDB  string
+		wantTTL int
+	}{
+		{name: "defaults", want: ":8080", wantDB: "settings.db", wantTTL: 15},
+		{name: "config file", file: ":7000", want: ":7000", wantDB: "file.db", wantTTL: 30},
+		{name: ".env over file", file: ":7000", dotenv: ":7100", want: ":7100", wantDB: "file.db", wantTTL: 30},
+		{name: "env over .env", file: ":7000", dotenv: ":7100", env: ":7200", want: ":7200", wantDB: "file.db", wantTTL: 30},
+		{name: "flag over env", file: ":7000", dotenv: ":7100", env: ":7200", flag: ":7300", want: ":7300", wantDB: "file.db", wantTTL: 30},
+		{name: "flag without file", flag: ":7300", want: ":7300", wantDB: "settings.db", wantTTL: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			dir := t.TempDir()
+
+			configJSON := "{}"
+			if tt.file != "" {
+				configJSON = `{"listen": "` + tt.file + `", "db_path": "file.db", "shutdown_timeout": 30}`
+			}
+			envFile := filepath.Join(dir, "missing.env")
+			if tt.dotenv != "" {
+				envFile = writeFile(t, dir, ".env", "LISTEN_ADDR="+tt.dotenv+"\n")
+			}
+			args := []string{"-config", writeFile(t, dir, "config.json", configJSON), "-env-file", envFile}
+			if tt.env != "" {
+				os.Setenv("LISTEN_ADDR", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-listen", tt.flag)
+			}
+
+			cfg, err := loadServerConfig(args)
+			if err != nil {
+				t.Fatalf("loadServerConfig: %v", err)
+			}
+			if cfg.Listen != tt.want {
+				t.Errorf("Listen = %q, want %q", cfg.Listen, tt.want)
+			}
+			// Слои выше не трогают значения, которых в них нет
+			if cfg.DBPath != tt.wantDB {
+				t.Errorf("DBPath = %q, want %q", cfg.DBPath, tt.wantDB)
+			}
+			if cfg.ShutdownTimeout != tt.wantTTL {
+				t.Errorf("ShutdownTimeout = %d, want %d", cfg.ShutdownTimeout, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    func(dir string) []string
+		wantErr string
+	}{
+		{"missing explicit config", func(dir string) []string {
+			return []string{"-config", filepath.Join(dir, "nope.json")}
+		}, "failed to read config file"},
+		{"invalid json", func(dir string) []string {
+			return []string{"-config", writeFile(t, dir, "bad.json", "{")}
+		}, "invalid config file"},
+		{"invalid int flag", func(dir string) []string {
+			return []string{"-config", writeFile(t, dir, "c.json", "{}"), "-shutdown-timeout", "soon"}
+		}, "invalid -shutdown-timeout"},
+		{"validation", func(dir string) []string {
+			return []string{"-config", writeFile(t, dir, "c.json", "{}"), "-listen", "nope"}
+		}, "listen:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			dir := t.TempDir()
+			args := append(tt.args(dir), "-env-file", filepath.Join(dir, "missing.env"))
+			_, err := loadServerConfig(args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadServerConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	cert := writeFile(t, dir, "cert.pem", "cert")
+	key := writeFile(t, dir, "key.pem", "key")
+
+	tests := []struct {
+		name    string
+		change  func(c *ServerConfig)
+		wantErr string // пусто - конфигурация верна
+	}{
+		{"defaults", func(c *ServerConfig) {}, ""},
+		{"listen with host", func(c *ServerConfig) { c.Listen = "127.0.0.1:9000" }, ""},
+		{"listen without port", func(c *ServerConfig) { c.Listen = "8080" }, "listen:"},
+		{"listen bad port", func(c *ServerConfig) { c.Listen = ":99999" }, "invalid port"},
+		{"empty db", func(c *ServerConfig) { c.DBPath = " " }, "db_path"},
+		{"missing templates", func(c *ServerConfig) { c.TemplateDir = filepath.Join(dir, "none") }, "template_dir"},
+		{"static is a file", func(c *ServerConfig) { c.StaticDir = cert }, "static_dir"},
+		{"tls pair", func(c *ServerConfig) { c.TLSCert, c.TLSKey = cert, key }, ""},
+		{"tls cert only", func(c *ServerConfig) { c.TLSCert = cert }, "must be set together"},
+		{"tls missing file", func(c *ServerConfig) { c.TLSCert, c.TLSKey = cert, filepath.Join(dir, "none.pem") }, "tls_key"},
+		{"redirect with tls", func(c *ServerConfig) { c.TLSCert, c.TLSKey, c.HTTPRedirect = cert, key, ":80" }, ""},
+		{"redirect without tls", func(c *ServerConfig) { c.HTTPRedirect = ":80" }, "requires tls_cert"},
+		{"negative owner", func(c *ServerConfig) { c.TelegramOwnerID = -1 }, "telegram_owner_id"},
+		{"zero poll timeout", func(c *ServerConfig) { c.TelegramPollTimeout = 0 }, "telegram_poll_timeout"},
+		{"negative cache", func(c *ServerConfig) { c.AudioCacheEntries = -1 }, "audio_cache_entries"},
+		{"zero shutdown", func(c *ServerConfig) { c.ShutdownTimeout = 0 }, "shutdown_timeout"},
+		{"public url", func(c *ServerConfig) { c.PublicURL = "https://music.example.com" }, ""},
+		{"relative public url", func(c *ServerConfig) { c.PublicURL = "/music" }, "public_url"},
+		{"ftp public url", func(c *ServerConfig) { c.PublicURL = "ftp://example.com" }, "public_url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultServerConfig()
+			tt.change(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Все ошибки возвращаются сразу, а не по одной
+func TestServerConfigValidateJoinsErrors(t *testing.T) {
+	c := defaultServerConfig()
+	c.DBPath = ""
+	c.ShutdownTimeout = 0
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want errors")
+	}
+	for _, want := range []string{"db_path", "shutdown_timeout"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultLang    = "ru"
+	langCookieName = "lang"
+)
+
+// Каталог сообщений: язык -> ключ -> шаблон для fmt.Sprintf.
+// Если ключа нет в выбранном языке, берется язык по умолчанию.
+var catalogs = map[string]map[string]string{
+	"ru": {
+		// Telegram-бот
+		"bot.start": "Привет! Я бот для управления вашим плейлистом. Доступные команды:\n" +
+			"/playlist - показать текущий плейлист\n" +
+			"/send <ссылка> - прислать трек аудиофайлом\n" +
+			"/help - показать справку\n" +
+			"Также вы можете отправить мне ссылку на трек Яндекс.Музыки для добавления",
+		"bot.help": "Доступные команды:\n" +
+			"/playlist - показать текущий плейлист\n" +
+			"/send <ссылка или ID> - прислать трек аудиофайлом\n" +
+			"/help - показать эту справку\n\n" +
+			"/next - переключиться на следующий трек\n" +
+			"/prev - переключиться на предыдущий трек\n" +
+			"/now - показать текущий трек\n" +
+			"/pause - поставить текущий трек на паузу\n" +
+			"/nowplaying <код комнаты|off> - анонсы текущего трека в этом чате\n" +
+			"/join <код комнаты> - войти в комнату (будет доступна в Mini App)\n" +
+			"/import [id] [append|replace|sync] - импорт плейлистов Яндекс.Музыки\n\n" +
+			"/grant [chat] <id> <owner|dj|listener> - выдать роль (только владелец)\n" +
+			"/revoke [chat] <id> - отозвать доступ (только владелец)\n" +
+			"/export [номер плейлиста] - сохранить плейлист в Яндекс.Музыку (только владелец)\n\n" +
+			"Для добавления трека отправьте ссылку на него с Яндекс.Музыки\n" +
+			"Для удаления трека используйте кнопку удаления в списке плейлиста",
+		"bot.next":                "Переключение на следующий трек",
+		"bot.now":                 "Показать текущий трек",
+		"bot.prev":                "Переключение на предыдущий трек",
+		"bot.pause":               "Пауза",
+		"bot.playlist_error":      "Ошибка при получении плейлиста: %s",
+		"bot.playlist_empty":      "Плейлист пуст",
+		"bot.playlist_header":     "Ваш плейлист:\n\n",
+		"bot.notification":        "Новая команда от Telegram-бота: %s",
+		"bot.notification_sent":   "Уведомление отправлено на фронтенд",
+		"bot.unknown_command":     "Неизвестная команда. Используйте /help для просмотра доступных команд",
+		"bot.access_check_error":  "Ошибка при проверке доступа",
+		"bot.forbidden_command":   "Недостаточно прав для этой команды",
+		"bot.forbidden_add":       "Недостаточно прав для добавления треков",
+		"bot.forbidden":           "Недостаточно прав",
+		"bot.bad_track_format":    "Неверный формат. Отправьте ссылку на трек, альбом, исполнителя или плейлист либо ID трека",
+		"bot.no_account":          "Аккаунт Яндекс.Музыки не подключен",
+		"bot.link_not_found":      "Альбом, исполнитель или плейлист не найден",
+		"bot.bulk_added":          "%s\nДобавлено: %d\nУже в плейлисте: %d\nНедоступны: %d",
+		"bot.track_check_error":   "Ошибка при проверке трека",
+		"bot.track_exists":        "Этот трек уже есть в плейлисте",
+		"bot.track_info_error":    "Ошибка при получении информации о треке",
+		"bot.track_add_error":     "Ошибка при добавлении трека",
+		"bot.track_added":         "Трек добавлен в плейлист:\n%s - %s",
+		"bot.send_audio_button":   "▶ Прислать аудио",
+		"bot.grant_usage":         "Использование: /grant <id> <owner|dj|listener> или /grant chat <id> <dj|listener>",
+		"bot.grant_error":         "Ошибка при выдаче доступа: %s",
+		"bot.granted":             "Роль %s выдана: %s %d",
+		"bot.revoke_usage":        "Использование: /revoke [chat] <id>",
+		"bot.access_error":        "Ошибка при проверке доступа: %s",
+		"bot.last_owner":          "Нельзя отозвать доступ или понизить роль последнего владельца",
+		"bot.chat_owner":          "Чату можно выдать только роль dj или listener",
+		"bot.config_owner":        "Этот владелец задан в telegram_owner_id: чтобы отозвать доступ или понизить роль, измените конфигурацию",
+		"bot.revoke_error":        "Ошибка при отзыве доступа: %s",
+		"bot.revoke_not_found":    "%s %d не найден в списке доступа",
+		"bot.revoked":             "Доступ отозван: %s %d",
+		"bot.send_usage":          "Использование: /send <ссылка на трек или его ID>",
+		"bot.send_error":          "Ошибка при отправке трека: %s",
+		"bot.bad_track_id":        "Неверный ID трека",
+		"bot.sending":             "Отправляю трек...",
+		"bot.audio_too_large":     "%s - %s\nФайл больше 50 МБ и не может быть отправлен ботом. Ссылка для прослушивания:\n%s",
+		"bot.nowplaying_usage":    "Использование: /nowplaying <код комнаты> или /nowplaying off",
+		"bot.nowplaying_off_err":  "Ошибка при отключении анонсов: %s",
+		"bot.nowplaying_inactive": "Анонсы для этого чата не были включены",
+		"bot.nowplaying_off":      "Анонсы отключены",
+		"bot.nowplaying_on_err":   "Ошибка при включении анонсов: %s",
+		"bot.nowplaying_on":       "Анонсы для комнаты %s включены",
+		"bot.now_playing":         "Сейчас играет: %s – %s",
+		"bot.room_check_error":    "Ошибка при проверке комнаты: %s",
+		"bot.room_not_found":      "Комната %s не найдена",
+		"bot.join_usage":          "Использование: /join <код комнаты>",
+		"bot.unknown_user":        "Не удалось определить пользователя",
+		"bot.join_error":          "Ошибка при входе в комнату: %s",
+		"bot.joined":              "Вы участник комнаты %s",
+		"bot.room_kicked":         "Вы исключены из комнаты %s",
+		"bot.liked_tracks":        "Мне нравится",
+		"bot.import_usage":        "Использование: /import <id> [append|replace|sync] или /import stop <id>",
+		"bot.import_error":        "Ошибка при импорте: %s",
+		"bot.imported":            "%s\nДобавлено: %d\nУдалено: %d\nУже в плейлисте: %d\nНедоступны: %d",
+		"bot.sync_on":             "Плейлист будет синхронизироваться каждые %d мин.",
+		"bot.sync_off":            "Синхронизация остановлена",
+		"bot.sync_not_found":      "Синхронизация этого плейлиста не включена",
+		"bot.export_usage":        "Использование: /export или /export <номер плейлиста в Яндекс.Музыке>",
+		"bot.export_error":        "Ошибка при сохранении в Яндекс.Музыку: %s",
+		"bot.export_conflict":     "Плейлист в Яндекс.Музыке изменился во время сохранения, попробуйте еще раз",
+		"bot.exported":            "Плейлист «%s» сохранен, треков: %d\n%s",
+		"bot.export_failed":       "Не удалось добавить:",
+		"bot.import_list": "Плейлисты Яндекс.Музыки:\n\n%s\n" +
+			"/import <id> [append|replace|sync] - добавить в конец, заменить плейлист или синхронизировать\n" +
+			"/import stop <id> - остановить синхронизацию",
+
+		// Ответы HTTP
+		"http.service_unavailable":  "Сервис недоступен",
+		"http.internal_error":       "Внутренняя ошибка сервера",
+		"http.database_error":       "Ошибка базы данных",
+		"http.invalid_method":       "Недопустимый метод запроса",
+		"http.invalid_request":      "Некорректные данные запроса",
+		"http.forbidden":            "Доступ запрещен",
+		"http.fetch_playlist":       "Ошибка при получении плейлиста",
+		"http.save_settings":        "Ошибка при сохранении настроек",
+		"http.account_status":       "Ошибка при получении данных аккаунта",
+		"http.bot_not_configured":   "Telegram-бот не настроен",
+		"http.invalid_init_data":    "Некорректные данные Mini App",
+		"http.track_id_required":    "Необходимо указать ID трека",
+		"http.invalid_track_id":     "Некорректный ID трека",
+		"http.invalid_track_url":    "Некорректная ссылка: нужна ссылка на трек, альбом, исполнителя или плейлист",
+		"http.link_not_found":       "Альбом, исполнитель или плейлист не найден",
+		"http.bulk_added":           "Добавлено треков: %d, пропущено: %d",
+		"http.track_exists":         "Трек уже есть в плейлисте",
+		"http.track_added":          "Трек успешно добавлен",
+		"http.track_not_found":      "Трек не найден в плейлисте",
+		"http.not_found":            "Не найдено",
+		"http.invalid_query":        "Неверные параметры фильтра, сортировки или страницы",
+		"http.invalid_cursor":       "Курсор страницы устарел или не подходит к запросу",
+		"http.track_deleted":        "Трек удален из плейлиста",
+		"http.track_info":           "Ошибка при получении информации о треке",
+		"http.track_data":           "Ошибка при получении данных трека",
+		"http.download_url":         "Ошибка при получении ссылки на трек",
+		"http.update_position":      "Ошибка при изменении позиции трека",
+		"http.encode_response":      "Ошибка при формировании ответа",
+		"http.template":             "Ошибка шаблона",
+		"http.template_render":      "Ошибка отрисовки шаблона",
+		"http.room_not_found":       "Комната не найдена",
+		"http.room_id_required":     "Необходимо указать ID комнаты",
+		"http.invalid_room_id":      "Некорректный ID комнаты",
+		"http.room_check":           "Ошибка при проверке комнаты",
+		"http.create_room":          "Ошибка при создании комнаты",
+		"http.room_tracks":          "Ошибка при получении треков комнаты",
+		"http.unsupported_language": "Язык не поддерживается",
+		"http.unauthorized":         "Требуется вход",
+		"http.totp_required":        "Для этого действия нужно включить двухфакторную аутентификацию",
+		"http.insufficient_scope":   "У токена нет прав на это действие",
+		"http.token_create_error":   "Не удалось создать токен",
+		"http.token_name_required":  "Укажите название токена",
+		"http.unknown_scope":        "Неизвестное право токена",
+		"http.admin_scope":          "Право admin может выдать только администратор",
+		"http.token_not_found":      "Токен не найден",
+		"http.room_code_required":   "Не указан код комнаты",
+		"http.room_forbidden":       "Ваша роль в комнате не позволяет это сделать",
+		"http.room_kicked":          "Вы исключены из этой комнаты",
+		"http.room_change_self":     "Нельзя изменить собственную роль",
+		"http.creator_protected":    "Роль создателя комнаты может изменить только администратор",
+		"http.member_not_found":     "Участник не найден в комнате",
+		"http.account_not_found":    "Аккаунт Яндекс.Музыки не найден",
+		"http.liked_tracks":         "Мне нравится",
+		"http.yandex_error":         "Ошибка при запросе к Яндекс.Музыке",
+		"http.invalid_import":       "Укажите плейлист и режим: append, replace или sync",
+		"http.import_not_found":     "Синхронизация не найдена",
+		"http.export_empty":         "В плейлисте комнаты нет треков",
+		"http.export_conflict":      "Плейлист в Яндекс.Музыке изменился во время сохранения, попробуйте еще раз",
+		"http.playlist_not_found":   "Плейлист не найден",
+		"http.invalid_playlist":     "Нужно название плейлиста; название, описание или обложка слишком длинные",
+		"http.invalid_format":       "Формат файла: m3u8, xspf или jspf",
+		"http.invalid_ttl":          "Срок ссылок - от 1 до 168 часов",
+		"http.upload_not_found":     "Загруженный файл не найден или устарел",
+		"http.upload_too_large":     "Файл больше 2 МБ или в нем больше 1000 треков",
+		"http.invalid_upload_file":  "Не удалось прочитать файл: нужен плейлист M3U, XSPF, JSPF или CSV",
+		"http.empty_upload_file":    "В файле нет треков",
+
+		// Веб-страницы
+		"page.menu":               "Меню",
+		"page.home":               "Главная",
+		"page.player":             "Плеер",
+		"page.debug":              "DEBUG",
+		"page.settings":           "Настройки",
+		"page.user_info":          "Информация о пользователе",
+		"page.full_name":          "Полное имя",
+		"page.get_track_info":     "Получить информацию о треке",
+		"page.track_id":           "ID трека",
+		"page.get_info":           "Получить информацию",
+		"page.api_settings":       "Настройки API",
+		"page.settings_saved":     "Настройки успешно сохранены!",
+		"page.save":               "Сохранить",
+		"page.setup_title":        "Заполните настройки",
+		"page.user_id":            "ID пользователя",
+		"page.access_token":       "Токен доступа",
+		"page.track_info":         "Информация о треке",
+		"page.author":             "Автор",
+		"page.duration":           "Продолжительность",
+		"page.ms":                 "мс",
+		"page.no_audio":           "Ваш браузер не поддерживает элемент audio.",
+		"page.back_home":          "Вернуться на главную",
+		"page.welcome":            "Добро пожаловать",
+		"page.welcome_text":       "Вижу, что вы тут впервые. Введите ваш код комнаты, либо создайте ее",
+		"page.room_code":          "Код комнаты",
+		"page.join":               "Присоединиться",
+		"page.create_room":        "Создать комнату",
+		"page.choose_track":       "Выберите трек",
+		"page.artist":             "Исполнитель",
+		"page.playlist":           "Плейлист",
+		"page.add":                "Добавить",
+		"page.export_yandex":      "В Яндекс.Музыку",
+		"page.export_done":        "Сохранено в Яндекс.Музыку: {exported}, не удалось добавить: {failed}",
+		"page.import_file":        "Из файла",
+		"page.upload_title":       "Импорт из файла",
+		"page.upload_hint":        "Плейлист M3U, XSPF, JSPF или CSV",
+		"page.upload_summary":     "Ссылок: {link}, найдено: {matched}, на проверку: {ambiguous}, не найдено: {not_found}",
+		"page.upload_skip":        "Пропустить",
+		"page.upload_not_found":   "Не найдены:",
+		"page.upload_done":        "Добавлено: {added}, пропущено: {skipped}",
+		"page.add_track":          "Добавить трек",
+		"page.track_url":          "URL трека:",
+		"page.close":              "Закрыть",
+		"page.debug_title":        "Отладочная информация",
+		"page.app_info":           "Информация о приложении",
+		"page.name":               "Название",
+		"page.version":            "Версия",
+		"page.build_time":         "Время сборки",
+		"page.start_time":         "Время запуска",
+		"page.uptime":             "Время работы",
+		"page.system_info":        "Системная информация",
+		"page.go_version":         "Go версия",
+		"page.os":                 "ОС",
+		"page.arch":               "Архитектура",
+		"page.cpus":               "CPU ядер",
+		"page.goroutines":         "Горутин",
+		"page.database":           "База данных",
+		"page.status":             "Статус",
+		"page.connected":          "Подключено",
+		"page.open_connections":   "Открытые соединения",
+		"page.in_use":             "Активные",
+		"page.idle":               "Простаивают",
+		"page.disconnected":       "Отключено",
+		"page.environment":        "Переменные окружения",
+		"page.configuration":      "Конфигурация",
+		"page.server_time":        "Время сервера",
+		"page.current_time":       "Текущее время",
+		"page.login":              "Вход",
+		"page.login_button":       "Войти",
+		"page.logout":             "Выйти",
+		"page.username":           "Логин",
+		"page.password":           "Пароль",
+		"page.login_failed":       "Неверный логин или пароль",
+		"page.first_admin":        "Создайте учетную запись администратора",
+		"page.users":              "Пользователи",
+		"page.create_user":        "Создать пользователя",
+		"page.is_admin":           "Администратор",
+		"page.created_at":         "Создан",
+		"page.delete":             "Удалить",
+		"page.yes":                "да",
+		"page.no":                 "нет",
+		"page.user_create_error":  "Не удалось создать пользователя: %s",
+		"page.cannot_delete_self": "Нельзя удалить свою учетную запись",
+		"page.security":           "Безопасность",
+		"page.totp":               "Двухфакторная аутентификация",
+		"page.totp_code":          "Код",
+		"page.totp_code_hint":     "Введите шестизначный код из приложения-аутентификатора или один из кодов восстановления.",
+		"page.totp_invalid":       "Неверный код",
+		"page.totp_verify":        "Подтвердить",
+		"page.totp_on":            "Двухфакторная аутентификация включена.",
+		"page.totp_off":           "Двухфакторная аутентификация выключена.",
+		"page.totp_enable":        "Включить",
+		"page.totp_disable":       "Выключить",
+		"page.totp_scan":          "Отсканируйте QR-код в приложении-аутентификаторе или введите ключ вручную, затем введите код из приложения.",
+		"page.totp_secret":        "Ключ",
+		"page.totp_open_app":      "Открыть в приложении",
+		"page.totp_required":      "Администратор требует двухфакторную аутентификацию для изменения настроек и управления комнатами.",
+		"page.recovery_codes":     "Коды восстановления",
+		"page.recovery_hint":      "Сохраните эти коды в надежном месте. Каждый код можно использовать один раз вместо кода из приложения. Больше они показаны не будут.",
+		"page.recovery_left":      "Осталось неиспользованных кодов восстановления: %d",
+		"page.recovery_renew":     "Создать новые коды",
+		"page.require_2fa":        "Требовать 2FA для изменения настроек и управления комнатами",
+		"page.reset_2fa":          "Сбросить 2FA",
+		"page.api_tokens":         "API-токены",
+		"page.token_name":         "Название",
+		"page.scopes":             "Права",
+		"page.last_used":          "Использован",
+		"page.never":              "никогда",
+		"page.create_token":       "Создать токен",
+		"page.revoke":             "Отозвать",
+		"page.token_created":      "Скопируйте токен сейчас - больше он показан не будет:",
+		"page.token_usage":        "Передавайте токен в заголовке Authorization: Bearer <токен> при запросах к /api/.",
+		"page.api_docs":           "Документация API",
+		"page.api_params":         "Параметры",
+		"page.api_request":        "Тело запроса",
+		"page.api_responses":      "Ответы",
+		"page.api_access":         "Права токена",
+		"page.api_spec":           "Описание в формате OpenAPI",
+		"page.yandex_accounts":    "Аккаунты Яндекс.Музыки",
+		"page.account_label":      "Название",
+		"page.default_account":    "По умолчанию",
+		"page.make_default":       "Сделать основным",
+		"page.add_account":        "Добавить аккаунт",
+		"page.room_accounts":      "Аккаунты комнат",
+		"page.room":               "Комната",
+		"page.account":            "Аккаунт",
+		"page.invalid_user_id":    "ID пользователя должен быть числом",
+		"page.user_id_auto":       "Определится по токену",
+		"page.token_invalid":      "Яндекс.Музыка не приняла токен: он неверный или истек",
+		"page.token_mismatch":     "Токен принадлежит другому пользователю: проверьте ID или оставьте поле пустым",
+		"page.token_check_failed": "Не удалось проверить токен: Яндекс.Музыка недоступна, попробуйте позже",
+		"page.account_verified":   "Аккаунт Яндекс.Музыки: %s.",
+		"page.setup_step":         "Шаг %d из %d",
+		"page.setup_admin_hint":   "Создайте учетную запись администратора. Под ней вы будете входить в веб-интерфейс.",
+		"page.setup_token_hint":   "Вставьте токен Яндекс.Музыки. Он будет проверен, ID пользователя определится автоматически. Получить токен:",
+	},
+	"en": {
+		// Telegram bot
+		"bot.start": "Hi! I'm a bot for managing your playlist. Available commands:\n" +
+			"/playlist - show the current playlist\n" +
+			"/send <link> - send a track as an audio file\n" +
+			"/help - show help\n" +
+			"You can also send me a Yandex Music track link to add it",
+		"bot.help": "Available commands:\n" +
+			"/playlist - show the current playlist\n" +
+			"/send <link or ID> - send a track as an audio file\n" +
+			"/help - show this help\n\n" +
+			"/next - skip to the next track\n" +
+			"/prev - go back to the previous track\n" +
+			"/now - show the current track\n" +
+			"/pause - pause the current track\n" +
+			"/nowplaying <room code|off> - now playing announcements in this chat\n" +
+			"/join <room code> - join a room (it will show up in the Mini App)\n" +
+			"/import [id] [append|replace|sync] - import Yandex Music playlists\n\n" +
+			"/grant [chat] <id> <owner|dj|listener> - grant a role (owner only)\n" +
+			"/revoke [chat] <id> - revoke access (owner only)\n" +
+			"/export [playlist number] - save the playlist to Yandex Music (owner only)\n\n" +
+			"To add a track, send its Yandex Music link\n" +
+			"To remove a track, use the delete button in the playlist",
+		"bot.next":                "Skipping to the next track",
+		"bot.now":                 "Showing the current track",
+		"bot.prev":                "Going back to the previous track",
+		"bot.pause":               "Paused",
+		"bot.playlist_error":      "Failed to get the playlist: %s",
+		"bot.playlist_empty":      "The playlist is empty",
+		"bot.playlist_header":     "Your playlist:\n\n",
+		"bot.notification":        "New command from the Telegram bot: %s",
+		"bot.notification_sent":   "Notification sent to the web player",
+		"bot.unknown_command":     "Unknown command. Use /help to see available commands",
+		"bot.access_check_error":  "Failed to check access",
+		"bot.forbidden_command":   "You are not allowed to use this command",
+		"bot.forbidden_add":       "You are not allowed to add tracks",
+		"bot.forbidden":           "Not allowed",
+		"bot.bad_track_format":    "Invalid format. Send a link to a track, album, artist or playlist, or a track ID",
+		"bot.no_account":          "No Yandex Music account is connected",
+		"bot.link_not_found":      "Album, artist or playlist not found",
+		"bot.bulk_added":          "%s\nAdded: %d\nAlready in the playlist: %d\nUnavailable: %d",
+		"bot.track_check_error":   "Failed to check the track",
+		"bot.track_exists":        "This track is already in the playlist",
+		"bot.track_info_error":    "Failed to get track information",
+		"bot.track_add_error":     "Failed to add the track",
+		"bot.track_added":         "Track added to the playlist:\n%s - %s",
+		"bot.send_audio_button":   "▶ Send audio",
+		"bot.grant_usage":         "Usage: /grant <id> <owner|dj|listener> or /grant chat <id> <dj|listener>",
+		"bot.grant_error":         "Failed to grant access: %s",
+		"bot.granted":             "Role %s granted to %s %d",
+		"bot.revoke_usage":        "Usage: /revoke [chat] <id>",
+		"bot.access_error":        "Failed to check access: %s",
+		"bot.last_owner":          "The last owner cannot be revoked or demoted",
+		"bot.chat_owner":          "A chat can only be granted the dj or listener role",
+		"bot.config_owner":        "This owner is set by telegram_owner_id; change the configuration to revoke or demote them",
+		"bot.revoke_error":        "Failed to revoke access: %s",
+		"bot.revoke_not_found":    "%s %d is not on the access list",
+		"bot.revoked":             "Access revoked: %s %d",
+		"bot.send_usage":          "Usage: /send <track link or ID>",
+		"bot.send_error":          "Failed to send the track: %s",
+		"bot.bad_track_id":        "Invalid track ID",
+		"bot.sending":             "Sending the track...",
+		"bot.audio_too_large":     "%s - %s\nThe file is larger than 50 MB and cannot be sent by a bot. Listen here:\n%s",
+		"bot.nowplaying_usage":    "Usage: /nowplaying <room code> or /nowplaying off",
+		"bot.nowplaying_off_err":  "Failed to disable announcements: %s",
+		"bot.nowplaying_inactive": "Announcements were not enabled for this chat",
+		"bot.nowplaying_off":      "Announcements disabled",
+		"bot.nowplaying_on_err":   "Failed to enable announcements: %s",
+		"bot.nowplaying_on":       "Announcements enabled for room %s",
+		"bot.now_playing":         "Now playing: %s – %s",
+		"bot.room_check_error":    "Failed to check the room: %s",
+		"bot.room_not_found":      "Room %s not found",
+		"bot.join_usage":          "Usage: /join <room code>",
+		"bot.unknown_user":        "Could not identify the user",
+		"bot.join_error":          "Failed to join the room: %s",
+		"bot.joined":              "You are a member of room %s",
+		"bot.room_kicked":         "You have been removed from room %s",
+		"bot.liked_tracks":        "Liked tracks",
+		"bot.import_usage":        "Usage: /import <id> [append|replace|sync] or /import stop <id>",
+		"bot.import_error":        "Import error: %s",
+		"bot.imported":            "%s\nAdded: %d\nRemoved: %d\nAlready in the playlist: %d\nUnavailable: %d",
+		"bot.sync_on":             "The playlist will be synced every %d min",
+		"bot.sync_off":            "Sync stopped",
+		"bot.sync_not_found":      "This playlist is not being synced",
+		"bot.export_usage":        "Usage: /export or /export <Yandex Music playlist number>",
+		"bot.export_error":        "Failed to save to Yandex Music: %s",
+		"bot.export_conflict":     "The Yandex Music playlist changed while saving, please try again",
+		"bot.exported":            "Playlist \"%s\" saved, tracks: %d\n%s",
+		"bot.export_failed":       "Could not add:",
+		"bot.import_list": "Yandex Music playlists:\n\n%s\n" +
+			"/import <id> [append|replace|sync] - append, replace the playlist or keep in sync\n" +
+			"/import stop <id> - stop syncing",
+
+		// HTTP responses
+		"http.service_unavailable":  "Service unavailable",
+		"http.internal_error":       "Internal server error",
+		"http.database_error":       "Database error",
+		"http.invalid_method":       "Invalid request method",
+		"http.invalid_request":      "Invalid request data",
+		"http.forbidden":            "Forbidden",
+		"http.fetch_playlist":       "Error fetching playlist",
+		"http.save_settings":        "Error saving settings",
+		"http.account_status":       "Error fetching account status",
+		"http.bot_not_configured":   "Telegram bot is not configured",
+		"http.invalid_init_data":    "Invalid init data",
+		"http.track_id_required":    "Track ID is required",
+		"http.invalid_track_id":     "Invalid track ID",
+		"http.invalid_track_url":    "Invalid link: expected a track, album, artist or playlist link",
+		"http.link_not_found":       "Album, artist or playlist not found",
+		"http.bulk_added":           "Tracks added: %d, skipped: %d",
+		"http.track_exists":         "Track already exists in the playlist",
+		"http.track_added":          "Track added successfully",
+		"http.track_not_found":      "Track not found in playlist",
+		"http.not_found":            "Not found",
+		"http.invalid_query":        "Invalid filter, sort or paging parameters",
+		"http.invalid_cursor":       "Page cursor is invalid or does not match the query",
+		"http.track_deleted":        "Successfully deleted track from playlist",
+		"http.track_info":           "Error getting track info",
+		"http.track_data":           "Error fetching track data",
+		"http.download_url":         "Error fetching track download URL",
+		"http.update_position":      "Error updating track position",
+		"http.encode_response":      "Error encoding response",
+		"http.template":             "Template error",
+		"http.template_render":      "Template rendering error",
+		"http.room_not_found":       "Room not found",
+		"http.room_id_required":     "Room ID is required",
+		"http.invalid_room_id":      "Invalid room ID",
+		"http.room_check":           "Error checking room existence",
+		"http.create_room":          "Error creating room",
+		"http.room_tracks":          "Error getting room tracks",
+		"http.unsupported_language": "Unsupported language",
+		"http.unauthorized":         "Login required",
+		"http.totp_required":        "Enable two-factor authentication to perform this action",
+		"http.insufficient_scope":   "The token does not have the scope for this action",
+		"http.token_create_error":   "Failed to create token",
+		"http.token_name_required":  "Token name is required",
+		"http.unknown_scope":        "Unknown token scope",
+		"http.admin_scope":          "Only administrators can grant the admin scope",
+		"http.token_not_found":      "Token not found",
+		"http.room_code_required":   "Room code is required",
+		"http.room_forbidden":       "Your room role does not allow this action",
+		"http.room_kicked":          "You have been removed from this room",
+		"http.room_change_self":     "You cannot change your own role",
+		"http.creator_protected":    "Only an administrator can change the room creator's role",
+		"http.member_not_found":     "Member not found in this room",
+		"http.account_not_found":    "Yandex Music account not found",
+		"http.liked_tracks":         "Liked tracks",
+		"http.yandex_error":         "Yandex Music request failed",
+		"http.invalid_import":       "Specify a playlist and a mode: append, replace or sync",
+		"http.import_not_found":     "Sync not found",
+		"http.export_empty":         "The room playlist is empty",
+		"http.export_conflict":      "The Yandex Music playlist changed while saving, please try again",
+		"http.playlist_not_found":   "Playlist not found",
+		"http.invalid_playlist":     "A playlist name is required; name, description or cover is too long",
+		"http.invalid_format":       "File format must be m3u8, xspf or jspf",
+		"http.invalid_ttl":          "Link lifetime must be from 1 to 168 hours",
+		"http.upload_not_found":     "Uploaded file not found or expired",
+		"http.upload_too_large":     "The file is larger than 2 MB or has more than 1000 tracks",
+		"http.invalid_upload_file":  "Could not read the file: expected an M3U, XSPF, JSPF or CSV playlist",
+		"http.empty_upload_file":    "The file has no tracks",
+
+		// Web pages
+		"page.menu":               "Menu",
+		"page.home":               "Home",
+		"page.player":             "Player",
+		"page.debug":              "DEBUG",
+		"page.settings":           "Settings",
+		"page.user_info":          "User information",
+		"page.full_name":          "Full name",
+		"page.get_track_info":     "Get track information",
+		"page.track_id":           "Track ID",
+		"page.get_info":           "Get information",
+		"page.api_settings":       "API settings",
+		"page.settings_saved":     "Settings saved!",
+		"page.save":               "Save",
+		"page.setup_title":        "Complete the setup",
+		"page.user_id":            "User ID",
+		"page.access_token":       "Access token",
+		"page.track_info":         "Track information",
+		"page.author":             "Artist",
+		"page.duration":           "Duration",
+		"page.ms":                 "ms",
+		"page.no_audio":           "Your browser does not support the audio element.",
+		"page.back_home":          "Back to home",
+		"page.welcome":            "Welcome",
+		"page.welcome_text":       "Looks like you're new here. Enter your room code or create a room",
+		"page.room_code":          "Room code",
+		"page.join":               "Join",
+		"page.create_room":        "Create room",
+		"page.choose_track":       "Choose a track",
+		"page.artist":             "Artist",
+		"page.playlist":           "Playlist",
+		"page.add":                "Add",
+		"page.export_yandex":      "To Yandex Music",
+		"page.export_done":        "Saved to Yandex Music: {exported}, could not add: {failed}",
+		"page.import_file":        "From file",
+		"page.upload_title":       "Import from file",
+		"page.upload_hint":        "M3U, XSPF, JSPF or CSV playlist",
+		"page.upload_summary":     "Links: {link}, matched: {matched}, to review: {ambiguous}, not found: {not_found}",
+		"page.upload_skip":        "Skip",
+		"page.upload_not_found":   "Not found:",
+		"page.upload_done":        "Added: {added}, skipped: {skipped}",
+		"page.add_track":          "Add track",
+		"page.track_url":          "Track URL:",
+		"page.close":              "Close",
+		"page.debug_title":        "Debug information",
+		"page.app_info":           "Application",
+		"page.name":               "Name",
+		"page.version":            "Version",
+		"page.build_time":         "Build time",
+		"page.start_time":         "Start time",
+		"page.uptime":             "Uptime",
+		"page.system_info":        "System",
+		"page.go_version":         "Go version",
+		"page.os":                 "OS",
+		"page.arch":               "Architecture",
+		"page.cpus":               "CPU cores",
+		"page.goroutines":         "Goroutines",
+		"page.database":           "Database",
+		"page.status":             "Status",
+		"page.connected":          "Connected",
+		"page.open_connections":   "Open connections",
+		"page.in_use":             "In use",
+		"page.idle":               "Idle",
+		"page.disconnected":       "Disconnected",
+		"page.environment":        "Environment",
+		"page.configuration":      "Configuration",
+		"page.server_time":        "Server time",
+		"page.current_time":       "Current time",
+		"page.login":              "Log in",
+		"page.login_button":       "Log in",
+		"page.logout":             "Log out",
+		"page.username":           "Username",
+		"page.password":           "Password",
+		"page.login_failed":       "Invalid username or password",
+		"page.first_admin":        "Create the administrator account",
+		"page.users":              "Users",
+		"page.create_user":        "Create user",
+		"page.is_admin":           "Administrator",
+		"page.created_at":         "Created",
+		"page.delete":             "Delete",
+		"page.yes":                "yes",
+		"page.no":                 "no",
+		"page.user_create_error":  "Failed to create user: %s",
+		"page.cannot_delete_self": "You cannot delete your own account",
+		"page.security":           "Security",
+		"page.totp":               "Two-factor authentication",
+		"page.totp_code":          "Code",
+		"page.totp_code_hint":     "Enter the six-digit code from your authenticator app or one of your recovery codes.",
+		"page.totp_invalid":       "Invalid code",
+		"page.totp_verify":        "Verify",
+		"page.totp_on":            "Two-factor authentication is enabled.",
+		"page.totp_off":           "Two-factor authentication is disabled.",
+		"page.totp_enable":        "Enable",
+		"page.totp_disable":       "Disable",
+		"page.totp_scan":          "Scan the QR code with your authenticator app or enter the key manually, then enter the code from the app.",
+		"page.totp_secret":        "Key",
+		"page.totp_open_app":      "Open in app",
+		"page.totp_required":      "The administrator requires two-factor authentication to change settings and manage rooms.",
+		"page.recovery_codes":     "Recovery codes",
+		"page.recovery_hint":      "Store these codes somewhere safe. Each code can be used once instead of an app code. They will not be shown again.",
+		"page.recovery_left":      "Unused recovery codes left: %d",
+		"page.recovery_renew":     "Generate new codes",
+		"page.require_2fa":        "Require 2FA to change settings and manage rooms",
+		"page.reset_2fa":          "Reset 2FA",
+		"page.api_tokens":         "API tokens",
+		"page.token_name":         "Name",
+		"page.scopes":             "Scopes",
+		"page.last_used":          "Last used",
+		"page.never":              "never",
+		"page.create_token":       "Create token",
+		"page.revoke":             "Revoke",
+		"page.token_created":      "Copy the token now - it will not be shown again:",
+		"page.token_usage":        "Send the token in the Authorization: Bearer <token> header with requests to /api/.",
+		"page.api_docs":           "API documentation",
+		"page.api_params":         "Parameters",
+		"page.api_request":        "Request body",
+		"page.api_responses":      "Responses",
+		"page.api_access":         "Token scopes",
+		"page.api_spec":           "OpenAPI document",
+		"page.yandex_accounts":    "Yandex Music accounts",
+		"page.account_label":      "Label",
+		"page.default_account":    "Default",
+		"page.make_default":       "Make default",
+		"page.add_account":        "Add account",
+		"page.room_accounts":      "Room accounts",
+		"page.room":               "Room",
+		"page.account":            "Account",
+		"page.invalid_user_id":    "User ID must be a number",
+		"page.user_id_auto":       "Detected from the token",
+		"page.token_invalid":      "Yandex Music rejected the token: it is invalid or expired",
+		"page.token_mismatch":     "The token belongs to another user: check the ID or leave the field empty",
+		"page.token_check_failed": "Could not check the token: Yandex Music is unavailable, try again later",
+		"page.account_verified":   "Yandex Music account: %s.",
+		"page.setup_step":         "Step %d of %d",
+		"page.setup_admin_hint":   "Create the administrator account. You will use it to log in to the web interface.",
+		"page.setup_token_hint":   "Paste your Yandex Music token. It will be checked and the user ID filled in automatically. Get a token:",
+	},
+}
+
+// T возвращает сообщение из каталога на нужном языке
+func T(lang, key string, args ...interface{}) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		msg, ok = catalogs[defaultLang][key]
+		if !ok {
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// normalizeLang приводит код языка ("en-US", "ru_RU") к языку каталога.
+// Для неподдерживаемых языков возвращает пустую строку.
+func normalizeLang(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := catalogs[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// telegramLang - язык пользователя Telegram по LanguageCode из его клиента
+func telegramLang(user *tgbotapi.User) string {
+	if user != nil {
+		if lang := normalizeLang(user.LanguageCode); lang != "" {
+			return lang
+		}
+	}
+	return defaultLang
+}
+
+// requestLang выбирает язык веб-запроса: сначала сохраненная настройка
+// (cookie), затем Accept-Language, иначе язык по умолчанию
+func requestLang(r *http.Request) string {
+	if cookie, err := r.Cookie(langCookieName); err == nil {
+		if lang := normalizeLang(cookie.Value); lang != "" {
+			return lang
+		}
+	}
+
+	if lang := parseAcceptLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return lang
+	}
+
+	return defaultLang
+}
+
+// parseAcceptLanguage возвращает поддерживаемый язык с наибольшим весом q
+func parseAcceptLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := normalizeLang(fields[0])
+		if lang == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// tr - сообщение каталога на языке запроса
+func tr(r *http.Request, key string, args ...interface{}) string {
+	return T(requestLang(r), key, args...)
+}
+
+// httpError отправляет локализованный текст ошибки. Для /api/v1 - в
+// едином JSON-формате с кодом ошибки.
+func httpError(w http.ResponseWriter, r *http.Request, key string, code int) {
+	if isAPIv1(r) {
+		writeAPIError(w, r, key, code)
+		return
+	}
+	http.Error(w, tr(r, key), code)
+}
+
+// Сохранение выбранного языка веб-интерфейса: /lang?lang=en
+func setLanguageHandler(w http.ResponseWriter, r *http.Request) {
+	lang := normalizeLang(r.URL.Query().Get("lang"))
+	if lang == "" {
+		httpError(w, r, "http.unsupported_language", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookieName,
+		Value:    lang,
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Возвращаемся на страницу, с которой пришли, но только в пределах сайта
+	redirect := "/"
+	if ref := r.Referer(); ref != "" {
+		if u, err := r.URL.Parse(ref); err == nil && u.Host == r.Host {
+			redirect = u.RequestURI()
+		}
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var catalogKeyRe = regexp.MustCompile(`^(http|bot)\.[a-z0-9_]+$`)
+
+// Каждый ключ "http.*" и "bot.*", который встречается в коде, есть во
+// всех каталогах: иначе T вернет сам ключ вместо текста
+func TestCatalogKeysExist(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	used := map[string]string{}
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "i18n.go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			if s, err := strconv.Unquote(lit.Value); err == nil && catalogKeyRe.MatchString(s) {
+				used[s] = fset.Position(lit.Pos()).String()
+			}
+			return true
+		})
+	}
+	if len(used) == 0 {
+		t.Fatal("no catalog keys found in the code")
+	}
+	for key, pos := range used {
+		for lang, catalog := range catalogs {
+			if _, ok := catalog[key]; !ok {
+				t.Errorf("%s: key %q is missing from the %s catalog", pos, key, lang)
+			}
+		}
+	}
+}
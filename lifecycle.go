@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownCh закрывается при остановке сервера. Фоновые горутины (бот,
+// анонсы, рассылка WebSocket, наблюдение за сертификатом) по нему завершаются.
+var shutdownCh = make(chan struct{})
+
+// shutdownContext - контекст фоновой задачи, который отменяется при остановке
+// сервера: запрос к Яндекс.Музыке прерывается, а не держит wg до дедлайна
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// closeWebSockets закрывает все соединения с кадром Close, чтобы плееры
+// поняли, что сервер уходит, а не оборвалась сеть
+func closeWebSockets() {
+	wsWriteMu.Lock()
+	defer wsWriteMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	deadline := time.Now().Add(time.Second)
+	for conn := range wsClients {
+		if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+			log.Printf("WebSocket close error: %v", err)
+		}
+		conn.Close()
+		delete(wsClients, conn)
+	}
+}
+
+// shutdown останавливает сервер в пределах shutdown_timeout: перестает
+// принимать запросы и дожидается текущих, закрывает WebSocket, останавливает
+// бота, дожидается фоновых записей в базу и закрывает ее.
+func shutdown(servers []*http.Server) {
+	timeout := time.Duration(appConfig.ShutdownTimeout) * time.Second
+	log.Printf("Shutting down, deadline %s...", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	close(shutdownCh)
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Warning: HTTP server did not stop cleanly: %v", err)
+		}
+	}
+
+	// Соединения WebSocket перехвачены у http.Server, Shutdown их не закрывает
+	closeWebSockets()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Warning: background tasks did not stop before the deadline")
+	}
+
+	if db != nil {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
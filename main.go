@@ -1,22 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -26,29 +27,22 @@ import (
 	"pkg.botr.me/yamusic"
 )
 
-const (
-	port      = 8080
-	dbPath    = "settings.db"
-	tmplDir   = "web"
-	staticDir = "static"
-)
-
 type Config struct {
 	TelegramToken string
+	OwnerID       int64 // Telegram ID владельца бота
 	Database      *sql.DB
-	YaMusicClient *yamusic.Client
 	CoverURI      string
 	TrackURL      string
 }
 
 var (
-	client *yamusic.Client
 	db     *sql.DB
 	wg     sync.WaitGroup
+	botCfg *Config // нужен веб-обработчикам для проверки подписи Mini App
 )
 
 func openDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath) // используем "sqlite" вместо "sqlite3"
+	db, err := sql.Open("sqlite", appConfig.DBPath) // используем "sqlite" вместо "sqlite3"
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -117,6 +111,76 @@ func createTableIfNotExists() error {
 		return fmt.Errorf("failed to create rooms table: %w", err)
 	}
 
+	if err := createTelegramAccessTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create telegram_access table: %w", err)
+	}
+
+	if err := createTelegramAudioCacheTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create telegram_audio_cache table: %w", err)
+	}
+
+	if err := createNowPlayingTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create telegram_now_playing table: %w", err)
+	}
+
+	if err := createTelegramSessionTables(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create telegram session tables: %w", err)
+	}
+
+	if err := createUsersTables(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create users tables: %w", err)
+	}
+
+	if err := createTOTPTables(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create 2FA tables: %w", err)
+	}
+
+	if err := createAPITokensTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+
+	if err := createRoomRolesTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create room_roles table: %w", err)
+	}
+
+	if err := createYandexAccountsTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create yandex_accounts table: %w", err)
+	}
+
+	if err := createPlaylistColumns(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to migrate playlist table: %w", err)
+	}
+
+	if err := createPlaylistImportsTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create playlist_imports table: %w", err)
+	}
+
+	if err := createPlaylistExportsTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create playlist_exports table: %w", err)
+	}
+
+	if err := createSavedPlaylistsTables(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create playlists tables: %w", err)
+	}
+
+	if err := createPlaylistUploadsTable(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create playlist_uploads table: %w", err)
+	}
+
 	// Подтверждаем транзакцию
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -125,6 +189,44 @@ func createTableIfNotExists() error {
 	return nil
 }
 
+// addColumnIfNotExists добавляет колонку в уже существующую таблицу,
+// чтобы базы, созданные старыми версиями, получали новые поля
+func addColumnIfNotExists(tx *sql.Tx, table, column, definition string) error {
+	exists, err := tableHasColumn(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// tableHasColumn - есть ли колонка в таблице
+func tableHasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func runTelegramBot(cfg *Config) {
 	defer wg.Done()
 
@@ -136,12 +238,30 @@ func runTelegramBot(cfg *Config) {
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
+	// Анонсы "Сейчас играет" по событиям от веб-плеера
+	wg.Add(1)
+	go runNowPlayingAnnouncer(bot, cfg)
+
 	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	u.Timeout = appConfig.TelegramPollTimeout
 
 	updates := bot.GetUpdatesChan(u)
 
-	for update := range updates {
+	for {
+		var update tgbotapi.Update
+		select {
+		case <-shutdownCh:
+			// Текущее обновление уже обработано, новые не запрашиваем
+			bot.StopReceivingUpdates()
+			return
+		case update = <-updates:
+		}
+
+		if update.CallbackQuery != nil {
+			handleCallbackQuery(bot, update.CallbackQuery, cfg)
+			continue
+		}
+
 		if update.Message == nil {
 			continue
 		}
@@ -157,80 +277,113 @@ func runTelegramBot(cfg *Config) {
 
 func handleCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, cfg *Config) {
 	var reply string
+	var replyMarkup interface{}
+	lang := telegramLang(message.From)
+
+	// Проверяем права до выполнения любой команды
+	role, err := resolveBotRole(cfg.Database, message)
+	if err != nil {
+		log.Printf("Error resolving bot role: %v", err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.access_check_error")))
+		return
+	}
+	if role < requiredRole(message.Command()) {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.forbidden_command")))
+		return
+	}
 
 	switch message.Command() {
 	case "start":
-		reply = "Привет! Я бот для управления вашим плейлистом. Доступные команды:\n" +
-			"/playlist - показать текущий плейлист\n" +
-			"/help - показать справку\n" +
-			"Также вы можете отправить мне ссылку на трек Яндекс.Музыки для добавления"
+		reply = T(lang, "bot.start")
 
 	case "help":
-		reply = "Доступные команды:\n" +
-			"/playlist - показать текущий плейлист\n" +
-			"/help - показать эту справку\n\n" +
-			"/next - переключиться на следующий трек\n" +
-			"/prev - переключиться на предыдущий трек\n" +
-			"/now - показать текущий трек\n" +
-			"/pause - поставить текущий трек на паузу\n\n" +
-			"Для добавления трека отправьте ссылку на него с Яндекс.Музыки\n" +
-			"Для удаления трека используйте кнопку удаления в списке плейлиста"
+		reply = T(lang, "bot.help")
 
 	case "next":
 		// отправляем wsBroadcast сообщение
-		wsBroadcast <- map[string]string{
+		wsSend(map[string]string{
 			"type": "next",
-		}
-		reply = "Переключение на следующий трек"
+		})
+		reply = T(lang, "bot.next")
 
 	case "now":
 		// отправляем wsBroadcast сообщение
-		wsBroadcast <- map[string]string{
+		wsSend(map[string]string{
 			"type": "now",
-		}
-		reply = "Показать текущий трек"
+		})
+		reply = T(lang, "bot.now")
 
 	case "prev":
 		// отправляем wsBroadcast сообщение
-		wsBroadcast <- map[string]string{
+		wsSend(map[string]string{
 			"type": "prev",
-		}
-		reply = "Переключение на предыдущий трек"
+		})
+		reply = T(lang, "bot.prev")
 
 	case "pause":
 		// отправляем wsBroadcast сообщение
-		wsBroadcast <- map[string]string{
+		wsSend(map[string]string{
 			"type": "pause",
-		}
-		reply = "Пауза"
+		})
+		reply = T(lang, "bot.pause")
 
 	case "playlist":
 		tracks, err := getPlaylist(context.Background(), cfg)
 		if err != nil {
-			reply = "Ошибка при получении плейлиста: " + err.Error()
+			reply = T(lang, "bot.playlist_error", err)
 		} else if len(tracks) == 0 {
-			reply = "Плейлист пуст"
+			reply = T(lang, "bot.playlist_empty")
 		} else {
 			var sb strings.Builder
-			sb.WriteString("Ваш плейлист:\n\n")
+			sb.WriteString(T(lang, "bot.playlist_header"))
 			for i, track := range tracks {
 				sb.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, track.Artist, track.Title))
 			}
 			reply = sb.String()
+			replyMarkup = playlistKeyboard(tracks)
 		}
 
+	case "send":
+		reply = handleSendCommand(bot, message, cfg)
+
+	case "nowplaying":
+		reply = handleNowPlayingCommand(message, cfg)
+
+	case "join":
+		reply = handleJoinCommand(message, cfg)
+
 	case "notify":
-		wsBroadcast <- map[string]string{
+		wsSend(map[string]string{
 			"type":    "notification",
-			"message": "Новая команда от Telegram-бота: " + message.Text,
-		}
-		reply = "Уведомление отправлено на фронтенд"
+			"message": T(lang, "bot.notification", message.Text),
+		})
+		reply = T(lang, "bot.notification_sent")
+
+	case "grant":
+		reply = handleGrantCommand(message, cfg)
+
+	case "revoke":
+		reply = handleRevokeCommand(message, cfg)
+
+	case "import":
+		reply = handleImportCommand(message, cfg)
+
+	case "export":
+		reply = handleExportCommand(message, cfg)
 
 	default:
-		reply = "Неизвестная команда. Используйте /help для просмотра доступных команд"
+		reply = T(lang, "bot.unknown_command")
+	}
+
+	// Пустой ответ означает, что команда уже сама ответила в чат
+	if reply == "" {
+		return
 	}
 
 	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	if replyMarkup != nil {
+		msg.ReplyMarkup = replyMarkup
+	}
 	bot.Send(msg)
 }
 
@@ -254,7 +407,7 @@ func getPlaylist(ctx context.Context, cfg *Config) ([]Track, error) {
 			return nil, err
 		}
 
-		trackInfo, resp, err := cfg.YaMusicClient.Tracks().Get(ctx, trackID)
+		trackInfo, resp, err := defaultClient().Tracks().Get(ctx, trackID)
 		if err != nil || resp.StatusCode != 200 {
 			continue
 		}
@@ -275,29 +428,50 @@ func checkTrackExists(trackID int, db *sql.DB) (bool, error) {
 	return exists, err
 }
 
-func addTrackToPlaylist(trackID int, db *sql.DB) error {
-	_, err := db.Exec("INSERT INTO playlist (track_id) VALUES (?)", trackID)
+// addTrackToPlaylist добавляет трек в конец плейлиста комнаты (0 - общий плейлист бота)
+// вместе с названием и исполнителем, чтобы по ним сразу работали фильтры.
+// addedBy - участник в виде "user:2", source - откуда пришел трек (web, api, telegram).
+func addTrackToPlaylist(ctx context.Context, track *yandexTrack, roomID int, addedBy, source string, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO playlist (track_id, room_id, position, added_by, source, title, artist, duration_ms)
+        VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist WHERE room_id = ?), NULLIF(?, ''), ?, ?, ?, ?)`,
+		int(track.ID), roomID, roomID, addedBy, source, track.Title, track.ArtistName(), track.DurationMs)
 	return err
 }
 
-func loadTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	tmplPath := filepath.Join(tmplDir, tmpl)
-
-	t, err := template.ParseFiles(tmplPath)
+func loadTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	tmplPath := filepath.Join(appConfig.TemplateDir, tmpl)
+	lang := requestLang(r)
+
+	// Тексты шаблонов берутся из каталога сообщений: {{t "page.home"}}
+	t, err := template.New(tmpl).Funcs(template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			return T(lang, key, args...)
+		},
+		"lang": func() string {
+			return lang
+		},
+	}).ParseFiles(tmplPath)
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
+		httpError(w, r, "http.template", http.StatusInternalServerError)
 		return
 	}
 
 	err = t.Execute(w, data)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Template rendering error", http.StatusInternalServerError)
+		httpError(w, r, "http.template_render", http.StatusInternalServerError)
 	}
 }
 
 func playlistHandler(w http.ResponseWriter, r *http.Request) {
+	client := defaultClient()
+	if client == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Получаем все треки из базы данных
 	db, err := openDB()
 	if err != nil {
@@ -309,7 +483,7 @@ func playlistHandler(w http.ResponseWriter, r *http.Request) {
 	rows, err := db.Query("SELECT track_id FROM playlist")
 	if err != nil {
 		log.Printf("Error fetching playlist: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
+		httpError(w, r, "http.fetch_playlist", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
@@ -329,7 +503,7 @@ func playlistHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if err := rows.Scan(&track.TrackID); err != nil {
 			log.Printf("Error scanning row: %v", err)
-			http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
+			httpError(w, r, "http.fetch_playlist", http.StatusInternalServerError)
 			return
 		}
 
@@ -370,242 +544,115 @@ func playlistHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := rows.Err(); err != nil {
 		log.Printf("Error iterating rows: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
+		httpError(w, r, "http.fetch_playlist", http.StatusInternalServerError)
 		return
 	}
 
 	// Передаем данные о плейлисте в шаблон
-	loadTemplate(w, "playlist.html", tracks)
+	loadTemplate(w, r, "playlist.html", tracks)
 }
 
-func setupHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		token := r.FormValue("token")
-		userID := r.FormValue("userID")
-
-		db, err := openDB()
-		if err != nil {
-			log.Fatal("Failed to open database:", err)
-		}
-		defer db.Close()
-
-		_, err = db.Exec(`
-            CREATE TABLE IF NOT EXISTS playlist (
-                track_id INTEGER PRIMARY KEY,
-                date_added TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-                position INTEGER DEFAULT 0
-            );`)
-		if err != nil {
-			http.Error(w, "Error creating playlist table", http.StatusInternalServerError)
-			return
-		}
-
-		_, err = db.Exec(`
-            CREATE TABLE IF NOT EXISTS settings (
-                id INTEGER PRIMARY KEY,
-                user_id INTEGER NOT NULL,
-                access_token TEXT NOT NULL
-            );`)
-		if err != nil {
-			http.Error(w, "Error creating settings table", http.StatusInternalServerError)
-			return
-		}
-
-		_, err = db.Exec("INSERT INTO settings (user_id, access_token) VALUES (?, ?)", userID, token)
-		if err != nil {
-			http.Error(w, "Error saving settings", http.StatusInternalServerError)
-			return
-		}
-
-		http.Redirect(w, r, "/", http.StatusFound)
+// Обработчик для API /api/tracks. Параметры фильтров, сортировки и страниц
+// описаны у parseTrackQuery; без limit возвращаются все треки. Общее число
+// треков и курсор следующей страницы - в заголовках X-Total-Count и X-Next-Cursor.
+func apiTracksHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := trackQueryForRequest(w, r, 0)
+	if !ok {
 		return
 	}
 
-	loadTemplate(w, "setup.html", nil)
-}
-func dbExists() bool {
-	_, err := os.Stat(dbPath)
-	return !os.IsNotExist(err)
-}
-
-// Обработчик для API /api/tracks
-func apiTracksHandler(w http.ResponseWriter, r *http.Request) {
-	// Добавим логирование
-	log.Printf("Fetching tracks from database...")
+	// Аккаунт комнаты, если она указана, иначе аккаунт по умолчанию
+	roomClient := clientForRoomCode(r.URL.Query().Get("room_code"))
 
-	rows, err := db.Query("SELECT track_id, position FROM playlist ORDER BY position")
+	page, err := queryPlaylist(r.Context(), q)
 	if err != nil {
 		log.Printf("Error fetching playlist: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
+		httpError(w, r, "http.fetch_playlist", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var tracks []struct {
-		TrackID  int    `json:"track_id"`
-		Title    string `json:"title"`
-		Artist   string `json:"artist"`
-		TrackURL string `json:"track_url"`
-		CoverURI string `json:"cover_uri"`
-		Position int    `json:"position"`
-	}
-
-	for rows.Next() {
-		var track struct {
-			TrackID  int
-			Position int
-		}
-
-		if err := rows.Scan(&track.TrackID, &track.Position); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue // Лучше не пропускать, а возвращать ошибку
-		}
-
-		// Добавим логирование для отслеживания запросов к API
-		log.Printf("Fetching info for track %d from Yandex Music API", track.TrackID)
-
-		trackInfo, resp, err := client.Tracks().Get(r.Context(), track.TrackID)
-		if err != nil {
-			log.Printf("Error getting track info for ID %d: %v", track.TrackID, err)
-			continue // Здесь мы пропускаем трек при ошибке
-		}
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Bad response status %d for track ID %d", resp.StatusCode, track.TrackID)
-			continue // И здесь тоже пропускаем
-		}
-
-		title := trackInfo.Result[0].Title
-		artist := trackInfo.Result[0].Artists[0].Name
-
-		trackURL, err := client.Tracks().GetDownloadURL(r.Context(), track.TrackID)
-		if err != nil {
-			log.Printf("Error getting download URL for track %d: %v", track.TrackID, err)
-			continue // И здесь пропускаем
-		}
-
-		coverURI := trackInfo.Result[0].Albums[0].CoverURI
-		coverURI = strings.Replace(coverURI, "%25%25", "400x400", -1)
-		coverURI = strings.Replace(coverURI, "%", "", -1)
-
-		tracks = append(tracks, struct {
-			TrackID  int    `json:"track_id"`
-			Title    string `json:"title"`
-			Artist   string `json:"artist"`
-			TrackURL string `json:"track_url"`
-			CoverURI string `json:"cover_uri"`
-			Position int    `json:"position"`
-		}{
-			TrackID:  track.TrackID,
-			Title:    title,
-			Artist:   artist,
-			TrackURL: trackURL,
-			CoverURI: coverURI,
-			Position: track.Position,
-		})
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
-		return
+	if err := fillPlaylistMetadata(r.Context(), page.Entries, yandexAPIForRoom(q.RoomID)); err != nil {
+		log.Printf("Error filling track metadata: %v", err)
 	}
 
-	// Добавим логирование результата
-	log.Printf("Successfully fetched %d tracks", len(tracks))
+	tracks := pageTracks(r.Context(), page.Entries, roomClient)
+	log.Printf("Successfully fetched %d of %d tracks", len(tracks), page.Total)
 
-	err = json.NewEncoder(w).Encode(tracks)
-	if err != nil {
+	setPageHeaders(w, r, page)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tracks); err != nil {
 		log.Printf("Error encoding tracks to JSON: %v", err)
-		http.Error(w, "Error encoding tracks to JSON", http.StatusInternalServerError)
+		httpError(w, r, "http.encode_response", http.StatusInternalServerError)
 	}
 }
 
-// Обработчик для добавления трека в плейлист
+// Обработчик для добавления трека в плейлист.
+// Старый адрес, то же самое - POST /api/v1/rooms/{code}/tracks.
 func addTrackToPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Чтение данных из тела запроса
 	var requestData struct {
 		TrackURL string `json:"track_url"`
+		RoomCode string `json:"room_code"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 		log.Printf("Error decoding JSON: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
-		return
-	}
-
-	// Извлекаем track_id из URL
-	trackID, err := extractTrackID(requestData.TrackURL)
-	if err != nil {
-		log.Printf("Error extracting track ID: %v", err)
-		http.Error(w, "Invalid track URL", http.StatusBadRequest)
-		return
-	}
-
-	// Проверка существования трека в базе данных
-	exists, err := checkTrackExists(trackID, db)
-	if err != nil {
-		log.Printf("Error checking track existence: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
 		return
 	}
 
-	if exists {
-		http.Error(w, "Track already exists in the playlist", http.StatusConflict)
+	_, result, ok := addRoomTrack(w, r, requestData.RoomCode, requestData.TrackURL)
+	if !ok {
 		return
 	}
-
-	// Добавление трека в плейлист
-	err = addTrackToPlaylist(trackID, db)
-	if err != nil {
-		log.Printf("Error adding track to playlist: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Альбом, исполнитель или плейлист: итог - что добавлено, что пропущено
+	if result != nil {
+		response := struct {
+			*bulkAddResult
+			Message string `json:"message"`
+		}{
+			bulkAddResult: result,
+			Message:       tr(r, "http.bulk_added", len(result.Added), len(result.Skipped)),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	// Отправляем успешный ответ
 	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write([]byte("Track added successfully"))
+	_, _ = w.Write([]byte(tr(r, "http.track_added")))
 }
 
-// Функция для изменения позиции трека в плейлисте
+// Функция для изменения позиции трека в плейлисте.
+// Старый адрес, то же самое - PATCH /api/v1/rooms/{code}/tracks/{id}.
 func changeTrackPosition(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Проверяем, что метод запроса - POST
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
 		return
 	}
 	// Чтение данных из тела запроса
 	var requestData struct {
-		TrackID  int `json:"track_id"`
-		Position int `json:"position"`
+		TrackID  int    `json:"track_id"`
+		Position int    `json:"position"`
+		RoomCode string `json:"room_code"`
 	}
 	// Декодируем JSON в структуру
 	err := json.NewDecoder(r.Body).Decode(&requestData)
 	if err != nil {
 		log.Printf("Error decoding JSON: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
-		return
-	}
-	// Открываем базу данных для изменения позиции трека
-	db, err := openDB()
-	if err != nil {
-		log.Printf("Failed to open database: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
 		return
 	}
-	defer db.Close()
-	// Обновляем позицию трека в плейлисте
-	_, err = db.Exec("UPDATE playlist SET position = ? WHERE track_id = ?", requestData.Position, requestData.TrackID)
-	if err != nil {
-		log.Printf("Error updating track position: %v", err)
-		http.Error(w, "Error updating track position", http.StatusInternalServerError)
+	if !moveRoomTrack(w, r, requestData.RoomCode, requestData.TrackID, requestData.Position) {
 		return
 	}
 	// Отправляем ответ об успешном изменении позиции
@@ -646,56 +693,122 @@ func extractTrackID(input string) (int, error) {
 
 // Обновляем handleTrackURL для корректной обработки
 func handleTrackURL(bot *tgbotapi.BotAPI, message *tgbotapi.Message, cfg *Config) {
+	lang := telegramLang(message.From)
+
+	// Добавлять треки могут только DJ и владельцы
+	role, err := resolveBotRole(cfg.Database, message)
+	if err != nil {
+		log.Printf("Error resolving bot role: %v", err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.access_check_error")))
+		return
+	}
+	if role < roleDJ {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.forbidden_add")))
+		return
+	}
+
 	// Пытаемся извлечь ID из сообщения
-	trackID, err := extractTrackID(message.Text)
+	link, err := parseMusicLink(message.Text)
 	if err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID,
-			"Неверный формат. Отправьте ссылку на трек или его ID")
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.bad_track_format"))
 		bot.Send(msg)
 		return
 	}
 
+	var addedBy string
+	if message.From != nil {
+		addedBy = roomMember{Type: memberTelegram, ID: message.From.ID}.String()
+	}
+
+	// Альбом, исполнитель или плейлист добавляются целиком
+	if link.Kind != musicLinkTrack {
+		handleCollectionLink(bot, message, link, addedBy, lang)
+		return
+	}
+	trackID := link.ID
+
 	// Проверяем существование трека
 	exists, err := checkTrackExists(trackID, cfg.Database)
 	if err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при проверке трека")
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_check_error"))
 		bot.Send(msg)
 		return
 	}
 	if exists {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Этот трек уже есть в плейлисте")
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_exists"))
 		bot.Send(msg)
 		return
 	}
 
 	// Получаем информацию о треке
-	trackInfo, resp, err := cfg.YaMusicClient.Tracks().Get(context.Background(), trackID)
-	if err != nil || resp.StatusCode != 200 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при получении информации о треке")
+	api := yandexAPIForRoom(0)
+	if api == nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.no_account")))
+		return
+	}
+	ctx := context.Background()
+	tracks, err := api.Tracks(ctx, []int{trackID})
+	if err != nil || tracks[0].Title == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_info_error"))
 		bot.Send(msg)
 		return
 	}
+	track := &tracks[0]
 
 	// Добавляем трек в базу
-	err = addTrackToPlaylist(trackID, cfg.Database)
+	err = addTrackToPlaylist(ctx, track, 0, addedBy, trackSourceTelegram, cfg.Database)
 	if err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при добавлении трека")
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_add_error"))
 		bot.Send(msg)
 		return
 	}
 
-	reply := fmt.Sprintf("Трек добавлен в плейлист:\n%s - %s",
-		trackInfo.Result[0].Artists[0].Name,
-		trackInfo.Result[0].Title)
+	reply := T(lang, "bot.track_added", track.ArtistName(), track.Title)
 	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(sendTrackButton(T(lang, "bot.send_audio_button"), trackID)))
 	bot.Send(msg)
 }
 
+// handleCollectionLink добавляет в плейлист бота треки альбома, исполнителя
+// или плейлиста и отвечает итогом
+func handleCollectionLink(bot *tgbotapi.BotAPI, message *tgbotapi.Message, link *musicLink, addedBy, lang string) {
+	api := yandexAPIForRoom(0)
+	if api == nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.no_account")))
+		return
+	}
+
+	ctx := context.Background()
+	title, tracks, err := expandMusicLink(ctx, api, link)
+	if isYandexNotFound(err) {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.link_not_found")))
+		return
+	}
+	if err != nil {
+		log.Printf("Error expanding %s %d: %v", link.Kind, link.ID, err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_info_error")))
+		return
+	}
+
+	result, err := bulkInsertTracks(ctx, 0, title, tracks, addedBy, trackSourceTelegram)
+	if err != nil {
+		log.Printf("Error adding tracks to playlist: %v", err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, T(lang, "bot.track_add_error")))
+		return
+	}
+
+	reply := T(lang, "bot.bulk_added", result.Title, len(result.Added),
+		result.skippedBy(skipDuplicate), result.skippedBy(skipUnavailable))
+	bot.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+}
+
 // Главная страница
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -705,11 +818,11 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	accountStatus, resp, err := client.Account().GetStatus(r.Context())
 	if err != nil {
 		log.Printf("Error getting account status: %v", err)
-		http.Error(w, "Error fetching account status", http.StatusInternalServerError)
+		httpError(w, r, "http.account_status", http.StatusInternalServerError)
 		return
 	}
 	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Error fetching account status", http.StatusInternalServerError)
+		httpError(w, r, "http.account_status", http.StatusInternalServerError)
 		return
 	}
 
@@ -723,18 +836,19 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отображаем шаблон с данными
-	loadTemplate(w, "index.html", data)
+	loadTemplate(w, r, "index.html", data)
 }
 
 func settingsTemplate(w http.ResponseWriter, r *http.Request) {
-	loadTemplate(w, "settings.html", nil)
+	renderSettings(w, r, "", "")
 }
 
 // Получение информации о треке
 func getTrackHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -742,25 +856,25 @@ func getTrackHandler(w http.ResponseWriter, r *http.Request) {
 
 	trackID := r.URL.Query().Get("trackID")
 	if trackID == "" {
-		http.Error(w, "Track ID is required", http.StatusBadRequest)
+		httpError(w, r, "http.track_id_required", http.StatusBadRequest)
 		return
 	}
 
 	// Получение информации о треке с использованием API
 	trackIDInt, err := strconv.Atoi(trackID)
 	if err != nil {
-		http.Error(w, "Invalid track ID", http.StatusBadRequest)
+		httpError(w, r, "http.invalid_track_id", http.StatusBadRequest)
 		return
 	}
 
 	trackInfo, resp, err := client.Tracks().Get(r.Context(), trackIDInt)
 	if err != nil {
 		log.Printf("Error getting track info: %v", err)
-		http.Error(w, "Error getting track info", http.StatusInternalServerError)
+		httpError(w, r, "http.track_info", http.StatusInternalServerError)
 		return
 	}
 	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Error fetching track data", http.StatusInternalServerError)
+		httpError(w, r, "http.track_data", http.StatusInternalServerError)
 		return
 	}
 
@@ -768,7 +882,7 @@ func getTrackHandler(w http.ResponseWriter, r *http.Request) {
 	trackURL, err := client.Tracks().GetDownloadURL(r.Context(), trackIDInt)
 	if err != nil {
 		log.Printf("Error getting track download URL: %v", err)
-		http.Error(w, "Error fetching track download URL", http.StatusInternalServerError)
+		httpError(w, r, "http.download_url", http.StatusInternalServerError)
 		return
 	}
 
@@ -789,48 +903,48 @@ func getTrackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отображаем страницу с информацией о треке и плеером
-	loadTemplate(w, "trackInfo.html", data)
+	loadTemplate(w, r, "trackInfo.html", data)
 }
 
 // Сохранение настроек API
 func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 
-	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+	// Без аккаунтов client пуст, но страница нужна, чтобы добавить аккаунт
+	if db == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	if r.Method == http.MethodPost {
-		token := r.FormValue("token")
-		userID := r.FormValue("userID")
-
-		// Сохранение данных в базе данных
-		db, err := openDB()
-		if err != nil {
-			log.Fatal("Failed to open database:", err)
-		}
-		defer db.Close()
-
-		_, err = db.Exec("UPDATE settings SET user_id = ?, access_token = ? WHERE id = 2", userID, token)
-		if err != nil {
-			log.Printf("Error saving settings: %v", err)
-			http.Error(w, "Error saving settings", http.StatusInternalServerError)
-			return
-		}
-
-		// Передаем флаг успешности
-		data := struct {
-			Success bool
-		}{
-			Success: true,
-		}
+	if r.Method != http.MethodPost {
+		// Для GET-запросов просто показываем форму настроек
+		renderSettings(w, r, "", "")
+		return
+	}
 
-		// Отображаем страницу настроек с сообщением об успешном сохранении
-		loadTemplate(w, "settings.html", data)
+	var accountName, errKey string
+	var err error
+	if r.FormValue("action") == "" {
+		// Старая форма с одним токеном меняет аккаунт по умолчанию
+		accountName, errKey, err = saveDefaultAccount(r)
 	} else {
-		// Для GET-запросов просто показываем форму настроек
-		loadTemplate(w, "settings.html", nil)
+		accountName, errKey, err = handleAccountsForm(r)
+	}
+	if err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "http.save_settings", http.StatusInternalServerError)
+		return
+	}
+	if errKey != "" {
+		renderSettings(w, r, "", tr(r, errKey))
+		return
+	}
+
+	// Клиенты уже пересозданы - новый токен действует без перезапуска
+	notice := tr(r, "page.settings_saved")
+	if accountName != "" {
+		notice += " " + tr(r, "page.account_verified", accountName)
 	}
+	renderSettings(w, r, notice, "")
 }
 
 type AppInfo struct {
@@ -844,8 +958,9 @@ type AppInfo struct {
 
 func debugHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -874,6 +989,7 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 		MemStats     runtime.MemStats
 		Time         time.Time
 		Environment  map[string]string
+		Config       map[string]string
 		DiskUsage    struct {
 			Total uint64
 			Free  uint64
@@ -898,6 +1014,7 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 		GOROOT:       runtime.GOROOT(),
 		Time:         time.Now(),
 		Environment:  make(map[string]string),
+		Config:       appConfig.Redacted(),
 	}
 
 	// Получаем статистику БД
@@ -918,29 +1035,33 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 		data.Environment["YandexMusicLogin"] = accountStatus.Result.Account.Login
 	}
 
+	// Сам токен не показываем - только как он хранится
+	if _, err := secretCipher(); err == nil {
+		data.Environment["AccessTokenStorage"] = "AES-256-GCM, key from " + secretKeyFrom
+	} else {
+		data.Environment["AccessTokenStorage"] = "secret key error"
+	}
+
 	// Получаем статистику памяти
 	runtime.ReadMemStats(&data.MemStats)
 
 	// шаблон
-	loadTemplate(w, "debug.html", data)
+	loadTemplate(w, r, "debug.html", data)
 
 }
 
+// Удаление трека. Старый адрес, то же самое - DELETE /api/v1/rooms/{code}/tracks/{id}.
 func deleteTrackFromPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	// Log the raw request body
-	body, _ := io.ReadAll(r.Body)
-	log.Printf("Received delete request body: %s", string(body))
-	r.Body = io.NopCloser(bytes.NewBuffer(body)) // Restore the body for later use
-
 	var requestData struct {
 		TrackID  int    `json:"track_id"`
 		RoomCode string `json:"room_code"`
@@ -948,72 +1069,20 @@ func deleteTrackFromPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 		log.Printf("Error decoding request: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
-		return
-	}
-
-	log.Printf("Decoded request data: track_id=%d, room_code=%s", requestData.TrackID, requestData.RoomCode)
-
-	db, err := openDB()
-	if err != nil {
-		log.Printf("Database connection error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
-	// First, check if the room exists
-	var roomID int
-	err = db.QueryRow("SELECT id FROM rooms WHERE code = ?", requestData.RoomCode).Scan(&roomID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Room not found with code: %s", requestData.RoomCode)
-			http.Error(w, "Room not found", http.StatusNotFound)
-			return
-		}
-		log.Printf("Error querying room: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	log.Printf("Found room ID: %d for code: %s", roomID, requestData.RoomCode)
-
-	// Check if the track exists in the playlist
-	var exists bool
-	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM playlist WHERE track_id = ? AND room_id = ?)",
-		requestData.TrackID, roomID).Scan(&exists)
-	if err != nil {
-		log.Printf("Error checking track existence: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		log.Printf("Track %d not found in room %d", requestData.TrackID, roomID)
-		http.Error(w, "Track not found in playlist", http.StatusNotFound)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
 		return
 	}
 
-	// Delete the track
-	result, err := db.Exec("DELETE FROM playlist WHERE track_id = ? AND room_id = ?",
-		requestData.TrackID, roomID)
-	if err != nil {
-		log.Printf("Delete error detail: %v", err)
-		http.Error(w, fmt.Sprintf("Delete error: %v", err), http.StatusInternalServerError)
+	if !deleteRoomTrack(w, r, requestData.RoomCode, requestData.TrackID) {
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
-	} else {
-		log.Printf("Delete operation affected %d rows", rowsAffected)
-	}
-
 	response := struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
 	}{
-		Success: rowsAffected > 0,
-		Message: fmt.Sprintf("Successfully deleted track from playlist"),
+		Success: true,
+		Message: tr(r, "http.track_deleted"),
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -1021,59 +1090,44 @@ func deleteTrackFromPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Выводим все track_id из базы данных
+// ID треков для проверки изменений плейлиста: /api/tracks/all?room_code=ABCDE.
+// Принимает те же фильтры, сортировку и страницы, что и /api/tracks.
 func getDBTracksIDHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Открываем базу данных
-	db, err := openDB()
-	if err != nil {
-		log.Fatal("Failed to open database:", err)
+	q, ok := trackQueryForRequest(w, r, 0)
+	if !ok {
+		return
 	}
-	defer db.Close()
-
-	// Запрашиваем все track_id
-	rows, err := db.Query("SELECT track_id FROM playlist")
+	page, err := queryPlaylist(r.Context(), q)
 	if err != nil {
 		log.Printf("Error fetching playlist: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
+		httpError(w, r, "http.fetch_playlist", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	// Список track_id
-	var trackIDs []int
-
-	// Проходим по каждому track_id
-	for rows.Next() {
-		var trackID int
-		if err := rows.Scan(&trackID); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
-			return
-		}
-
-		// Добавляем track_id в список
-		trackIDs = append(trackIDs, trackID)
+	if err := fillPlaylistMetadata(r.Context(), page.Entries, yandexAPIForRoom(q.RoomID)); err != nil {
+		log.Printf("Error filling track metadata: %v", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		http.Error(w, "Error fetching playlist", http.StatusInternalServerError)
-		return
+	// Список track_id
+	trackIDs := make([]int, 0, len(page.Entries))
+	for _, e := range page.Entries {
+		trackIDs = append(trackIDs, e.TrackID)
 	}
 
 	// Отправляем список track_id в формате JSON
+	setPageHeaders(w, r, page)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(trackIDs); err != nil {
 		log.Printf("Error encoding track IDs: %v", err)
-		http.Error(w, "Error encoding track IDs", http.StatusInternalServerError)
+		httpError(w, r, "http.encode_response", http.StatusInternalServerError)
 	}
 }
 
@@ -1085,11 +1139,13 @@ var upgrader = websocket.Upgrader{
 
 var wsClients = make(map[*websocket.Conn]bool) // Хранение активных соединений
 var wsBroadcast = make(chan interface{})       // Канал для отправки сообщений клиентам
+var wsWriteMu sync.Mutex                       // В соединение websocket может писать только один поток
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -1099,21 +1155,143 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	wsWriteMu.Lock()
 	wsClients[conn] = true
+	wsWriteMu.Unlock()
 
 	for {
-		var msg interface{}
+		var msg struct {
+			Type     string `json:"type"`
+			RoomCode string `json:"room_code"`
+			TrackID  int    `json:"track_id"`
+			Action   string `json:"action"`
+			roomMember
+			Role string `json:"role"`
+		}
 		if err := conn.ReadJSON(&msg); err != nil {
 			log.Printf("WebSocket read error: %v", err)
+			wsWriteMu.Lock()
 			delete(wsClients, conn)
+			wsWriteMu.Unlock()
 			break
 		}
+
+		switch msg.Type {
+		case playerEventNowPlaying:
+			// Плеер сообщает о смене трека - передаем событие боту.
+			// Сообщать может только тот, кто управляет воспроизведением,
+			// и только о треке из очереди этой комнаты
+			if msg.RoomCode == "" || msg.TrackID == 0 {
+				break
+			}
+			if errKey := wsRoomCommand(r, msg.RoomCode, roomActionPlayback, func(roomID int) (string, error) {
+				var exists bool
+				err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM playlist WHERE room_id = ? AND track_id = ?)", roomID, msg.TrackID).Scan(&exists)
+				if err != nil {
+					return "", err
+				}
+				if !exists {
+					return "http.track_not_found", nil
+				}
+				publishPlayerEvent(playerEvent{
+					Type:     msg.Type,
+					RoomCode: msg.RoomCode,
+					TrackID:  msg.TrackID,
+				})
+				return "", nil
+			}); errKey != "" {
+				wsReply(conn, map[string]string{"type": "error", "message": tr(r, errKey)})
+			}
+
+		case "control":
+			// Управление воспроизведением, как /next, /prev и /pause в боте
+			if errKey := wsRoomCommand(r, msg.RoomCode, roomActionPlayback, func(roomID int) (string, error) {
+				switch msg.Action {
+				case "next", "prev", "pause", "now":
+					wsSend(map[string]string{"type": msg.Action})
+					return "", nil
+				}
+				return "http.invalid_request", nil
+			}); errKey != "" {
+				wsReply(conn, map[string]string{"type": "error", "message": tr(r, errKey)})
+			}
+
+		case "setRole", "kick":
+			// Хозяин комнаты меняет роль участника или исключает его
+			role := roomRoleKicked
+			if msg.Type == "setRole" {
+				var err error
+				if role, err = parseRoomRole(msg.Role); err != nil {
+					wsReply(conn, map[string]string{"type": "error", "message": tr(r, "http.invalid_request")})
+					continue
+				}
+			}
+			if errKey := wsRoomCommand(r, msg.RoomCode, roomActionManage, func(roomID int) (string, error) {
+				return changeMemberRole(r, roomID, msg.roomMember, role)
+			}); errKey != "" {
+				wsReply(conn, map[string]string{"type": "error", "message": tr(r, errKey)})
+			}
+		}
+	}
+}
+
+// wsRoomCommand проверяет право на действие в комнате и выполняет команду,
+// пришедшую по websocket. Возвращает ключ сообщения об ошибке.
+func wsRoomCommand(r *http.Request, roomCode, action string, run func(roomID int) (string, error)) string {
+	roomID, err := getRoomIDByCode(db, roomCode)
+	if err == sql.ErrNoRows {
+		return "http.room_not_found"
+	}
+	if err != nil {
+		log.Printf("Error querying room: %v", err)
+		return "http.database_error"
+	}
+
+	allowed, err := checkRoomAction(r, roomID, action)
+	if err != nil {
+		log.Printf("Error checking room role: %v", err)
+		return "http.database_error"
+	}
+	if !allowed {
+		return "http.room_forbidden"
+	}
+
+	errKey, err := run(roomID)
+	if err != nil {
+		log.Printf("Error running websocket command: %v", err)
+		return "http.database_error"
+	}
+	return errKey
+}
+
+// wsReply отвечает одному клиенту, а не всем подключенным
+func wsReply(conn *websocket.Conn, msg interface{}) {
+	wsWriteMu.Lock()
+	defer wsWriteMu.Unlock()
+	if err := conn.WriteJSON(msg); err != nil {
+		log.Printf("WebSocket write error: %v", err)
+	}
+}
+
+// wsSend отправляет сообщение всем клиентам. После остановки сервера
+// рассылка уже не работает, и сообщение отбрасывается, а не вешает отправителя.
+func wsSend(msg interface{}) {
+	select {
+	case wsBroadcast <- msg:
+	case <-shutdownCh:
 	}
 }
 
 func wsBroadcastMessages() {
 	for {
-		msg := <-wsBroadcast
+		var msg interface{}
+		select {
+		case <-shutdownCh:
+			return
+		case msg = <-wsBroadcast:
+		}
+
+		wsWriteMu.Lock()
 		for client := range wsClients {
 			err := client.WriteJSON(msg)
 			if err != nil {
@@ -1122,16 +1300,21 @@ func wsBroadcastMessages() {
 				delete(wsClients, client)
 			}
 		}
+		wsWriteMu.Unlock()
 	}
 }
 
 type TrackInfo struct {
-	TrackID  int    `json:"track_id"`
-	Title    string `json:"title"`
-	Artist   string `json:"artist"`
-	TrackURL string `json:"track_url"`
-	CoverURI string `json:"cover_uri"`
-	Position int    `json:"position"`
+	TrackID    int    `json:"track_id"`
+	Title      string `json:"title"`
+	Artist     string `json:"artist"`
+	TrackURL   string `json:"track_url"`
+	CoverURI   string `json:"cover_uri"`
+	Position   int    `json:"position"`
+	DurationMs int    `json:"duration_ms"`
+	DateAdded  string `json:"date_added,omitempty"`
+	AddedBy    string `json:"added_by,omitempty"`
+	Source     string `json:"source,omitempty"`
 }
 
 // getTrackInfo retrieves complete track information from Yandex Music
@@ -1176,78 +1359,47 @@ func getTrackInfo(ctx context.Context, trackID int, client *yamusic.Client) (*Tr
 	}
 
 	return &TrackInfo{
-		TrackID:  trackID,
-		Title:    trackInfo.Result[0].Title,
-		Artist:   trackInfo.Result[0].Artists[0].Name,
-		TrackURL: trackURL,
-		CoverURI: coverURI,
-		Position: position,
+		TrackID:    trackID,
+		Title:      trackInfo.Result[0].Title,
+		Artist:     trackInfo.Result[0].Artists[0].Name,
+		TrackURL:   trackURL,
+		CoverURI:   coverURI,
+		Position:   position,
+		DurationMs: trackInfo.Result[0].DurationMs,
 	}, nil
 }
 
-// getTrackInfoHandler handles HTTP requests for track information
-func getTrackInfoHandler(w http.ResponseWriter, r *http.Request) {
-
-	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// CORS разрешить использование для всех запросов
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get and validate track ID from query parameters
-	trackIDStr := r.URL.Query().Get("trackID")
-	if trackIDStr == "" {
-		http.Error(w, `{"error":"trackID is required"}`, http.StatusBadRequest)
-		return
-	}
-
-	trackID, err := strconv.Atoi(trackIDStr)
-	if err != nil {
-		http.Error(w, `{"error":"invalid trackID format"}`, http.StatusBadRequest)
+// Создание комнаты. Старый адрес, то же самое - POST /api/v1/rooms.
+func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, code, ok := createRoom(w, r)
+	if !ok {
 		return
 	}
 
-	// Get track information with context
-	trackInfo, err := getTrackInfo(r.Context(), trackID, client)
-	if err != nil {
-		log.Printf("Error getting track info: %v", err)
-		statusCode := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "not found") {
-			statusCode = http.StatusNotFound
-		}
-		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), statusCode)
-		return
+	// Отправляем ответ с кодом комнаты
+	response := struct {
+		ID   int64  `json:"id"`
+		Code string `json:"code"`
+	}{
+		ID:   roomID,
+		Code: code,
 	}
 
-	// Encode and send response
-	if err := json.NewEncoder(w).Encode(trackInfo); err != nil {
-		log.Printf("Error encoding track info response: %v", err)
-		http.Error(w, `{"error":"failed to encode response"}`, http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+// createRoom создает комнату, создатель становится ее хозяином.
+// При ошибке сам отвечает клиенту и возвращает false.
+func createRoom(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	db, err := openDB()
-	if err != nil {
-		log.Printf("Failed to open database: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return 0, "", false
 	}
-	defer db.Close()
 
-	// Генерируем уникальный код комнаты (6 символов)
+	// Генерируем уникальный код комнаты
 	code := generateRoomCode(5)
 
 	// Создаем комнату в БД
@@ -1258,24 +1410,23 @@ func createRoomHandler(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		log.Printf("Error creating room: %v", err)
-		http.Error(w, "Error creating room", http.StatusInternalServerError)
-		return
+		httpError(w, r, "http.create_room", http.StatusInternalServerError)
+		return 0, "", false
 	}
 
 	// Получаем ID созданной комнаты
 	roomID, _ := result.LastInsertId()
 
-	// Отправляем ответ с кодом комнаты
-	response := struct {
-		ID   int64  `json:"id"`
-		Code string `json:"code"`
-	}{
-		ID:   roomID,
-		Code: code,
+	// Создатель становится хозяином комнаты
+	if member, ok := requestMember(r); ok {
+		if err := setRoomCreator(db, int(roomID), member); err != nil {
+			log.Printf("Error saving room creator: %v", err)
+			httpError(w, r, "http.create_room", http.StatusInternalServerError)
+			return 0, "", false
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return roomID, code, true
 }
 
 // SQL для создания таблицы rooms
@@ -1289,36 +1440,57 @@ func createRoomsTable(db *sql.DB) error {
 	return err
 }
 
+// Вход в комнату по коду. Старый адрес, то же самое - POST /api/v1/rooms/{code}/join.
 func joinRoomHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// Чтение данных из тела запроса
-	var requestData struct {
-		RoomCode string `json:"room_code"`
-	}
 
-	// Декодируем JSON в структуру
-	err := json.NewDecoder(r.Body).Decode(&requestData)
-	if err != nil {
-		log.Printf("Error decoding JSON: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
-		return
+	// В /api/v1 код комнаты - часть пути, в старом адресе - в теле запроса
+	roomCode := r.PathValue("code")
+	if roomCode == "" {
+		var requestData struct {
+			RoomCode string `json:"room_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			log.Printf("Error decoding JSON: %v", err)
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+		roomCode = requestData.RoomCode
 	}
 
 	// Проверяем, существует ли комната с таким кодом
-	var roomID int
-	err = db.QueryRow("SELECT id FROM rooms WHERE code = ?", requestData.RoomCode).Scan(&roomID)
+	roomID, err := getRoomIDByCode(db, roomCode)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.room_not_found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error checking room existence: %v", err)
-		http.Error(w, "Error checking room existence", http.StatusInternalServerError)
+		httpError(w, r, "http.room_check", http.StatusInternalServerError)
 		return
 	}
 
+	// Вошедший по коду становится гостем, исключенных обратно не пускаем
+	if member, ok := requestMember(r); ok {
+		role, err := joinRoomAsGuest(db, roomID, member)
+		if err != nil {
+			log.Printf("Error joining room: %v", err)
+			httpError(w, r, "http.room_check", http.StatusInternalServerError)
+			return
+		}
+		if role == roomRoleKicked {
+			httpError(w, r, "http.room_kicked", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Отправляем ответ с ID комнаты
 	response := struct {
 		RoomID int `json:"room_id"`
@@ -1326,9 +1498,10 @@ func joinRoomHandler(w http.ResponseWriter, r *http.Request) {
 		RoomID: roomID,
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		httpError(w, r, "http.encode_response", http.StatusInternalServerError)
 	}
 }
 
@@ -1343,8 +1516,9 @@ func generateRoomCode(length int) string {
 
 func getRoomPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 
+	client := defaultClient()
 	if db == nil || client == nil {
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -1352,13 +1526,13 @@ func getRoomPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 
 	roomIDStr := r.URL.Query().Get("roomID")
 	if roomIDStr == "" {
-		http.Error(w, "Room ID is required", http.StatusBadRequest)
+		httpError(w, r, "http.room_id_required", http.StatusBadRequest)
 		return
 	}
 
 	roomID, err := strconv.Atoi(roomIDStr)
 	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		httpError(w, r, "http.invalid_room_id", http.StatusBadRequest)
 		return
 	}
 
@@ -1366,7 +1540,7 @@ func getRoomPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 	tracks, err := getRoomTracks(roomID)
 	if err != nil {
 		log.Printf("Error getting room tracks: %v", err)
-		http.Error(w, "Error getting room tracks", http.StatusInternalServerError)
+		httpError(w, r, "http.room_tracks", http.StatusInternalServerError)
 		return
 	}
 
@@ -1374,11 +1548,14 @@ func getRoomPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(tracks); err != nil {
 		log.Printf("Error encoding room tracks: %v", err)
-		http.Error(w, "Error encoding room tracks", http.StatusInternalServerError)
+		httpError(w, r, "http.encode_response", http.StatusInternalServerError)
 	}
 }
 
 func getRoomTracks(roomID int) ([]TrackInfo, error) {
+	// Треки комнаты запрашиваются от имени выбранного в ней аккаунта
+	roomClient := clientForRoom(roomID)
+
 	rows, err := db.Query("SELECT track_id FROM playlist WHERE room_id = ? ORDER BY position", roomID)
 	if err != nil {
 		return nil, err
@@ -1392,7 +1569,7 @@ func getRoomTracks(roomID int) ([]TrackInfo, error) {
 			return nil, err
 		}
 
-		trackInfo, err := getTrackInfo(context.Background(), trackID, client)
+		trackInfo, err := getTrackInfo(context.Background(), trackID, roomClient)
 		if err != nil {
 			log.Printf("Error getting track info: %v", err)
 			continue
@@ -1424,26 +1601,78 @@ func isExistRoomCode(code string) (bool, error) {
 	fetch("/api/track?trackID=12345")
 */
 
+// registerRoutes регистрирует все страницы и API сервера
+func registerRoutes(mux *routeMux) {
+	fs := http.FileServer(http.Dir(appConfig.StaticDir))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/lang", setLanguageHandler)
+
+	// Мастер настройки доступен всегда. Пока нет администратора или аккаунта
+	// Яндекс.Музыки, requireSetup перенаправляет на него все страницы.
+	mux.HandleFunc("/setup", setupHandler)
+	mux.HandleFunc("/", indexHandler)
+	mux.HandleFunc("/debug", requireTOTP(debugHandler))
+	mux.HandleFunc("/settings", requireTOTP(saveSettingsHandler))
+	mux.HandleFunc("/page/settings", requireTOTP(settingsTemplate))
+	mux.HandleFunc("/get-track", getTrackHandler)
+	mux.HandleFunc("/playlist", playlistHandler)
+	mux.HandleFunc("/add-track", requireBotRole(roleDJ, addTrackToPlaylistHandler))
+	mux.HandleFunc("/api/tracks", requireScope(scopePlaylistRead, apiTracksHandler))
+	mux.HandleFunc("/api/tracks/changeposition", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, changeTrackPosition)))
+	mux.HandleFunc("/api/tracks/delete", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, deleteTrackFromPlaylistHandler)))
+	mux.HandleFunc("/api/tracks/all", requireScope(scopePlaylistRead, getDBTracksIDHandler))
+	mux.HandleFunc("/api/room/join", requireScope(scopePlaylistRead, joinRoomHandler))
+	mux.HandleFunc("/api/room/create", requireScope(scopePlaylistWrite, requireBotRole(roleDJ, requireTOTP(createRoomHandler))))
+	mux.HandleFunc("/api/room/members", requireScope(scopePlaylistRead, roomMembersHandler))
+	mux.HandleFunc("/api/room/members/role", requireScope(scopePlaylistWrite, roomMemberRoleHandler))
+	mux.HandleFunc("/api/room/members/kick", requireScope(scopePlaylistWrite, roomMemberRoleHandler))
+	mux.HandleFunc("/api/room/account", requireScope(scopePlaylistWrite, requireTOTP(roomAccountHandler)))
+	mux.HandleFunc("/api/accounts", requireScope(scopePlaylistRead, yandexAccountsHandler))
+	mux.HandleFunc("/api/player/control", requireScope(scopePlayback, requireBotRole(roleDJ, playerControlHandler)))
+	mux.HandleFunc("/api/tokens", requireScope(scopeAdmin, apiTokensHandler))
+	mux.HandleFunc("/api/tokens/create", requireScope(scopeAdmin, requireTOTP(createAPITokenHandler)))
+	mux.HandleFunc("/api/tokens/revoke", requireScope(scopeAdmin, revokeAPITokenHandler))
+	mux.HandleFunc("/api/telegram/auth", telegramAuthHandler)
+	mux.HandleFunc("/tg/app", miniAppHandler)
+	mux.HandleFunc("/login", loginHandler)
+	mux.HandleFunc("/login/2fa", loginTOTPHandler)
+	mux.HandleFunc("/account/2fa", accountTOTPHandler)
+	mux.HandleFunc("/account/tokens", requireTOTP(accountTokensHandler))
+	mux.HandleFunc("/logout", logoutHandler)
+	mux.HandleFunc("/users", requireAdmin(requireTOTP(usersHandler)))
+	registerAPIv1(mux)
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /api/docs", apiDocsHandler)
+}
+
 func main() {
-	var err error
-	db, err = openDB()
+	serverConfig, err := loadServerConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		log.Fatal("Invalid configuration: ", err)
 	}
-	defer db.Close()
+	appConfig = serverConfig
+	registerLogSecret(appConfig.TelegramToken)
 
-	mux := http.NewServeMux() // Создаем новый мультиплексор
+	initDatabase()
 
-	fs := http.FileServer(http.Dir(staticDir))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	db, err = openDB()
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	// База закрывается в shutdown, после остановки всех, кто в нее пишет
 
-	mux.HandleFunc("/ws", wsHandler)
 	go wsBroadcastMessages()
 
 	cfg := &Config{
-		TelegramToken: "YOUR_TELEGRAM",
+		TelegramToken: appConfig.TelegramToken,
+		OwnerID:       appConfig.TelegramOwnerID,
 		Database:      db,
-		YaMusicClient: client,
+	}
+	botCfg = cfg
+	if err := ensureBotOwner(db, cfg.OwnerID); err != nil {
+		log.Printf("Warning: failed to register bot owner: %v", err)
 	}
 
 	if cfg.TelegramToken != "" {
@@ -1451,33 +1680,88 @@ func main() {
 		go runTelegramBot(cfg)
 	}
 
-	if !dbExists() {
-		mux.HandleFunc("/", setupHandler) // Перенаправляем все запросы на setup
-		mux.HandleFunc("/setup", setupHandler)
-		log.Println("Database not found, redirecting to setup page...")
+	// Синхронизация импортированных плейлистов Яндекс.Музыки по расписанию
+	wg.Add(1)
+	go runPlaylistSync()
+
+	// Названия треков, добавленных старыми версиями, для фильтров и сортировки
+	wg.Add(1)
+	go backfillPlaylistMetadata()
+
+	mux := newRouteMux() // Мультиплексор, который запоминает маршруты для проверки описания API
+	registerRoutes(mux)
+	checkOpenAPICoverage(mux)
+
+	if step, err := refreshSetupState(db); err != nil {
+		log.Printf("Warning: failed to check setup state: %v", err)
+	} else if step != setupStepDone {
+		log.Println("Setup is not complete, redirecting to setup page...")
+	}
+
+	// Все, кроме статики и страницы входа, доступно только после входа,
+	// а до завершения настройки - только мастер настройки
+	handler := requireSetup(requireLogin(mux))
+
+	server := &http.Server{
+		Addr:    appConfig.Listen,
+		Handler: handler,
+	}
+	servers := []*http.Server{server}
+	serveErr := make(chan error, 1)
+
+	if appConfig.TLSCert == "" {
+		log.Printf("Starting server on %s", appConfig.Listen)
+		go func() { serveErr <- server.ListenAndServe() }()
 	} else {
-		mux.HandleFunc("/", indexHandler)
-		mux.HandleFunc("/debug", debugHandler)
-		mux.HandleFunc("/settings", saveSettingsHandler)
-		mux.HandleFunc("/page/settings", settingsTemplate)
-		mux.HandleFunc("/get-track", getTrackHandler)
-		mux.HandleFunc("/playlist", playlistHandler)
-		mux.HandleFunc("/add-track", addTrackToPlaylistHandler)
-		mux.HandleFunc("/api/tracks", apiTracksHandler)
-		mux.HandleFunc("/api/tracks/changeposition", changeTrackPosition)
-		mux.HandleFunc("/api/tracks/delete", deleteTrackFromPlaylistHandler)
-		mux.HandleFunc("/api/tracks/all", getDBTracksIDHandler)
-		mux.HandleFunc("/api/room/join", joinRoomHandler)
-		mux.HandleFunc("/api/room/create", createRoomHandler)
-	}
-
-	log.Printf("Starting server on :%d", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		// Сертификат перечитывается с диска после продления без перезапуска
+		certs, err := newCertReloader(appConfig.TLSCert, appConfig.TLSKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.watch(shutdownCh)
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		if appConfig.HTTPRedirect != "" {
+			redirect := &http.Server{
+				Addr:    appConfig.HTTPRedirect,
+				Handler: httpsRedirectHandler(appConfig.Listen),
+			}
+			servers = append(servers, redirect)
+			go func() {
+				log.Printf("Redirecting HTTP on %s to HTTPS", appConfig.HTTPRedirect)
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Printf("HTTP redirect listener stopped: %v", err)
+				}
+			}()
+		}
+
+		log.Printf("Starting HTTPS server on %s", appConfig.Listen)
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+	}
+
+	// SIGINT (Ctrl+C) и SIGTERM (systemd, docker stop) - штатная остановка
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
 		log.Fatal(err)
+	case <-ctx.Done():
 	}
+	shutdown(servers)
 }
 
 func init() {
+	// Все логи проходят через фильтр секретов
+	log.SetOutput(logRedactor)
+}
+
+// initDatabase создает таблицы и загружает аккаунты. Вызывается из main
+// после загрузки конфигурации, потому что путь к базе задается в ней.
+func initDatabase() {
 	if err := createTableIfNotExists(); err != nil {
 		log.Printf("Warning: Error creating table: %v", err)
 		return
@@ -1490,14 +1774,19 @@ func init() {
 	}
 	defer db.Close()
 
-	var userID int
-	var accessToken string
+	// Токены, сохраненные старыми версиями открытым текстом, шифруем сразу
+	if err := migrateSecrets(db); err != nil {
+		log.Printf("Warning: Failed to encrypt stored secrets: %v", err)
+		return
+	}
 
-	row := db.QueryRow("SELECT user_id, access_token FROM settings WHERE id = 2")
-	if err := row.Scan(&userID, &accessToken); err != nil {
-		log.Printf("Warning: Could not load Yandex Music settings: %v", err)
+	// Раньше аккаунт был один и хранился в settings
+	if err := migrateLegacySettings(db); err != nil {
+		log.Printf("Warning: Could not migrate Yandex Music settings: %v", err)
 		return
 	}
 
-	client = yamusic.NewClient(yamusic.AccessToken(userID, accessToken))
+	if err := loadYandexAccounts(db); err != nil {
+		log.Printf("Warning: Could not load Yandex Music accounts: %v", err)
+	}
 }
@@ -0,0 +1,1108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Описание HTTP API в формате OpenAPI 3.1. Документ собирается в коде рядом
+// с маршрутами, отдается на /api/openapi.json и показывается на /api/docs.
+// При запуске checkOpenAPICoverage сверяет его с зарегистрированными
+// маршрутами, чтобы новый адрес не остался без описания.
+
+type jsonSchema map[string]interface{}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []openAPITag                            `json:"tags"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]jsonSchema `json:"schemas"`
+	SecuritySchemes map[string]jsonSchema `json:"securitySchemes"`
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string     `json:"name"`
+	In          string     `json:"in"`
+	Required    bool       `json:"required,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Schema      jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema jsonSchema `json:"schema"`
+}
+
+// Конструкторы схем
+
+func schemaRef(name string) jsonSchema {
+	return jsonSchema{"$ref": "#/components/schemas/" + name}
+}
+
+func schemaString(description string) jsonSchema {
+	s := jsonSchema{"type": "string"}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+func schemaInt(description string) jsonSchema {
+	s := jsonSchema{"type": "integer"}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+func schemaEnum(description string, values ...string) jsonSchema {
+	s := schemaString(description)
+	s["enum"] = values
+	return s
+}
+
+func schemaArray(items jsonSchema) jsonSchema {
+	return jsonSchema{"type": "array", "items": items}
+}
+
+// schemaObject - объект с полями; обязательные поля перечислены в required
+func schemaObject(properties map[string]jsonSchema, required ...string) jsonSchema {
+	s := jsonSchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// Конструкторы параметров, тел и ответов
+
+func pathParam(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "path", Required: true, Description: description, Schema: schemaString("")}
+}
+
+func queryParam(name, description string, required bool, schema jsonSchema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Required: required, Description: description, Schema: schema}
+}
+
+func jsonBody(schema jsonSchema) *openAPIRequestBody {
+	return &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{"application/json": {Schema: schema}}}
+}
+
+func formBody(schema jsonSchema) *openAPIRequestBody {
+	return &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{"application/x-www-form-urlencoded": {Schema: schema}}}
+}
+
+func jsonResponse(description string, schema jsonSchema) *openAPIResponse {
+	return &openAPIResponse{Description: description, Content: map[string]openAPIMediaType{"application/json": {Schema: schema}}}
+}
+
+func textResponse(description string) *openAPIResponse {
+	return &openAPIResponse{Description: description, Content: map[string]openAPIMediaType{"text/plain": {Schema: schemaString("")}}}
+}
+
+func htmlResponse(description string) *openAPIResponse {
+	return &openAPIResponse{Description: description, Content: map[string]openAPIMediaType{"text/html": {Schema: schemaString("")}}}
+}
+
+func emptyResponse(description string) *openAPIResponse {
+	return &openAPIResponse{Description: description}
+}
+
+// Пути без метода - это старые адреса, которые принимают любой метод
+// и сами проверяют его. В документе у них указан ожидаемый метод.
+func (d *openAPIDocument) add(method, path string, op *openAPIOperation) {
+	if d.Paths[path] == nil {
+		d.Paths[path] = make(map[string]*openAPIOperation)
+	}
+
+	// Ошибки: у /api/v1 - JSON с кодом, у остальных - текст на языке запроса
+	if op.Responses == nil {
+		op.Responses = make(map[string]*openAPIResponse)
+	}
+	if _, ok := op.Responses["default"]; !ok {
+		if strings.HasPrefix(path, apiV1Prefix) {
+			op.Responses["default"] = jsonResponse("Ошибка", schemaRef("Error"))
+		} else {
+			op.Responses["default"] = textResponse("Ошибка, текст на языке запроса")
+		}
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+// Требования авторизации: сессия веб-интерфейса или API-токен с правом scope
+func requiresScope(scope string) []map[string][]string {
+	return []map[string][]string{
+		{"session": {}},
+		{"telegramSession": {}},
+		{"bearer": {scope}},
+	}
+}
+
+var publicAccess = []map[string][]string{{}}
+
+var openAPISpec = buildOpenAPISpec()
+
+func buildOpenAPISpec() *openAPIDocument {
+	d := &openAPIDocument{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:   "MusicDirect API",
+			Version: "1",
+			Description: "Новым клиентам стоит использовать адреса /api/v1: ресурсы в пути, " +
+				"методы HTTP по назначению и ошибки в едином JSON-формате. " +
+				"Старые адреса сохранены для совместимости.",
+		},
+		Tags: []openAPITag{
+			{Name: "v1", Description: "Версионированное API"},
+			{Name: "tracks", Description: "Старые адреса плейлиста"},
+			{Name: "rooms", Description: "Старые адреса комнат"},
+			{Name: "accounts", Description: "Аккаунты Яндекс.Музыки и API-токены"},
+			{Name: "telegram", Description: "Telegram Mini App"},
+			{Name: "realtime", Description: "WebSocket плеера"},
+			{Name: "pages", Description: "HTML-страницы и формы веб-интерфейса"},
+			{Name: "docs", Description: "Это описание"},
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: map[string]jsonSchema{
+				"Error": schemaObject(map[string]jsonSchema{
+					"error": schemaObject(map[string]jsonSchema{
+						"code":    schemaString("Код ошибки, не зависит от языка, например room_not_found"),
+						"message": schemaString("Текст ошибки на языке запроса"),
+					}, "code", "message"),
+				}, "error"),
+				"Track": schemaObject(map[string]jsonSchema{
+					"track_id":    schemaInt("ID трека в Яндекс.Музыке"),
+					"title":       schemaString(""),
+					"artist":      schemaString(""),
+					"track_url":   schemaString("Временная ссылка на аудио"),
+					"cover_uri":   schemaString(""),
+					"position":    schemaInt("Позиция в плейлисте"),
+					"duration_ms": schemaInt(""),
+					"date_added":  schemaString("Время добавления, UTC"),
+					"added_by":    schemaString("Кто добавил: user:2 или telegram:123456"),
+					"source":      schemaEnum("Откуда добавлен", trackSourceWeb, trackSourceAPI, trackSourceTelegram, trackSourceSync),
+				}, "track_id"),
+				"Room": schemaObject(map[string]jsonSchema{
+					"id":   schemaInt(""),
+					"code": schemaString("Код для входа, 5 символов"),
+				}, "id", "code"),
+				"RoomMember": schemaObject(map[string]jsonSchema{
+					"member_type": schemaEnum("", memberUser, memberTelegram),
+					"member_id":   schemaInt("ID пользователя веб-интерфейса или Telegram"),
+					"name":        schemaString(""),
+					"role":        schemaEnum("", "guest", "dj", "host", "kicked"),
+					"joined_at":   jsonSchema{"type": "string", "format": "date-time"},
+				}, "member_type", "member_id", "role"),
+				"YandexAccount": schemaObject(map[string]jsonSchema{
+					"id":         schemaInt(""),
+					"label":      schemaString(""),
+					"user_id":    schemaInt("UID в Яндексе"),
+					"is_default": jsonSchema{"type": "boolean"},
+					"created_at": jsonSchema{"type": "string", "format": "date-time"},
+				}, "id", "label", "is_default"),
+				"APIToken": schemaObject(map[string]jsonSchema{
+					"id":           schemaInt(""),
+					"name":         schemaString(""),
+					"scopes":       schemaArray(schemaEnum("", allScopes...)),
+					"created_at":   jsonSchema{"type": "string", "format": "date-time"},
+					"last_used_at": jsonSchema{"type": []string{"string", "null"}, "format": "date-time"},
+				}, "id", "name", "scopes"),
+				"BulkAddResult": schemaObject(map[string]jsonSchema{
+					"title":   schemaString("Альбом, исполнитель или плейлист"),
+					"added":   schemaArray(schemaRef("BulkAddTrack")),
+					"skipped": schemaArray(schemaRef("BulkAddTrack")),
+				}, "title", "added", "skipped"),
+				"BulkAddTrack": schemaObject(map[string]jsonSchema{
+					"track_id": schemaInt(""),
+					"title":    schemaString(""),
+					"artist":   schemaString(""),
+					"reason":   schemaEnum("Почему пропущен", skipDuplicate, skipUnavailable, skipNotMatched),
+				}, "track_id"),
+				"YandexPlaylist": schemaObject(map[string]jsonSchema{
+					"id":          schemaString("Номер плейлиста или likes"),
+					"title":       schemaString(""),
+					"track_count": schemaInt(""),
+				}, "id", "title", "track_count"),
+				"ImportResult": jsonSchema{"allOf": []jsonSchema{
+					schemaRef("BulkAddResult"),
+					schemaObject(map[string]jsonSchema{
+						"mode":    schemaEnum("", importModeAppend, importModeReplace, importModeSync),
+						"removed": schemaInt("Удалено треков из комнаты"),
+						"sync":    schemaRef("PlaylistImport"),
+					}, "mode", "removed"),
+				}},
+				"PlaylistImport": schemaObject(map[string]jsonSchema{
+					"id":               schemaInt(""),
+					"owner_uid":        schemaInt("UID владельца плейлиста"),
+					"playlist":         schemaString("Номер плейлиста или likes"),
+					"title":            schemaString(""),
+					"interval_minutes": schemaInt(""),
+					"revision":         schemaInt("Ревизия источника при последней синхронизации"),
+					"last_sync_at":     jsonSchema{"type": "string", "format": "date-time"},
+					"last_error":       schemaString("Ошибка последней синхронизации"),
+				}, "id", "owner_uid", "playlist", "interval_minutes"),
+				"ExportResult": schemaObject(map[string]jsonSchema{
+					"kind":     schemaInt("Номер плейлиста в Яндекс.Музыке"),
+					"title":    schemaString(""),
+					"revision": schemaInt("Ревизия плейлиста после сохранения"),
+					"url":      schemaString("Ссылка на плейлист"),
+					"created":  jsonSchema{"type": "boolean"},
+					"exported": schemaInt("Треков в плейлисте"),
+					"failed":   schemaArray(schemaRef("ExportFailure")),
+				}, "kind", "title", "revision", "url", "created", "exported", "failed"),
+				"ExportFailure": schemaObject(map[string]jsonSchema{
+					"track_id": schemaInt(""),
+					"title":    schemaString(""),
+					"artist":   schemaString(""),
+					"reason":   schemaEnum("unavailable - недоступен, rejected - Яндекс не добавил", skipUnavailable, exportRejected),
+				}, "track_id", "reason"),
+				"SavedPlaylist": schemaObject(map[string]jsonSchema{
+					"id":          schemaInt(""),
+					"name":        schemaString(""),
+					"description": schemaString(""),
+					"cover_uri":   schemaString("Ссылка на обложку"),
+					"owner":       schemaString("Владелец: user:2 или telegram:123456"),
+					"track_count": schemaInt(""),
+					"created_at":  jsonSchema{"type": "string", "format": "date-time"},
+					"updated_at":  jsonSchema{"type": "string", "format": "date-time"},
+					"tracks":      schemaArray(schemaRef("SavedPlaylistTrack")),
+				}, "id", "name", "owner", "track_count"),
+				"SavedPlaylistTrack": schemaObject(map[string]jsonSchema{
+					"track_id":    schemaInt("ID трека в Яндекс.Музыке"),
+					"position":    schemaInt("Позиция, с 1"),
+					"title":       schemaString(""),
+					"artist":      schemaString(""),
+					"duration_ms": schemaInt(""),
+					"added_at":    jsonSchema{"type": "string", "format": "date-time"},
+				}, "track_id", "position"),
+				"PlaylistUpload": schemaObject(map[string]jsonSchema{
+					"id":          schemaInt(""),
+					"name":        schemaString("Название из файла или имя файла"),
+					"format":      schemaEnum("", playlistFormatM3U8, playlistFormatXSPF, playlistFormatJSPF, playlistFormatCSV),
+					"uploaded_by": schemaString(""),
+					"created_at":  jsonSchema{"type": "string", "format": "date-time"},
+					"entries":     schemaArray(schemaRef("UploadEntry")),
+					"summary":     jsonSchema{"type": "object", "description": "Число записей по статусам", "additionalProperties": schemaInt("")},
+				}, "id", "name", "format", "entries", "summary"),
+				"UploadEntry": schemaObject(map[string]jsonSchema{
+					"position":    schemaInt("Номер записи, ключ в choices"),
+					"title":       schemaString("Из файла"),
+					"artist":      schemaString("Из файла"),
+					"duration_ms": schemaInt(""),
+					"location":    schemaString("Путь или ссылка из файла"),
+					"status": schemaEnum("link - ссылка, matched - найден уверенно, ambiguous - нужен выбор, not_found - не найден",
+						uploadLink, uploadMatched, uploadAmbiguous, uploadNotFound),
+					"match":      schemaRef("UploadCandidate"),
+					"candidates": schemaArray(schemaRef("UploadCandidate")),
+				}, "position", "status"),
+				"UploadCandidate": schemaObject(map[string]jsonSchema{
+					"track_id":    schemaInt("ID трека в Яндекс.Музыке"),
+					"title":       schemaString(""),
+					"artist":      schemaString(""),
+					"duration_ms": schemaInt(""),
+					"confidence":  jsonSchema{"type": "number", "description": "Уверенность от 0 до 1"},
+				}, "track_id", "confidence"),
+				"Success": schemaObject(map[string]jsonSchema{
+					"success": jsonSchema{"type": "boolean"},
+				}, "success"),
+			},
+			SecuritySchemes: map[string]jsonSchema{
+				"session":         {"type": "apiKey", "in": "cookie", "name": sessionCookieName, "description": "Сессия после входа на /login"},
+				"telegramSession": {"type": "apiKey", "in": "cookie", "name": telegramSessionName, "description": "Сессия Mini App после /api/telegram/auth"},
+				"bearer":          {"type": "http", "scheme": "bearer", "description": "API-токен md_... со страницы /account/tokens"},
+			},
+		},
+	}
+
+	addV1Spec(d)
+	addLegacySpec(d)
+	addPagesSpec(d)
+	return d
+}
+
+func addV1Spec(d *openAPIDocument) {
+	v1 := []string{"v1"}
+	code := pathParam("code", "Код комнаты")
+	trackID := pathParam("id", "ID трека в Яндекс.Музыке")
+	memberType := pathParam("type", "Тип участника: user или telegram")
+	memberID := pathParam("id", "ID участника")
+
+	d.add("POST", "/api/v1/rooms", &openAPIOperation{
+		Summary: "Создать комнату", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Создатель становится хозяином комнаты. При включенной 2FA нужен подтвержденный вход.",
+		Responses:   map[string]*openAPIResponse{"201": jsonResponse("Комната создана", schemaRef("Room"))},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/join", &openAPIOperation{
+		Summary: "Войти в комнату", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{code},
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Участник стал гостем комнаты",
+			schemaObject(map[string]jsonSchema{"room_id": schemaInt("")}, "room_id"))},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/tracks", &openAPIOperation{
+		Summary: "Треки комнаты", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: append([]openAPIParameter{code}, trackListParams()...),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Страница треков, по умолчанию 50",
+			schemaObject(map[string]jsonSchema{
+				"tracks":      schemaArray(schemaRef("Track")),
+				"total":       schemaInt("Число треков с учетом фильтров"),
+				"next_cursor": schemaString("Курсор следующей страницы, нет на последней"),
+			}, "tracks", "total"))},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/tracks", &openAPIOperation{
+		Summary: "Добавить трек, альбом, исполнителя или плейлист", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: musicLinkDescription,
+		Parameters:  []openAPIParameter{code},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"track_url": schemaString("Ссылка Яндекс.Музыки или ID трека"),
+		}, "track_url")),
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Трек (Track) или итог добавления (BulkAddResult)",
+			jsonSchema{"oneOf": []jsonSchema{schemaRef("Track"), schemaRef("BulkAddResult")}})},
+	})
+	d.add("PATCH", "/api/v1/rooms/{code}/tracks/{id}", &openAPIOperation{
+		Summary: "Переместить трек", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Parameters: []openAPIParameter{code, trackID},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"position": schemaInt("Новая позиция"),
+		}, "position")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Позиция изменена", schemaObject(map[string]jsonSchema{
+			"track_id": schemaInt(""),
+			"position": schemaInt(""),
+		}))},
+	})
+	d.add("DELETE", "/api/v1/rooms/{code}/tracks/{id}", &openAPIOperation{
+		Summary: "Удалить трек", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Parameters: []openAPIParameter{code, trackID},
+		Responses:  map[string]*openAPIResponse{"204": emptyResponse("Трек удален")},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/members", &openAPIOperation{
+		Summary: "Участники комнаты", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{code},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Участники", schemaArray(schemaRef("RoomMember")))},
+	})
+	d.add("PUT", "/api/v1/rooms/{code}/members/{type}/{id}", &openAPIOperation{
+		Summary: "Сменить роль участника", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Только хозяин комнаты или администратор. Свою роль сменить нельзя.",
+		Parameters:  []openAPIParameter{code, memberType, memberID},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"role": schemaEnum("", "guest", "dj", "host", "kicked"),
+		}, "role")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Роль изменена", schemaRef("RoomMember"))},
+	})
+	d.add("DELETE", "/api/v1/rooms/{code}/members/{type}/{id}", &openAPIOperation{
+		Summary: "Исключить участника", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Исключенный не может снова войти по коду.",
+		Parameters:  []openAPIParameter{code, memberType, memberID},
+		Responses:   map[string]*openAPIResponse{"204": emptyResponse("Участник исключен")},
+	})
+	d.add("PUT", "/api/v1/rooms/{code}/account", &openAPIOperation{
+		Summary: "Выбрать аккаунт Яндекс.Музыки комнаты", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Parameters: []openAPIParameter{code},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"account_id": schemaInt("0 - аккаунт по умолчанию"),
+		}, "account_id")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Аккаунт выбран", schemaRef("Success"))},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/yandex/playlists", &openAPIOperation{
+		Summary: "Плейлисты аккаунта Яндекс.Музыки комнаты", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Description: "Первым идет \"Мне нравится\" (id likes).",
+		Parameters:  []openAPIParameter{code},
+		Responses:   map[string]*openAPIResponse{"200": jsonResponse("Плейлисты", schemaArray(schemaRef("YandexPlaylist")))},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/imports", &openAPIOperation{
+		Summary: "Импортировать плейлист в комнату", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "append добавляет треки в конец, replace заменяет плейлист комнаты, sync добавляет и затем " +
+			"по расписанию подтягивает изменения, удаляя только добавленные им треки. " +
+			"replace и sync требуют права на удаление треков.",
+		Parameters: []openAPIParameter{code},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"playlist":         schemaString("Номер плейлиста или likes"),
+			"mode":             schemaEnum("По умолчанию append", importModeAppend, importModeReplace, importModeSync),
+			"interval_minutes": schemaInt("Для sync: интервал, по умолчанию 60, не меньше 15"),
+		}, "playlist")),
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Итог импорта", schemaRef("ImportResult"))},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/imports", &openAPIOperation{
+		Summary: "Синхронизируемые плейлисты комнаты", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{code},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Синхронизации", schemaArray(schemaRef("PlaylistImport")))},
+	})
+	d.add("DELETE", "/api/v1/rooms/{code}/imports/{id}", &openAPIOperation{
+		Summary: "Остановить синхронизацию", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Добавленные синхронизацией треки остаются в комнате.",
+		Parameters:  []openAPIParameter{code, pathParam("id", "ID синхронизации")},
+		Responses:   map[string]*openAPIResponse{"204": emptyResponse("Синхронизация остановлена")},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/export", &openAPIOperation{
+		Summary: "Сохранить плейлист комнаты в Яндекс.Музыку", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Только хозяин комнаты. Треки плейлиста в Яндексе заменяются треками комнаты по порядку. " +
+			"Без kind обновляется плейлист прошлого сохранения или создается новый с названием title. " +
+			"Тело можно не передавать.",
+		Parameters: []openAPIParameter{code},
+		RequestBody: &openAPIRequestBody{Content: map[string]openAPIMediaType{"application/json": {Schema: schemaObject(map[string]jsonSchema{
+			"kind":  schemaInt("Номер существующего плейлиста"),
+			"title": schemaString("Название нового плейлиста, по умолчанию MusicDirect и код комнаты"),
+		})}}},
+		Responses: map[string]*openAPIResponse{
+			"200": jsonResponse("Плейлист обновлен", schemaRef("ExportResult")),
+			"201": jsonResponse("Плейлист создан", schemaRef("ExportResult")),
+		},
+	})
+	uploadID := pathParam("id", "ID загруженного файла")
+	d.add("POST", "/api/v1/rooms/{code}/uploads", &openAPIOperation{
+		Summary: "Загрузить файл плейлиста", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "M3U, XSPF, JSPF или CSV до 2 МБ и 1000 записей вместе с треками альбомов и плейлистов по ссылкам. Треки не добавляются: ссылки Яндекс.Музыки " +
+			"разбираются сразу, остальные записи ищутся по исполнителю и названию. Результат хранится сутки " +
+			"и добавляется в комнату через confirm.",
+		Parameters: []openAPIParameter{code,
+			queryParam("format", "Формат, если его не понять по имени и содержимому", false,
+				schemaEnum("", playlistFormatM3U8, playlistFormatXSPF, playlistFormatJSPF, playlistFormatCSV)),
+			queryParam("name", "Имя файла, если он передан в теле", false, schemaString(""))},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"multipart/form-data":      {Schema: schemaObject(map[string]jsonSchema{"file": {"type": "string", "format": "binary"}}, "file")},
+			"application/octet-stream": {Schema: jsonSchema{"type": "string", "format": "binary"}},
+		}},
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Результат сопоставления", schemaRef("PlaylistUpload"))},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/uploads/{id}", &openAPIOperation{
+		Summary: "Результат сопоставления файла", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{code, uploadID},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Загруженный файл", schemaRef("PlaylistUpload"))},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/uploads/{id}/confirm", &openAPIOperation{
+		Summary: "Добавить треки из файла", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Добавляются ссылки и уверенные совпадения; сомнительные записи - если для них выбран трек " +
+			"в choices или передан accept_ambiguous. Трек не из кандидатов проверяется в Яндекс.Музыке, недоступный " +
+			"пропускается с причиной unavailable. Тело можно не передавать. После добавления файл удаляется.",
+		Parameters: []openAPIParameter{code, uploadID},
+		RequestBody: &openAPIRequestBody{Content: map[string]openAPIMediaType{"application/json": {Schema: schemaObject(map[string]jsonSchema{
+			"choices": jsonSchema{"type": "object", "description": "Номер записи -> ID трека, 0 - пропустить запись",
+				"additionalProperties": schemaInt("")},
+			"accept_ambiguous": jsonSchema{"type": "boolean", "description": "Взять лучших кандидатов для сомнительных записей"},
+		})}}},
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Итог добавления", schemaRef("BulkAddResult"))},
+	})
+	d.add("DELETE", "/api/v1/rooms/{code}/uploads/{id}", &openAPIOperation{
+		Summary: "Отменить импорт файла", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Parameters: []openAPIParameter{code, uploadID},
+		Responses:  map[string]*openAPIResponse{"204": emptyResponse("Файл удален")},
+	})
+	d.add("GET", "/api/v1/rooms/{code}/export/{format}", &openAPIOperation{
+		Summary: "Очередь комнаты файлом плейлиста", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Description: playlistFileDescription,
+		Parameters:  append([]openAPIParameter{code}, playlistFileParams()...),
+		Responses:   map[string]*openAPIResponse{"200": playlistFileResponse()},
+	})
+	d.add("POST", "/api/v1/rooms/{code}/player", &openAPIOperation{
+		Summary: "Управление плеером", Tags: v1, Security: requiresScope(scopePlayback),
+		Parameters: []openAPIParameter{code},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"action": schemaEnum("", "next", "prev", "pause", "now"),
+		}, "action")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Команда отправлена плеерам", schemaRef("Success"))},
+	})
+	d.add("GET", "/api/v1/tracks/{id}", &openAPIOperation{
+		Summary: "Данные трека из Яндекс.Музыки", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{trackID,
+			queryParam("room_code", "Запросить через аккаунт комнаты", false, schemaString(""))},
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Трек", schemaRef("Track"))},
+	})
+	d.add("GET", "/api/v1/tracks/{id}/stream", &openAPIOperation{
+		Summary: "Воспроизвести трек", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Description: "Перенаправляет на временную ссылку Яндекс.Музыки. " +
+			"С действующими expires и sig из файла плейлиста работает без входа.",
+		Parameters: []openAPIParameter{trackID,
+			queryParam("room_code", "Воспроизвести через аккаунт комнаты", false, schemaString("")),
+			queryParam("expires", "Срок подписанной ссылки, Unix-время", false, schemaInt("")),
+			queryParam("sig", "Подпись ссылки", false, schemaString(""))},
+		Responses: map[string]*openAPIResponse{"302": emptyResponse("Перенаправление на аудиофайл")},
+	})
+	playlistID := pathParam("id", "ID сохраненного плейлиста")
+	playlistTrackID := pathParam("track_id", "ID трека")
+	playlistFields := map[string]jsonSchema{
+		"name":        schemaString("До 200 символов"),
+		"description": schemaString(""),
+		"cover_uri":   schemaString("Ссылка на обложку"),
+	}
+	d.add("GET", "/api/v1/playlists", &openAPIOperation{
+		Summary: "Сохраненные плейлисты", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{queryParam("owner", "me - только свои, user:2 - плейлисты пользователя", false, schemaString(""))},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Плейлисты без треков", schemaArray(schemaRef("SavedPlaylist")))},
+	})
+	d.add("POST", "/api/v1/playlists", &openAPIOperation{
+		Summary: "Создать плейлист", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "С room_code плейлист создается из очереди комнаты в ее порядке.",
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"name":        playlistFields["name"],
+			"description": playlistFields["description"],
+			"cover_uri":   playlistFields["cover_uri"],
+			"room_code":   schemaString("Сохранить очередь этой комнаты"),
+		}, "name")),
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Плейлист создан", schemaRef("SavedPlaylist"))},
+	})
+	d.add("GET", "/api/v1/playlists/{id}", &openAPIOperation{
+		Summary: "Плейлист с треками", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{playlistID},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Плейлист", schemaRef("SavedPlaylist"))},
+	})
+	d.add("PATCH", "/api/v1/playlists/{id}", &openAPIOperation{
+		Summary: "Изменить плейлист", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Только владелец или администратор. Не переданные поля не меняются.",
+		Parameters:  []openAPIParameter{playlistID},
+		RequestBody: jsonBody(schemaObject(playlistFields)),
+		Responses:   map[string]*openAPIResponse{"200": jsonResponse("Плейлист изменен", schemaRef("SavedPlaylist"))},
+	})
+	d.add("DELETE", "/api/v1/playlists/{id}", &openAPIOperation{
+		Summary: "Удалить плейлист", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Только владелец или администратор.",
+		Parameters:  []openAPIParameter{playlistID},
+		Responses:   map[string]*openAPIResponse{"204": emptyResponse("Плейлист удален")},
+	})
+	d.add("POST", "/api/v1/playlists/{id}/tracks", &openAPIOperation{
+		Summary: "Добавить треки в плейлист", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Только владелец или администратор. " + musicLinkDescription,
+		Parameters:  []openAPIParameter{playlistID},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"track_url": schemaString("Ссылка Яндекс.Музыки или ID трека"),
+		}, "track_url")),
+		Responses: map[string]*openAPIResponse{"201": jsonResponse("Итог добавления", schemaRef("BulkAddResult"))},
+	})
+	d.add("PATCH", "/api/v1/playlists/{id}/tracks/{track_id}", &openAPIOperation{
+		Summary: "Переместить трек в плейлисте", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Остальные треки сдвигаются; позиция за концом списка ставит трек последним.",
+		Parameters:  []openAPIParameter{playlistID, playlistTrackID},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{"position": schemaInt("Новая позиция, с 1")}, "position")),
+		Responses:   map[string]*openAPIResponse{"200": jsonResponse("Плейлист после перемещения", schemaRef("SavedPlaylist"))},
+	})
+	d.add("DELETE", "/api/v1/playlists/{id}/tracks/{track_id}", &openAPIOperation{
+		Summary: "Удалить трек из плейлиста", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Parameters: []openAPIParameter{playlistID, playlistTrackID},
+		Responses:  map[string]*openAPIResponse{"204": emptyResponse("Трек удален")},
+	})
+	d.add("GET", "/api/v1/playlists/{id}/export/{format}", &openAPIOperation{
+		Summary: "Плейлист файлом", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Description: playlistFileDescription + " Треки играют через аккаунт по умолчанию.",
+		Parameters:  append([]openAPIParameter{playlistID}, playlistFileParams()...),
+		Responses:   map[string]*openAPIResponse{"200": playlistFileResponse()},
+	})
+	d.add("POST", "/api/v1/playlists/{id}/queue", &openAPIOperation{
+		Summary: "Поставить плейлист в очередь комнаты", Tags: v1, Security: requiresScope(scopePlaylistWrite),
+		Description: "Треки добавляются в конец очереди; уже добавленные в какую-либо комнату пропускаются.",
+		Parameters:  []openAPIParameter{playlistID},
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{"room_code": schemaString("")}, "room_code")),
+		Responses:   map[string]*openAPIResponse{"201": jsonResponse("Итог добавления", schemaRef("BulkAddResult"))},
+	})
+	d.add("GET", "/api/v1/accounts", &openAPIOperation{
+		Summary: "Аккаунты Яндекс.Музыки", Tags: v1, Security: requiresScope(scopePlaylistRead),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Аккаунты без токенов", schemaArray(schemaRef("YandexAccount")))},
+	})
+	d.add("GET", "/api/v1/tokens", &openAPIOperation{
+		Summary: "Свои API-токены", Tags: v1, Security: requiresScope(scopeAdmin),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Токены без секретов", schemaArray(schemaRef("APIToken")))},
+	})
+	d.add("POST", "/api/v1/tokens", &openAPIOperation{
+		Summary: "Создать API-токен", Tags: v1, Security: requiresScope(scopeAdmin),
+		RequestBody: jsonBody(tokenCreateSchema()),
+		Responses:   map[string]*openAPIResponse{"201": jsonResponse("Токен показывается один раз", tokenCreatedSchema())},
+	})
+	d.add("DELETE", "/api/v1/tokens/{id}", &openAPIOperation{
+		Summary: "Отозвать API-токен", Tags: v1, Security: requiresScope(scopeAdmin),
+		Parameters: []openAPIParameter{pathParam("id", "ID токена")},
+		Responses:  map[string]*openAPIResponse{"204": emptyResponse("Токен отозван")},
+	})
+}
+
+const playlistFileDescription = "Расширенный M3U, XSPF или JSPF с названием, исполнителем и длительностью треков. " +
+	"Ссылки ведут на /api/v1/tracks/{id}/stream; с signed=1 они подписаны и работают без входа до истечения срока."
+
+// playlistFileParams - формат и подпись ссылок файлов плейлистов
+func playlistFileParams() []openAPIParameter {
+	format := pathParam("format", "")
+	format.Schema = schemaEnum("Формат файла", playlistFormatM3U8, playlistFormatXSPF, playlistFormatJSPF)
+	return []openAPIParameter{format,
+		queryParam("signed", "1 - подписать ссылки", false, schemaString("")),
+		queryParam("ttl", fmt.Sprintf("Срок подписанных ссылок в часах, по умолчанию %d, не больше %d", defaultStreamLinkTTL, maxStreamLinkTTL), false, schemaInt("")),
+	}
+}
+
+func playlistFileResponse() *openAPIResponse {
+	content := make(map[string]openAPIMediaType, len(playlistFormatTypes))
+	for format, contentType := range playlistFormatTypes {
+		content[strings.SplitN(contentType, ";", 2)[0]] = openAPIMediaType{Schema: schemaString("Файл ." + format)}
+	}
+	return &openAPIResponse{Description: "Файл плейлиста", Content: content}
+}
+
+// trackListParams - фильтры, сортировка и страницы списков треков (parseTrackQuery)
+func trackListParams() []openAPIParameter {
+	date := schemaString("2024-05-01 или время в RFC 3339")
+	return []openAPIParameter{
+		queryParam("artist", "Часть имени исполнителя", false, schemaString("")),
+		queryParam("added_by", "Кто добавил: user:2 или telegram:123456", false, schemaString("")),
+		queryParam("source", "Откуда добавлен", false, schemaEnum("", trackSourceWeb, trackSourceAPI, trackSourceTelegram, trackSourceSync)),
+		queryParam("from", "Добавлены не раньше", false, date),
+		queryParam("to", "Добавлены не позже (дата - включительно)", false, date),
+		queryParam("sort", "Сортировка, по умолчанию position", false, schemaEnum("", "position", "date_added", "title")),
+		queryParam("order", "", false, schemaEnum("", "asc", "desc")),
+		queryParam("limit", fmt.Sprintf("Размер страницы, не больше %d", maxTrackPageSize), false, schemaInt("")),
+		queryParam("cursor", "Курсор из предыдущей страницы, с теми же sort и order", false, schemaString("")),
+	}
+}
+
+const musicLinkDescription = "Ссылка на альбом (/album/N), исполнителя (/artist/N, популярные треки) " +
+	"или плейлист (/users/LOGIN/playlists/N) добавляет все треки; уже добавленные и недоступные пропускаются."
+
+func tokenCreateSchema() jsonSchema {
+	return schemaObject(map[string]jsonSchema{
+		"name":   schemaString(""),
+		"scopes": schemaArray(schemaEnum("", allScopes...)),
+	}, "name", "scopes")
+}
+
+func tokenCreatedSchema() jsonSchema {
+	return jsonSchema{"allOf": []jsonSchema{
+		schemaRef("APIToken"),
+		schemaObject(map[string]jsonSchema{"token": schemaString("Секрет токена, md_...")}, "token"),
+	}}
+}
+
+func addLegacySpec(d *openAPIDocument) {
+	roomCode := schemaString("Код комнаты")
+	success := map[string]*openAPIResponse{"200": jsonResponse("Готово", schemaRef("Success"))}
+
+	d.add("POST", "/add-track", &openAPIOperation{
+		Summary: "Добавить трек, альбом, исполнителя или плейлист", Tags: []string{"tracks"}, Security: requiresScope(scopePlaylistWrite),
+		Description: "То же, что POST /api/v1/rooms/{code}/tracks. " + musicLinkDescription,
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"track_url": schemaString("Ссылка Яндекс.Музыки или ID трека"),
+			"room_code": roomCode,
+		}, "track_url", "room_code")),
+		Responses: map[string]*openAPIResponse{"201": {
+			Description: "Трек добавлен (текст) или итог добавления альбома, исполнителя, плейлиста (JSON)",
+			Content: map[string]openAPIMediaType{
+				"text/plain": {Schema: schemaString("")},
+				"application/json": {Schema: jsonSchema{"allOf": []jsonSchema{
+					schemaRef("BulkAddResult"),
+					schemaObject(map[string]jsonSchema{"message": schemaString("Итог на языке запроса")}),
+				}}},
+			},
+		}},
+	})
+	d.add("GET", "/api/tracks", &openAPIOperation{
+		Summary: "Плейлист с данными треков", Tags: []string{"tracks"}, Security: requiresScope(scopePlaylistRead),
+		Description: "Без limit возвращаются все треки. Общее число - в заголовке X-Total-Count, " +
+			"курсор следующей страницы - в X-Next-Cursor и Link.",
+		Parameters: append([]openAPIParameter{
+			queryParam("room_code", "Треки комнаты, через ее аккаунт; без кода - все комнаты", false, roomCode),
+		}, trackListParams()...),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Треки", schemaArray(schemaRef("Track")))},
+	})
+	d.add("GET", "/api/tracks/all", &openAPIOperation{
+		Summary: "ID треков", Tags: []string{"tracks"}, Security: requiresScope(scopePlaylistRead),
+		Description: "Те же параметры и заголовки, что у /api/tracks, без запросов к Яндекс.Музыке.",
+		Parameters: append([]openAPIParameter{
+			queryParam("room_code", "Треки комнаты; без кода - все комнаты", false, roomCode),
+		}, trackListParams()...),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("ID треков", schemaArray(schemaInt("")))},
+	})
+	d.add("POST", "/api/tracks/changeposition", &openAPIOperation{
+		Summary: "Переместить трек", Tags: []string{"tracks"}, Security: requiresScope(scopePlaylistWrite),
+		Description: "То же, что PATCH /api/v1/rooms/{code}/tracks/{id}.",
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"track_id":  schemaInt(""),
+			"position":  schemaInt(""),
+			"room_code": roomCode,
+		}, "track_id", "position", "room_code")),
+		Responses: map[string]*openAPIResponse{"200": emptyResponse("Позиция изменена")},
+	})
+	d.add("POST", "/api/tracks/delete", &openAPIOperation{
+		Summary: "Удалить трек", Tags: []string{"tracks"}, Security: requiresScope(scopePlaylistWrite),
+		Description: "То же, что DELETE /api/v1/rooms/{code}/tracks/{id}.",
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"track_id":  schemaInt(""),
+			"room_code": roomCode,
+		}, "track_id", "room_code")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Трек удален", schemaObject(map[string]jsonSchema{
+			"success": jsonSchema{"type": "boolean"},
+			"message": schemaString(""),
+		}))},
+	})
+
+	d.add("POST", "/api/room/create", &openAPIOperation{
+		Summary: "Создать комнату", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistWrite),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Комната создана", schemaRef("Room"))},
+	})
+	d.add("POST", "/api/room/join", &openAPIOperation{
+		Summary: "Войти в комнату", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistRead),
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{"room_code": roomCode}, "room_code")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Участник стал гостем комнаты",
+			schemaObject(map[string]jsonSchema{"room_id": schemaInt("")}, "room_id"))},
+	})
+	d.add("GET", "/api/room/members", &openAPIOperation{
+		Summary: "Участники комнаты", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistRead),
+		Parameters: []openAPIParameter{queryParam("room_code", "", true, roomCode)},
+		Responses:  map[string]*openAPIResponse{"200": jsonResponse("Участники", schemaArray(schemaRef("RoomMember")))},
+	})
+	memberBody := func(withRole bool) jsonSchema {
+		props := map[string]jsonSchema{
+			"room_code":   roomCode,
+			"member_type": schemaEnum("", memberUser, memberTelegram),
+			"member_id":   schemaInt(""),
+		}
+		if withRole {
+			props["role"] = schemaEnum("", "guest", "dj", "host", "kicked")
+			return schemaObject(props, "room_code", "member_type", "member_id", "role")
+		}
+		return schemaObject(props, "room_code", "member_type", "member_id")
+	}
+	d.add("POST", "/api/room/members/role", &openAPIOperation{
+		Summary: "Сменить роль участника", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistWrite),
+		RequestBody: jsonBody(memberBody(true)), Responses: success,
+	})
+	d.add("POST", "/api/room/members/kick", &openAPIOperation{
+		Summary: "Исключить участника", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistWrite),
+		RequestBody: jsonBody(memberBody(false)), Responses: success,
+	})
+	d.add("POST", "/api/room/account", &openAPIOperation{
+		Summary: "Выбрать аккаунт Яндекс.Музыки комнаты", Tags: []string{"rooms"}, Security: requiresScope(scopePlaylistWrite),
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"room_code":  roomCode,
+			"account_id": schemaInt("0 - аккаунт по умолчанию"),
+		}, "room_code", "account_id")),
+		Responses: success,
+	})
+	d.add("POST", "/api/player/control", &openAPIOperation{
+		Summary: "Управление плеером", Tags: []string{"rooms"}, Security: requiresScope(scopePlayback),
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"room_code": roomCode,
+			"action":    schemaEnum("", "next", "prev", "pause", "now"),
+		}, "room_code", "action")),
+		Responses: success,
+	})
+
+	d.add("GET", "/api/accounts", &openAPIOperation{
+		Summary: "Аккаунты Яндекс.Музыки", Tags: []string{"accounts"}, Security: requiresScope(scopePlaylistRead),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Аккаунты без токенов", schemaArray(schemaRef("YandexAccount")))},
+	})
+	d.add("GET", "/api/tokens", &openAPIOperation{
+		Summary: "Свои API-токены", Tags: []string{"accounts"}, Security: requiresScope(scopeAdmin),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Токены без секретов", schemaArray(schemaRef("APIToken")))},
+	})
+	d.add("POST", "/api/tokens/create", &openAPIOperation{
+		Summary: "Создать API-токен", Tags: []string{"accounts"}, Security: requiresScope(scopeAdmin),
+		RequestBody: jsonBody(tokenCreateSchema()),
+		Responses:   map[string]*openAPIResponse{"201": jsonResponse("Токен показывается один раз", tokenCreatedSchema())},
+	})
+	d.add("POST", "/api/tokens/revoke", &openAPIOperation{
+		Summary: "Отозвать API-токен", Tags: []string{"accounts"}, Security: requiresScope(scopeAdmin),
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{"id": schemaInt("")}, "id")),
+		Responses:   success,
+	})
+
+	d.add("POST", "/api/telegram/auth", &openAPIOperation{
+		Summary: "Вход из Telegram Mini App", Tags: []string{"telegram"}, Security: publicAccess,
+		Description: "Проверяет подпись initData и выдает сессию Mini App в cookie и в ответе.",
+		RequestBody: jsonBody(schemaObject(map[string]jsonSchema{
+			"init_data": schemaString("Telegram.WebApp.initData"),
+		}, "init_data")),
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Сессия создана", schemaObject(map[string]jsonSchema{
+			"token":      schemaString(""),
+			"user_id":    schemaInt(""),
+			"first_name": schemaString(""),
+			"role":       schemaString("Роль в боте"),
+			"rooms":      schemaArray(schemaString("Код комнаты")),
+		}))},
+	})
+	d.add("GET", "/ws", &openAPIOperation{
+		Summary: "WebSocket плеера", Tags: []string{"realtime"},
+		Security: []map[string][]string{{"session": {}}, {"telegramSession": {}}},
+		Description: "Сервер рассылает события next, prev, pause, now, notification и memberUpdated. " +
+			`Клиент отправляет {"type": "control", "room_code": "...", "action": "next"}, ` +
+			`а также setRole и kick с полями как у /api/room/members/role.`,
+		Responses: map[string]*openAPIResponse{"101": emptyResponse("Переход на WebSocket")},
+	})
+
+	d.add("GET", "/api/openapi.json", &openAPIOperation{
+		Summary: "Это описание в формате OpenAPI", Tags: []string{"docs"}, Security: publicAccess,
+		Responses: map[string]*openAPIResponse{"200": jsonResponse("Документ OpenAPI 3.1", jsonSchema{"type": "object"})},
+	})
+	d.add("GET", "/api/docs", &openAPIOperation{
+		Summary: "Страница документации API", Tags: []string{"docs"}, Security: publicAccess,
+		Responses: map[string]*openAPIResponse{"200": htmlResponse("Документация")},
+	})
+}
+
+func addPagesSpec(d *openAPIDocument) {
+	pages := []string{"pages"}
+	page := func(summary string, security []map[string][]string) *openAPIOperation {
+		return &openAPIOperation{Summary: summary, Tags: pages, Security: security,
+			Responses: map[string]*openAPIResponse{"200": htmlResponse("Страница")}}
+	}
+	form := func(summary string, security []map[string][]string, fields map[string]jsonSchema) *openAPIOperation {
+		return &openAPIOperation{Summary: summary, Tags: pages, Security: security, RequestBody: formBody(schemaObject(fields)),
+			Responses: map[string]*openAPIResponse{
+				"200": htmlResponse("Страница с результатом"),
+				"302": emptyResponse("Перенаправление после успеха"),
+			}}
+	}
+	session := []map[string][]string{{"session": {}}}
+
+	d.add("GET", "/", page("Главная", session))
+	d.add("GET", "/debug", page("Отладочная информация и действующая конфигурация", session))
+	d.add("GET", "/playlist", page("Плейлист", session))
+	d.add("GET", "/get-track", &openAPIOperation{
+		Summary: "Страница трека с плеером", Tags: pages, Security: session,
+		Parameters: []openAPIParameter{queryParam("trackID", "ID трека", true, schemaInt(""))},
+		Responses:  map[string]*openAPIResponse{"200": htmlResponse("Страница")},
+	})
+	d.add("GET", "/page/settings", page("Настройки и аккаунты Яндекс.Музыки", session))
+	d.add("POST", "/settings", form("Сохранить настройки", session, map[string]jsonSchema{
+		"action":     schemaEnum("Пусто - аккаунт по умолчанию", "add_account", "default_account", "delete_account", "room_account"),
+		"label":      schemaString(""),
+		"userID":     schemaString("Пусто - взять из токена"),
+		"token":      schemaString(""),
+		"is_default": schemaString("on - сделать аккаунтом по умолчанию"),
+		"id":         schemaInt("ID аккаунта"),
+		"account_id": schemaInt(""),
+		"room_id":    schemaInt(""),
+	}))
+	d.add("GET", "/setup", page("Мастер первой настройки", publicAccess))
+	d.add("POST", "/setup", form("Шаг мастера настройки", publicAccess, map[string]jsonSchema{
+		"username": schemaString("Шаг admin"),
+		"password": schemaString("Шаг admin"),
+		"token":    schemaString("Шаг token"),
+		"label":    schemaString("Шаг token"),
+	}))
+	d.add("GET", "/login", page("Вход", publicAccess))
+	d.add("POST", "/login", form("Вход по логину и паролю", publicAccess, map[string]jsonSchema{
+		"username": schemaString(""),
+		"password": schemaString(""),
+		"next":     schemaString("Куда вернуться после входа"),
+	}))
+	d.add("GET", "/login/2fa", page("Ввод кода 2FA", publicAccess))
+	d.add("POST", "/login/2fa", form("Проверка кода 2FA", publicAccess, map[string]jsonSchema{
+		"code": schemaString("Код из приложения или резервный код"),
+		"next": schemaString("Куда вернуться после входа"),
+	}))
+	d.add("POST", "/logout", &openAPIOperation{
+		Summary: "Выход", Tags: pages, Security: session,
+		Responses: map[string]*openAPIResponse{"302": emptyResponse("Перенаправление на /login")},
+	})
+	d.add("GET", "/lang", &openAPIOperation{
+		Summary: "Выбор языка интерфейса", Tags: pages, Security: publicAccess,
+		Parameters: []openAPIParameter{queryParam("lang", "", true, schemaEnum("", "ru", "en"))},
+		Responses:  map[string]*openAPIResponse{"302": emptyResponse("Возврат на предыдущую страницу")},
+	})
+	d.add("GET", "/account/2fa", page("Настройка 2FA", session))
+	d.add("POST", "/account/2fa", form("Включение или отключение 2FA", session, map[string]jsonSchema{
+		"action": schemaEnum("", "begin", "confirm", "disable", "recovery"),
+		"code":   schemaString(""),
+	}))
+	d.add("GET", "/account/tokens", page("API-токены", session))
+	d.add("POST", "/account/tokens", form("Создание или отзыв API-токена", session, map[string]jsonSchema{
+		"action": schemaEnum("", "create", "revoke"),
+		"name":   schemaString(""),
+		"scopes": schemaArray(schemaEnum("", allScopes...)),
+		"id":     schemaInt(""),
+	}))
+	d.add("GET", "/users", page("Пользователи (администраторы)", session))
+	d.add("POST", "/users", form("Управление пользователями", session, map[string]jsonSchema{
+		"action":      schemaEnum("", "create", "require_2fa", "reset_2fa", "delete"),
+		"username":    schemaString(""),
+		"password":    schemaString(""),
+		"is_admin":    schemaString("on - администратор"),
+		"require_2fa": schemaString("on - обязательная 2FA для всех"),
+		"id":          schemaInt(""),
+	}))
+	d.add("GET", "/tg/app", page("Telegram Mini App", publicAccess))
+}
+
+// GET /api/openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, openAPISpec)
+}
+
+// apiDocsOperation - операция для страницы /api/docs
+type apiDocsOperation struct {
+	Method string
+	Path   string
+	*openAPIOperation
+	Scopes    string
+	Body      string
+	Responses []apiDocsResponse
+}
+
+type apiDocsResponse struct {
+	Status      string
+	Description string
+	Schema      string
+}
+
+// Порядок методов на странице документации
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// GET /api/docs - то же описание в виде страницы, сгруппированное по тегам
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	paths := make([]string, 0, len(openAPISpec.Paths))
+	for path := range openAPISpec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	byTag := make(map[string][]apiDocsOperation)
+	for _, path := range paths {
+		for _, method := range openAPIMethods {
+			op, ok := openAPISpec.Paths[path][method]
+			if !ok {
+				continue
+			}
+			entry := apiDocsOperation{Method: strings.ToUpper(method), Path: path, openAPIOperation: op}
+
+			var scopes []string
+			for _, req := range op.Security {
+				scopes = append(scopes, req["bearer"]...)
+			}
+			entry.Scopes = strings.Join(scopes, ", ")
+
+			if op.RequestBody != nil {
+				for contentType, media := range op.RequestBody.Content {
+					entry.Body = contentType + "\n" + indentSchema(media.Schema)
+				}
+			}
+
+			statuses := make([]string, 0, len(op.Responses))
+			for status := range op.Responses {
+				statuses = append(statuses, status)
+			}
+			sort.Strings(statuses) // "default" после кодов
+			for _, status := range statuses {
+				resp := apiDocsResponse{Status: status, Description: op.Responses[status].Description}
+				for _, media := range op.Responses[status].Content {
+					resp.Schema = indentSchema(media.Schema)
+				}
+				entry.Responses = append(entry.Responses, resp)
+			}
+
+			byTag[op.Tags[0]] = append(byTag[op.Tags[0]], entry)
+		}
+	}
+
+	type tagSection struct {
+		openAPITag
+		Operations []apiDocsOperation
+	}
+	var sections []tagSection
+	for _, tag := range openAPISpec.Tags {
+		sections = append(sections, tagSection{openAPITag: tag, Operations: byTag[tag.Name]})
+	}
+
+	loadTemplate(w, r, "api_docs.html", struct {
+		Info     openAPIInfo
+		Sections []tagSection
+	}{
+		Info:     openAPISpec.Info,
+		Sections: sections,
+	})
+}
+
+// indentSchema - схема в читаемом виде со ссылками на components
+func indentSchema(schema jsonSchema) string {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// routeMux запоминает зарегистрированные шаблоны маршрутов, чтобы сверить
+// их с описанием API
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouteMux() *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// undocumentedRoutes возвращает маршруты, которых нет в описании API.
+// Поддеревья ("/static/", "/api/v1/") - это статика и заглушки, они не описываются.
+// Маршрут без метода считается описанным, если его путь есть с любым методом.
+func undocumentedRoutes(patterns []string, doc *openAPIDocument) []string {
+	var missing []string
+	for _, pattern := range patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		if path != "/" && strings.HasSuffix(path, "/") {
+			continue
+		}
+
+		ops, ok := doc.Paths[path]
+		if ok && method != "" {
+			_, ok = ops[strings.ToLower(method)]
+		}
+		if !ok {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing
+}
+
+// checkOpenAPICoverage предупреждает в логе о маршрутах без описания
+func checkOpenAPICoverage(mux *routeMux) {
+	for _, pattern := range undocumentedRoutes(mux.patterns, openAPISpec) {
+		log.Printf("Warning: route %q is missing from the OpenAPI document (openapi.go)", pattern)
+	}
+}
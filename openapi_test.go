@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Каждый зарегистрированный маршрут должен быть описан в openapi.go
+func TestOpenAPICoversRoutes(t *testing.T) {
+	appConfig = &ServerConfig{StaticDir: "static"}
+	mux := newRouteMux()
+	registerRoutes(mux)
+
+	if len(mux.patterns) == 0 {
+		t.Fatal("no routes registered")
+	}
+	for _, pattern := range undocumentedRoutes(mux.patterns, buildOpenAPISpec()) {
+		t.Errorf("route %q is missing from the OpenAPI document", pattern)
+	}
+}
+
+func TestUndocumentedRoutes(t *testing.T) {
+	doc := &openAPIDocument{Paths: map[string]map[string]*openAPIOperation{
+		"/api/tracks":        {"get": {}},
+		"/api/v1/rooms/{id}": {"get": {}, "delete": {}},
+	}}
+
+	tests := []struct {
+		pattern string
+		missing bool
+	}{
+		{"/api/tracks", false},
+		{"GET /api/tracks", false},
+		{"POST /api/tracks", true},
+		{"DELETE /api/v1/rooms/{id}", false},
+		{"PUT /api/v1/rooms/{id}", true},
+		{"/api/unknown", true},
+		{"/static/", false},
+	}
+	for _, tt := range tests {
+		missing := undocumentedRoutes([]string{tt.pattern}, doc)
+		if got := len(missing) > 0; got != tt.missing {
+			t.Errorf("undocumentedRoutes(%q) = %v, want missing=%v", tt.pattern, missing, tt.missing)
+		}
+	}
+}
+
+// Описание API доступно и до завершения мастера настройки
+func TestRequireSetupAllowsAPIDocs(t *testing.T) {
+	setupComplete.Store(false)
+	defer setupComplete.Store(false)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := requireSetup(next)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/openapi.json", http.StatusOK},
+		{"/api/docs", http.StatusOK},
+		{"/setup", http.StatusOK},
+		{"/api/tracks", http.StatusServiceUnavailable},
+		{"/settings", http.StatusFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Сохранение плейлиста комнаты в Яндекс.Музыку. Плейлист в Яндексе
+// заменяется треками комнаты в их порядке. Правки принимаются только с
+// актуальной ревизией, поэтому при конфликте плейлист перечитывается и
+// операция повторяется.
+
+// exportRejected - трек отправлен, но в плейлисте Яндекса не появился
+const exportRejected = "rejected"
+
+// exportAttempts - сколько раз повторять сохранение при смене ревизии
+const exportAttempts = 3
+
+var (
+	errExportEmpty    = errors.New("room playlist is empty")
+	errExportConflict = errors.New("yandex playlist keeps changing")
+)
+
+// Плейлист, в который комната сохранялась последний раз: повторное
+// сохранение без номера плейлиста обновляет его, а не создает новый
+func createPlaylistExportsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS playlist_exports (
+		room_id INTEGER NOT NULL,
+		owner_uid INTEGER NOT NULL,
+		kind INTEGER NOT NULL,
+		exported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (room_id, owner_uid)
+	);`)
+	return err
+}
+
+// exportResult - итог сохранения. Failed - треки, которые не удалось добавить.
+type exportResult struct {
+	Kind     int            `json:"kind"`
+	Title    string         `json:"title"`
+	Revision int            `json:"revision"`
+	URL      string         `json:"url"`
+	Created  bool           `json:"created"`
+	Exported int            `json:"exported"`
+	Failed   []bulkAddTrack `json:"failed"`
+}
+
+// exportRoomPlaylist сохраняет треки комнаты в плейлист kind аккаунта api.
+// kind 0 - плейлист прошлого сохранения, а если его нет - новый с названием title.
+func exportRoomPlaylist(ctx context.Context, api *yandexAPI, roomID, kind int, title string) (*exportResult, error) {
+	page, err := queryPlaylist(ctx, &trackQuery{RoomID: roomID, HasRoom: true, Sort: "position"})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Entries) == 0 {
+		return nil, errExportEmpty
+	}
+
+	ids := make([]int, len(page.Entries))
+	for i, entry := range page.Entries {
+		ids[i] = entry.TrackID
+	}
+	tracks, err := api.Tracks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Без альбома трек в плейлист не добавить
+	res := &exportResult{Failed: []bulkAddTrack{}}
+	refs := make([]yandexTrackRef, 0, len(tracks))
+	sent := make(map[int]bulkAddTrack, len(tracks))
+	for _, t := range tracks {
+		entry := bulkAddTrack{TrackID: int(t.ID), Title: t.Title, Artist: t.ArtistName()}
+		if !t.IsAvailable() || t.AlbumID() == 0 {
+			entry.Reason = skipUnavailable
+			res.Failed = append(res.Failed, entry)
+			continue
+		}
+		refs = append(refs, yandexTrackRef{ID: strconv.Itoa(entry.TrackID), AlbumID: strconv.Itoa(t.AlbumID())})
+		sent[entry.TrackID] = entry
+	}
+
+	playlist, created, err := exportTarget(ctx, api, roomID, kind, title)
+	if err != nil {
+		return nil, err
+	}
+	res.Created = created
+
+	for attempt := 1; ; attempt++ {
+		var ops []yandexPlaylistOp
+		if n := len(playlist.Tracks); n > 0 {
+			ops = append(ops, deleteTracksOp(0, n))
+		}
+		if len(refs) > 0 {
+			ops = append(ops, insertTracksOp(0, refs))
+		}
+		if len(ops) == 0 {
+			break
+		}
+
+		_, err = api.ChangePlaylist(ctx, playlist.Kind, playlist.Revision, ops)
+		if err == nil {
+			break
+		}
+		if !isYandexWrongRevision(err) {
+			return nil, err
+		}
+		if attempt == exportAttempts {
+			return nil, errExportConflict
+		}
+		log.Printf("Yandex playlist %d changed during export, retrying", playlist.Kind)
+		if playlist, err = api.Playlist(ctx, strconv.Itoa(api.userID), playlist.Kind); err != nil {
+			return nil, err
+		}
+	}
+
+	// Яндекс молча пропускает треки, которые не может добавить, поэтому
+	// сверяемся с тем, что получилось
+	playlist, err = api.Playlist(ctx, strconv.Itoa(api.userID), playlist.Kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range playlist.TrackList() {
+		delete(sent, int(t.ID))
+		res.Exported++
+	}
+	for _, ref := range refs {
+		id, _ := strconv.Atoi(ref.ID)
+		if entry, ok := sent[id]; ok {
+			entry.Reason = exportRejected
+			res.Failed = append(res.Failed, entry)
+		}
+	}
+
+	res.Kind, res.Title, res.Revision = playlist.Kind, playlist.Title, playlist.Revision
+	owner := playlist.Owner.Login
+	if owner == "" {
+		owner = strconv.Itoa(api.userID)
+	}
+	res.URL = fmt.Sprintf("https://music.yandex.ru/users/%s/playlists/%d", owner, playlist.Kind)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO playlist_exports (room_id, owner_uid, kind) VALUES (?, ?, ?)
+		ON CONFLICT (room_id, owner_uid) DO UPDATE SET kind = excluded.kind, exported_at = CURRENT_TIMESTAMP`,
+		roomID, api.userID, playlist.Kind)
+	if err != nil {
+		log.Printf("Error saving playlist export: %v", err)
+	}
+	return res, nil
+}
+
+// exportTarget возвращает плейлист для сохранения и признак того, что он создан
+func exportTarget(ctx context.Context, api *yandexAPI, roomID, kind int, title string) (*yandexPlaylist, bool, error) {
+	owner := strconv.Itoa(api.userID)
+	if kind != 0 {
+		playlist, err := api.Playlist(ctx, owner, kind)
+		return playlist, false, err
+	}
+
+	var saved int
+	err := db.QueryRowContext(ctx, "SELECT kind FROM playlist_exports WHERE room_id = ? AND owner_uid = ?", roomID, api.userID).Scan(&saved)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, err
+	}
+	if saved != 0 {
+		playlist, err := api.Playlist(ctx, owner, saved)
+		if err == nil {
+			return playlist, false, nil
+		}
+		// Плейлист удалили в Яндексе - создадим новый
+		if !isYandexNotFound(err) {
+			return nil, false, err
+		}
+	}
+
+	playlist, err := api.CreatePlaylist(ctx, title)
+	return playlist, true, err
+}
+
+// exportTitle - название нового плейлиста, если его не указали
+func exportTitle(roomCode string) string {
+	if roomCode == "" {
+		return "MusicDirect"
+	}
+	return "MusicDirect " + roomCode
+}
+
+// POST /api/v1/rooms/{code}/export {"kind": 5, "title": "..."} - сохранить
+// плейлист комнаты в Яндекс.Музыку. Без kind обновляется плейлист прошлого
+// сохранения или создается новый.
+func apiV1ExportRoom(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Kind  int    `json:"kind"`
+		Title string `json:"title"`
+	}
+	// Пустое тело - сохранить с настройками по умолчанию
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.Kind < 0 {
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Плейлист в Яндексе перезаписывается - это решает хозяин комнаты
+	code := r.PathValue("code")
+	roomID, ok := authorizeRoomAction(w, r, code, roomActionManage)
+	if !ok {
+		return
+	}
+	api, ok := roomYandexAPI(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	title := strings.TrimSpace(requestData.Title)
+	if title == "" {
+		title = exportTitle(code)
+	}
+	res, err := exportRoomPlaylist(r.Context(), api, roomID, requestData.Kind, title)
+	switch {
+	case err == nil:
+	case errors.Is(err, errExportEmpty):
+		httpError(w, r, "http.export_empty", http.StatusBadRequest)
+		return
+	case errors.Is(err, errExportConflict):
+		httpError(w, r, "http.export_conflict", http.StatusConflict)
+		return
+	case isYandexNotFound(err):
+		httpError(w, r, "http.link_not_found", http.StatusNotFound)
+		return
+	default:
+		log.Printf("Error exporting room %d: %v", roomID, err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("Room %d: exported %d tracks to Yandex playlist %d, %d failed", roomID, res.Exported, res.Kind, len(res.Failed))
+	status := http.StatusOK
+	if res.Created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, res)
+}
+
+// handleExportCommand сохраняет плейлист бота в Яндекс.Музыку:
+// "/export" или "/export <номер плейлиста>"
+func handleExportCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	kind := 0
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return T(lang, "bot.export_usage")
+		}
+		kind = n
+	}
+
+	api := yandexAPIForRoom(0)
+	if api == nil {
+		return T(lang, "bot.no_account")
+	}
+
+	res, err := exportRoomPlaylist(context.Background(), api, 0, kind, exportTitle(""))
+	switch {
+	case err == nil:
+	case errors.Is(err, errExportEmpty):
+		return T(lang, "bot.playlist_empty")
+	case errors.Is(err, errExportConflict):
+		return T(lang, "bot.export_conflict")
+	case isYandexNotFound(err):
+		return T(lang, "bot.link_not_found")
+	default:
+		log.Printf("Error exporting bot playlist: %v", err)
+		return T(lang, "bot.export_error", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(T(lang, "bot.exported", res.Title, res.Exported, res.URL))
+	if len(res.Failed) > 0 {
+		sb.WriteString("\n\n" + T(lang, "bot.export_failed"))
+		for _, t := range res.Failed {
+			if t.Title != "" {
+				sb.WriteString(fmt.Sprintf("\n%s - %s", t.Artist, t.Title))
+			} else {
+				sb.WriteString(fmt.Sprintf("\n%d", t.TrackID))
+			}
+		}
+	}
+	return sb.String()
+}
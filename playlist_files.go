@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Файлы плейлистов: очередь комнаты или сохраненный плейлист в форматах
+// M3U8, XSPF и JSPF. Ссылки ведут на /api/v1/tracks/{id}/stream, который
+// перенаправляет на свежую ссылку Яндекс.Музыки. Ссылки Яндекса живут
+// недолго, поэтому в файл их не записываем.
+//
+// Без входа в браузере такой файл не проиграть, поэтому ссылки можно
+// подписать: подписанная ссылка работает без сессии до истечения срока.
+//
+// Здесь же чтение файлов других плееров (M3U, XSPF, JSPF и CSV) для
+// импорта - см. playlist_upload.go.
+
+const (
+	playlistFormatM3U8 = "m3u8"
+	playlistFormatXSPF = "xspf"
+	playlistFormatJSPF = "jspf"
+	playlistFormatCSV  = "csv" // только импорт
+)
+
+// playlistFormatTypes - Content-Type файла по формату
+var playlistFormatTypes = map[string]string{
+	playlistFormatM3U8: "audio/x-mpegurl; charset=utf-8",
+	playlistFormatXSPF: "application/xspf+xml; charset=utf-8",
+	playlistFormatJSPF: "application/jspf+json; charset=utf-8",
+}
+
+// Срок подписанных ссылок в часах
+const (
+	defaultStreamLinkTTL = 24
+	maxStreamLinkTTL     = 7 * 24
+)
+
+const streamSignPurpose = "stream"
+
+// playlistFile - плейлист, готовый к записи в файл
+type playlistFile struct {
+	Title  string
+	Tracks []playlistFileTrack
+}
+
+type playlistFileTrack struct {
+	TrackID    int
+	Title      string
+	Artist     string
+	DurationMs int
+	Location   string
+}
+
+// yandexTrackURL - страница трека в Яндекс.Музыке, идентификатор записи в XSPF и JSPF
+func yandexTrackURL(trackID int) string {
+	return fmt.Sprintf("https://music.yandex.ru/track/%d", trackID)
+}
+
+// writeM3U8 пишет расширенный M3U в UTF-8
+func writeM3U8(w io.Writer, f *playlistFile) error {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	if f.Title != "" {
+		sb.WriteString("#PLAYLIST:" + m3uText(f.Title) + "\n")
+	}
+	for _, t := range f.Tracks {
+		// -1 - длительность неизвестна
+		seconds := -1
+		if t.DurationMs > 0 {
+			seconds = (t.DurationMs + 500) / 1000
+		}
+		name := t.Title
+		if t.Artist != "" {
+			name = t.Artist + " - " + t.Title
+		}
+		fmt.Fprintf(&sb, "#EXTINF:%d,%s\n%s\n", seconds, m3uText(name), t.Location)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// m3uText убирает переводы строк: в M3U запись занимает одну строку
+func m3uText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int         `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title,omitempty"`
+	Creator    string `xml:"creator,omitempty"`
+	Duration   int    `xml:"duration,omitempty"` // миллисекунды
+}
+
+// writeXSPF пишет плейлист в XSPF версии 1
+func writeXSPF(w io.Writer, f *playlistFile) error {
+	doc := xspfPlaylist{Version: 1, Title: f.Title, Tracks: make([]xspfTrack, len(f.Tracks))}
+	for i, t := range f.Tracks {
+		doc.Tracks[i] = xspfTrack{
+			Location:   t.Location,
+			Identifier: yandexTrackURL(t.TrackID),
+			Title:      t.Title,
+			Creator:    t.Artist,
+			Duration:   t.DurationMs,
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// JSPF - XSPF в JSON: location и identifier в нем массивы
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title  string      `json:"title,omitempty"`
+	Tracks []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Location   jspfStrings `json:"location"`
+	Identifier jspfStrings `json:"identifier,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Creator    string      `json:"creator,omitempty"`
+	Duration   int         `json:"duration,omitempty"`
+}
+
+// jspfStrings - массив строк; некоторые программы пишут в JSPF одну строку
+type jspfStrings []string
+
+func (s *jspfStrings) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = jspfStrings{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// writeJSPF пишет плейлист в JSPF
+func writeJSPF(w io.Writer, f *playlistFile) error {
+	doc := jspfDocument{Playlist: jspfPlaylist{Title: f.Title, Tracks: make([]jspfTrack, len(f.Tracks))}}
+	for i, t := range f.Tracks {
+		doc.Playlist.Tracks[i] = jspfTrack{
+			Location:   []string{t.Location},
+			Identifier: []string{yandexTrackURL(t.TrackID)},
+			Title:      t.Title,
+			Creator:    t.Artist,
+			Duration:   t.DurationMs,
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// writePlaylistFile пишет плейлист в нужном формате
+func writePlaylistFile(w io.Writer, format string, f *playlistFile) error {
+	switch format {
+	case playlistFormatM3U8:
+		return writeM3U8(w, f)
+	case playlistFormatXSPF:
+		return writeXSPF(w, f)
+	default:
+		return writeJSPF(w, f)
+	}
+}
+
+// publicBaseURL - адрес сервера для ссылок в файле: public_url из настроек,
+// иначе адрес, по которому пришел запрос
+func publicBaseURL(r *http.Request) string {
+	if appConfig.PublicURL != "" {
+		return strings.TrimRight(appConfig.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// streamSignedValue - подписываемые данные ссылки: трек, комната и срок
+func streamSignedValue(trackID int, roomCode string, expires int64) string {
+	return fmt.Sprintf("%d:%s:%d", trackID, roomCode, expires)
+}
+
+// streamURL - ссылка на воспроизведение трека. С ненулевым expires ссылка
+// подписывается и работает без входа до этого момента.
+func streamURL(base string, trackID int, roomCode string, expires time.Time) (string, error) {
+	q := url.Values{}
+	if roomCode != "" {
+		q.Set("room_code", roomCode)
+	}
+	if !expires.IsZero() {
+		sig, err := signValue(streamSignPurpose, streamSignedValue(trackID, roomCode, expires.Unix()))
+		if err != nil {
+			return "", err
+		}
+		q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+		q.Set("sig", sig)
+	}
+
+	link := fmt.Sprintf("%s%stracks/%d/stream", base, apiV1Prefix, trackID)
+	if len(q) > 0 {
+		link += "?" + q.Encode()
+	}
+	return link, nil
+}
+
+// isSignedStreamRequest - запрос трека по действующей подписанной ссылке.
+// Такие запросы requireLogin пропускает без сессии.
+func isSignedStreamRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, apiV1Prefix+"tracks/")
+	if !ok {
+		return false
+	}
+	id, ok := strings.CutSuffix(rest, "/stream")
+	if !ok {
+		return false
+	}
+	trackID, err := strconv.Atoi(id)
+	if err != nil || trackID <= 0 {
+		return false
+	}
+
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return verifySignature(streamSignPurpose, streamSignedValue(trackID, q.Get("room_code"), expires), q.Get("sig"))
+}
+
+// GET /api/v1/tracks/{id}/stream[?room_code=ABCDE] - перенаправление на
+// ссылку для воспроизведения. Работает и по подписанной ссылке без входа.
+func apiV1StreamTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, ok := pathTrackID(w, r)
+	if !ok {
+		return
+	}
+
+	// Без подписи слушать через аккаунт комнаты могут только ее участники
+	code := r.URL.Query().Get("room_code")
+	if code != "" && !isSignedStreamRequest(r) {
+		if _, ok := authorizeRoomAction(w, r, code, roomActionView); !ok {
+			return
+		}
+	}
+
+	client := clientForRoomCode(code)
+	if client == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	trackURL, err := client.Tracks().GetDownloadURL(r.Context(), trackID)
+	if err != nil {
+		log.Printf("Error getting track download URL: %v", err)
+		httpError(w, r, "http.download_url", http.StatusBadGateway)
+		return
+	}
+
+	// Ссылка Яндекса временная - не кешируем
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, trackURL, http.StatusFound)
+}
+
+// playlistFileOptions разбирает формат из пути и параметры ссылок:
+// ?signed=1 - подписать ссылки, ttl - срок подписи в часах.
+// При ошибке сам отвечает клиенту и возвращает false.
+func playlistFileOptions(w http.ResponseWriter, r *http.Request) (format string, expires time.Time, ok bool) {
+	format = strings.ToLower(r.PathValue("format"))
+	if _, known := playlistFormatTypes[format]; !known {
+		httpError(w, r, "http.invalid_format", http.StatusBadRequest)
+		return "", time.Time{}, false
+	}
+
+	q := r.URL.Query()
+	if signed, _ := strconv.ParseBool(q.Get("signed")); !signed {
+		return format, time.Time{}, true
+	}
+	ttl := defaultStreamLinkTTL
+	if value := q.Get("ttl"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxStreamLinkTTL {
+			httpError(w, r, "http.invalid_ttl", http.StatusBadRequest)
+			return "", time.Time{}, false
+		}
+		ttl = n
+	}
+	return format, time.Now().Add(time.Duration(ttl) * time.Hour), true
+}
+
+// servePlaylistFile собирает файл из треков и отдает его как вложение
+func servePlaylistFile(w http.ResponseWriter, r *http.Request, format, name, roomCode string, expires time.Time, tracks []savedPlaylistTrack) {
+	base := publicBaseURL(r)
+	f := &playlistFile{Title: name, Tracks: make([]playlistFileTrack, len(tracks))}
+	for i, t := range tracks {
+		location, err := streamURL(base, t.TrackID, roomCode, expires)
+		if err != nil {
+			log.Printf("Error signing stream URL: %v", err)
+			httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+			return
+		}
+		f.Tracks[i] = playlistFileTrack{
+			TrackID:    t.TrackID,
+			Title:      t.Title,
+			Artist:     t.Artist,
+			DurationMs: t.DurationMs,
+			Location:   location,
+		}
+	}
+
+	w.Header().Set("Content-Type", playlistFormatTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", playlistFileName(name)+"."+format))
+	if !expires.IsZero() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if err := writePlaylistFile(w, format, f); err != nil {
+		log.Printf("Error writing %s playlist: %v", format, err)
+	}
+}
+
+// playlistFileName - имя файла из латиницы, цифр, "-" и "_"
+func playlistFileName(name string) string {
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '_'
+		}
+		return -1
+	}, name)
+	if clean == "" {
+		return "playlist"
+	}
+	return clean
+}
+
+// GET /api/v1/rooms/{code}/export/{format}[?signed=1&ttl=24] - очередь
+// комнаты файлом m3u8, xspf или jspf
+func apiV1RoomPlaylistFile(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	roomID, ok := authorizeRoomAction(w, r, code, roomActionView)
+	if !ok {
+		return
+	}
+	format, expires, ok := playlistFileOptions(w, r)
+	if !ok {
+		return
+	}
+
+	tracks, err := roomQueueTracks(r.Context(), roomID)
+	if err != nil {
+		log.Printf("Error loading room %d playlist: %v", roomID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	servePlaylistFile(w, r, format, exportTitle(code), code, expires, tracks)
+}
+
+// GET /api/v1/playlists/{id}/export/{format}[?signed=1&ttl=24] - сохраненный
+// плейлист файлом. Треки играют через аккаунт по умолчанию.
+func apiV1SavedPlaylistFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := playlistForRequest(w, r, false)
+	if !ok {
+		return
+	}
+	format, expires, ok := playlistFileOptions(w, r)
+	if !ok {
+		return
+	}
+	servePlaylistFile(w, r, format, p.Name, "", expires, p.Tracks)
+}
+
+// errUnknownPlaylistFile - файл не похож ни на один поддерживаемый формат
+var errUnknownPlaylistFile = errors.New("unsupported playlist file")
+
+// playlistFileFormat определяет формат по расширению имени файла, а если
+// его нет - по началу содержимого
+func playlistFileFormat(name string, data []byte) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u", ".m3u8":
+		return playlistFormatM3U8
+	case ".xspf":
+		return playlistFormatXSPF
+	case ".jspf":
+		return playlistFormatJSPF
+	case ".csv", ".tsv":
+		return playlistFormatCSV
+	}
+
+	head := strings.TrimSpace(string(data[:min(len(data), 512)]))
+	switch {
+	case strings.HasPrefix(head, "<"):
+		return playlistFormatXSPF
+	case strings.HasPrefix(head, "{"):
+		return playlistFormatJSPF
+	case strings.HasPrefix(head, "#EXTM3U"):
+		return playlistFormatM3U8
+	case strings.ContainsAny(firstLine(head), ",;\t"):
+		return playlistFormatCSV
+	}
+	// Простой M3U - список путей без заголовка
+	return playlistFormatM3U8
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// readPlaylistFile разбирает файл плейлиста. Location трека - путь или
+// ссылка из файла; название и исполнитель могут быть пустыми.
+func readPlaylistFile(format string, data []byte) (*playlistFile, error) {
+	// BOM, который добавляют Excel и Блокнот
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		data = []byte(strings.ToValidUTF8(string(data), "?"))
+	}
+
+	var f *playlistFile
+	var err error
+	switch format {
+	case playlistFormatM3U8:
+		f = readM3U(data)
+	case playlistFormatXSPF:
+		f, err = readXSPF(data)
+	case playlistFormatJSPF:
+		f, err = readJSPF(data)
+	case playlistFormatCSV:
+		f, err = readCSV(data)
+	default:
+		return nil, errUnknownPlaylistFile
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnknownPlaylistFile, err)
+	}
+	return f, nil
+}
+
+// readM3U читает M3U: #EXTINF:секунды,Исполнитель - Название перед путем
+func readM3U(data []byte) *playlistFile {
+	f := &playlistFile{}
+	var pending playlistFileTrack
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			f.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info, name, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			// После длительности могут идти атрибуты: #EXTINF:215 tvg-id="...",...
+			seconds, _ := strconv.Atoi(strings.Fields(info + " ")[0])
+			pending = playlistFileTrack{}
+			if seconds > 0 {
+				pending.DurationMs = seconds * 1000
+			}
+			pending.Artist, pending.Title = splitArtistTitle(name)
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			f.Tracks = append(f.Tracks, pending)
+			pending = playlistFileTrack{}
+		}
+	}
+	return f
+}
+
+// splitArtistTitle делит "Исполнитель - Название"; без разделителя это название
+func splitArtistTitle(s string) (artist, title string) {
+	s = strings.TrimSpace(s)
+	if a, t, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(a), strings.TrimSpace(t)
+	}
+	return "", s
+}
+
+func readXSPF(data []byte) (*playlistFile, error) {
+	// Без XMLName подходит корневой элемент с любым пространством имен
+	var doc struct {
+		Title  string      `xml:"title"`
+		Tracks []xspfTrack `xml:"trackList>track"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	f := &playlistFile{Title: strings.TrimSpace(doc.Title)}
+	for _, t := range doc.Tracks {
+		track := playlistFileTrack{
+			Title:      strings.TrimSpace(t.Title),
+			Artist:     strings.TrimSpace(t.Creator),
+			DurationMs: t.Duration,
+			Location:   strings.TrimSpace(t.Location),
+		}
+		// Ссылка на Яндекс.Музыку может быть только в identifier
+		if track.Location == "" || !isYandexMusicLink(track.Location) && isYandexMusicLink(t.Identifier) {
+			track.Location = strings.TrimSpace(t.Identifier)
+		}
+		f.Tracks = append(f.Tracks, track)
+	}
+	return f, nil
+}
+
+func readJSPF(data []byte) (*playlistFile, error) {
+	var doc jspfDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	f := &playlistFile{Title: strings.TrimSpace(doc.Playlist.Title)}
+	for _, t := range doc.Playlist.Tracks {
+		track := playlistFileTrack{
+			Title:      strings.TrimSpace(t.Title),
+			Artist:     strings.TrimSpace(t.Creator),
+			DurationMs: t.Duration,
+		}
+		for _, link := range append(append([]string{}, t.Location...), t.Identifier...) {
+			if track.Location == "" || isYandexMusicLink(link) {
+				track.Location = strings.TrimSpace(link)
+			}
+			if isYandexMusicLink(link) {
+				break
+			}
+		}
+		f.Tracks = append(f.Tracks, track)
+	}
+	return f, nil
+}
+
+// Названия столбцов CSV в выгрузках разных сервисов (Exportify, Soundiiz,
+// TuneMyMusic и таблицы, сделанные вручную), в нижнем регистре
+var (
+	csvTitleColumns    = []string{"title", "track name", "track", "track title", "name", "song", "название", "трек"}
+	csvArtistColumns   = []string{"artist", "artist name(s)", "artist name", "artists", "creator", "исполнитель", "артист"}
+	csvDurationColumns = []string{"duration (ms)", "duration_ms", "duration", "length", "длительность"}
+	csvLinkColumns     = []string{"url", "link", "location", "track url", "ссылка"}
+)
+
+// readCSV читает таблицу с заголовком. Без узнаваемого заголовка столбцы -
+// исполнитель, название и длительность.
+func readCSV(data []byte) (*playlistFile, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = csvDelimiter(firstLine(string(data)))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &playlistFile{}, nil
+	}
+
+	column := func(names []string) int {
+		for i, h := range rows[0] {
+			if slices.Contains(names, strings.ToLower(strings.TrimSpace(h))) {
+				return i
+			}
+		}
+		return -1
+	}
+	titleCol, artistCol := column(csvTitleColumns), column(csvArtistColumns)
+	durationCol, linkCol := column(csvDurationColumns), column(csvLinkColumns)
+	durationInMs := durationCol >= 0 && strings.Contains(strings.ToLower(rows[0][durationCol]), "ms")
+
+	if titleCol >= 0 || linkCol >= 0 {
+		rows = rows[1:]
+	} else {
+		artistCol, titleCol, durationCol = 0, 1, 2
+	}
+
+	cell := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	f := &playlistFile{}
+	for _, row := range rows {
+		track := playlistFileTrack{
+			Title:      cell(row, titleCol),
+			Artist:     cell(row, artistCol),
+			DurationMs: parseFileDuration(cell(row, durationCol), durationInMs),
+			Location:   cell(row, linkCol),
+		}
+		if track.Title != "" || track.Location != "" {
+			f.Tracks = append(f.Tracks, track)
+		}
+	}
+	return f, nil
+}
+
+// csvDelimiter - самый частый разделитель в строке заголовка
+func csvDelimiter(header string) rune {
+	best, count := ',', strings.Count(header, ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(header, string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// parseFileDuration понимает "3:35", "1:02:10", миллисекунды и секунды
+func parseFileDuration(s string, ms bool) int {
+	if s == "" {
+		return 0
+	}
+	if strings.Contains(s, ":") {
+		total := 0
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0
+			}
+			total = total*60 + n
+		}
+		return total * 1000
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	// Больше суток в секундах не бывает - значит, это миллисекунды
+	if ms || n > 86400 {
+		return int(n)
+	}
+	return int(n * 1000)
+}
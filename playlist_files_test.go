@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	sig, err := signValue("stream", "1:ABCDE:100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		purpose string
+		value   string
+		sig     string
+		want    bool
+	}{
+		{"valid", "stream", "1:ABCDE:100", sig, true},
+		{"other value", "stream", "2:ABCDE:100", sig, false},
+		{"other purpose", "download", "1:ABCDE:100", sig, false},
+		{"truncated", "stream", "1:ABCDE:100", sig[:len(sig)-1], false},
+		{"empty", "stream", "1:ABCDE:100", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifySignature(tt.purpose, tt.value, tt.sig); got != tt.want {
+			t.Errorf("%s: verifySignature = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// signedStreamRequest - запрос по ссылке streamURL с подменой параметров
+func signedStreamRequest(t *testing.T, trackID int, roomCode string, expires time.Time, edit func(q url.Values)) *http.Request {
+	t.Helper()
+	link, err := streamURL("http://example.com", trackID, roomCode, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		q := u.Query()
+		edit(q)
+		u.RawQuery = q.Encode()
+	}
+	return httptest.NewRequest(http.MethodGet, u.String(), nil)
+}
+
+func TestIsSignedStreamRequest(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		edit    func(q url.Values)
+		method  string
+		want    bool
+	}{
+		{"valid", future, nil, http.MethodGet, true},
+		{"head", future, nil, http.MethodHead, true},
+		{"expired", past, nil, http.MethodGet, false},
+		{"expiry extended", past, func(q url.Values) {
+			q.Set("expires", strconv.FormatInt(future.Unix(), 10))
+		}, http.MethodGet, false},
+		{"other room", future, func(q url.Values) { q.Set("room_code", "ZZZZZ") }, http.MethodGet, false},
+		{"no room", future, func(q url.Values) { q.Del("room_code") }, http.MethodGet, false},
+		{"no signature", future, func(q url.Values) { q.Del("sig") }, http.MethodGet, false},
+		{"bad expires", future, func(q url.Values) { q.Set("expires", "soon") }, http.MethodGet, false},
+		{"post", future, nil, http.MethodPost, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := signedStreamRequest(t, 42, "ABCDE", tt.expires, tt.edit)
+			r.Method = tt.method
+			if got := isSignedStreamRequest(r); got != tt.want {
+				t.Errorf("isSignedStreamRequest(%s %s) = %v, want %v", tt.method, r.URL, got, tt.want)
+			}
+		})
+	}
+}
+
+// Подпись одного трека не подходит к другому
+func TestIsSignedStreamRequestOtherTrack(t *testing.T) {
+	r := signedStreamRequest(t, 42, "ABCDE", time.Now().Add(time.Hour), nil)
+	r.URL.Path = strings.Replace(r.URL.Path, "/42/", "/43/", 1)
+	if isSignedStreamRequest(r) {
+		t.Errorf("signature for track 42 accepted for %s", r.URL.Path)
+	}
+}
+
+func TestStreamURLUnsigned(t *testing.T) {
+	link, err := streamURL("https://music.example.com", 42, "ABCDE", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://music.example.com/api/v1/tracks/42/stream?room_code=ABCDE"; link != want {
+		t.Errorf("streamURL = %q, want %q", link, want)
+	}
+	r := httptest.NewRequest(http.MethodGet, link, nil)
+	if isSignedStreamRequest(r) {
+		t.Error("unsigned link accepted as signed")
+	}
+}
+
+func TestPlaylistFileFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"list.M3U", "", playlistFormatM3U8},
+		{"list.m3u8", "", playlistFormatM3U8},
+		{"list.xspf", "", playlistFormatXSPF},
+		{"list.jspf", "", playlistFormatJSPF},
+		{"list.tsv", "", playlistFormatCSV},
+		{"export.csv", "{}", playlistFormatCSV},
+		{"", " <?xml version=\"1.0\"?><playlist/>", playlistFormatXSPF},
+		{"", "{\"playlist\": {}}", playlistFormatJSPF},
+		{"", "#EXTM3U\n#EXTINF:1,a\nb.mp3", playlistFormatM3U8},
+		{"paste", "Artist;Title\nQueen;Bohemian Rhapsody", playlistFormatCSV},
+		{"", "/music/a.mp3\n/music/b.mp3", playlistFormatM3U8},
+	}
+	for _, tt := range tests {
+		if got := playlistFileFormat(tt.name, []byte(tt.data)); got != tt.want {
+			t.Errorf("playlistFileFormat(%q, %q) = %q, want %q", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReadPlaylistFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		data      string
+		wantTitle string
+		want      []playlistFileTrack
+	}{
+		{
+			name:   "m3u with extinf",
+			format: playlistFormatM3U8,
+			data: "\xef\xbb\xbf#EXTM3U\r\n#PLAYLIST:Road trip\r\n" +
+				"#EXTINF:355,Queen - Bohemian Rhapsody\r\n/music/queen.mp3\r\n" +
+				"#EXTINF:-1 tvg-id=\"x\",Yesterday\r\nhttps://music.yandex.ru/album/1/track/2\r\n" +
+				"plain.mp3\r\n",
+			wantTitle: "Road trip",
+			want: []playlistFileTrack{
+				{Title: "Bohemian Rhapsody", Artist: "Queen", DurationMs: 355000, Location: "/music/queen.mp3"},
+				{Title: "Yesterday", Location: "https://music.yandex.ru/album/1/track/2"},
+				{Location: "plain.mp3"},
+			},
+		},
+		{
+			name:   "xspf with identifier",
+			format: playlistFormatXSPF,
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/"><title> Mix </title><trackList>
+<track><location>file:///a.mp3</location><title>Song</title><creator>Band</creator><duration>1000</duration></track>
+<track><location>file:///b.mp3</location><identifier>https://music.yandex.ru/track/5</identifier><title>Other</title></track>
+</trackList></playlist>`,
+			wantTitle: "Mix",
+			want: []playlistFileTrack{
+				{Title: "Song", Artist: "Band", DurationMs: 1000, Location: "file:///a.mp3"},
+				{Title: "Other", Location: "https://music.yandex.ru/track/5"},
+			},
+		},
+		{
+			name:   "jspf with location array",
+			format: playlistFormatJSPF,
+			data: `{"playlist": {"title": "Mix", "track": [
+				{"title": "Song", "creator": "Band", "duration": 1000, "location": ["file:///a.mp3", "https://music.yandex.ru/track/7"]},
+				{"title": "Solo", "location": "file:///b.mp3"}
+			]}}`,
+			wantTitle: "Mix",
+			want: []playlistFileTrack{
+				{Title: "Song", Artist: "Band", DurationMs: 1000, Location: "https://music.yandex.ru/track/7"},
+				{Title: "Solo", Location: "file:///b.mp3"},
+			},
+		},
+		{
+			name:   "csv exportify",
+			format: playlistFormatCSV,
+			data: "Track Name,Artist Name(s),Duration (ms)\n" +
+				"\"Hello, World\",Band,215000\n" +
+				",,\n",
+			want: []playlistFileTrack{
+				{Title: "Hello, World", Artist: "Band", DurationMs: 215000},
+			},
+		},
+		{
+			name:   "csv semicolon with minutes",
+			format: playlistFormatCSV,
+			data:   "Исполнитель;Название;Длительность;Ссылка\nКино;Кукушка;6:36;https://music.yandex.ru/track/9\n",
+			want: []playlistFileTrack{
+				{Title: "Кукушка", Artist: "Кино", DurationMs: 396000, Location: "https://music.yandex.ru/track/9"},
+			},
+		},
+		{
+			name:   "csv without header",
+			format: playlistFormatCSV,
+			data:   "Queen,Bohemian Rhapsody,5:55\nABBA,SOS\n",
+			want: []playlistFileTrack{
+				{Title: "Bohemian Rhapsody", Artist: "Queen", DurationMs: 355000},
+				{Title: "SOS", Artist: "ABBA"},
+			},
+		},
+		{
+			name:   "invalid utf-8",
+			format: playlistFormatM3U8,
+			data:   "#EXTINF:10,Caf\xe9\nx.mp3\n",
+			want:   []playlistFileTrack{{Title: "Caf?", DurationMs: 10000, Location: "x.mp3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := readPlaylistFile(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("readPlaylistFile: %v", err)
+			}
+			if f.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", f.Title, tt.wantTitle)
+			}
+			if len(f.Tracks) != len(tt.want) {
+				t.Fatalf("got %d tracks %+v, want %d", len(f.Tracks), f.Tracks, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if f.Tracks[i] != want {
+					t.Errorf("track %d = %+v, want %+v", i, f.Tracks[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadPlaylistFileErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{playlistFormatXSPF, "<playlist><trackList>"},
+		{playlistFormatJSPF, "{\"playlist\": "},
+		{"pls", "[playlist]"},
+	}
+	for _, tt := range tests {
+		_, err := readPlaylistFile(tt.format, []byte(tt.data))
+		if !errors.Is(err, errUnknownPlaylistFile) {
+			t.Errorf("readPlaylistFile(%s, %q) error = %v, want errUnknownPlaylistFile", tt.format, tt.data, err)
+		}
+	}
+}
+
+// Файлы, которые отдает экспорт, читаются импортом без потерь
+func TestPlaylistFileRoundTrip(t *testing.T) {
+	src := &playlistFile{Title: "Mix & <more>", Tracks: []playlistFileTrack{
+		{TrackID: 1, Title: "Song, part 1", Artist: "Band", DurationMs: 215000, Location: "https://example.com/api/v1/tracks/1/stream"},
+		{TrackID: 2, Title: "Другая", Artist: "Группа", DurationMs: 60000, Location: "https://example.com/api/v1/tracks/2/stream"},
+	}}
+	for _, format := range []string{playlistFormatM3U8, playlistFormatXSPF, playlistFormatJSPF} {
+		t.Run(format, func(t *testing.T) {
+			var buf strings.Builder
+			if err := writePlaylistFile(&buf, format, src); err != nil {
+				t.Fatalf("writePlaylistFile: %v", err)
+			}
+			got, err := readPlaylistFile(format, []byte(buf.String()))
+			if err != nil {
+				t.Fatalf("readPlaylistFile: %v\n%s", err, buf.String())
+			}
+			if got.Title != src.Title {
+				t.Errorf("Title = %q, want %q", got.Title, src.Title)
+			}
+			if len(got.Tracks) != len(src.Tracks) {
+				t.Fatalf("got %d tracks, want %d", len(got.Tracks), len(src.Tracks))
+			}
+			for i, want := range src.Tracks {
+				want.TrackID = 0
+				// XSPF и JSPF при импорте ведут на трек в Яндекс.Музыке
+				if format != playlistFormatM3U8 {
+					want.Location = yandexTrackURL(src.Tracks[i].TrackID)
+				}
+				if got.Tracks[i] != want {
+					t.Errorf("track %d = %+v, want %+v", i, got.Tracks[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFileDuration(t *testing.T) {
+	tests := []struct {
+		s    string
+		ms   bool
+		want int
+	}{
+		{"", false, 0},
+		{"3:35", false, 215000},
+		{"1:02:10", false, 3730000},
+		{"215", false, 215000},
+		{"215.5", false, 215500},
+		{"215000", true, 215000},
+		{"-5", false, 0},
+		{"3:xx", false, 0},
+		{"soon", false, 0},
+	}
+	for _, tt := range tests {
+		if got := parseFileDuration(tt.s, tt.ms); got != tt.want {
+			t.Errorf("parseFileDuration(%q, %v) = %d, want %d", tt.s, tt.ms, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArtistTitle(t *testing.T) {
+	tests := []struct {
+		in, artist, title string
+	}{
+		{"Queen - Bohemian Rhapsody", "Queen", "Bohemian Rhapsody"},
+		{" AC/DC  -  T.N.T. ", "AC/DC", "T.N.T."},
+		{"Jay-Z - 99 Problems", "Jay-Z", "99 Problems"},
+		{"A - B - C", "A", "B - C"},
+		{"Yesterday", "", "Yesterday"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		artist, title := splitArtistTitle(tt.in)
+		if artist != tt.artist || title != tt.title {
+			t.Errorf("splitArtistTitle(%q) = %q, %q; want %q, %q", tt.in, artist, title, tt.artist, tt.title)
+		}
+	}
+}
+
+// Поток через аккаунт комнаты без подписи - только для участников комнаты
+func TestStreamTrackRoomAccess(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.Exec("INSERT INTO rooms (code) VALUES ('ABCDE')"); err != nil {
+		t.Fatal(err)
+	}
+	roomID, err := getRoomIDByCode(db, "ABCDE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, _, err := createTelegramSession(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setRoomRole(db, roomID, roomMember{Type: memberTelegram, ID: 1}, roomRoleGuest); err != nil {
+		t.Fatal(err)
+	}
+	stranger, _, err := createTelegramSession(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := func(session string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/tracks/42/stream?room_code=ABCDE", nil)
+		r.Header.Set(telegramSessionHeader, session)
+		return r
+	}
+	// Аккаунта Яндекс.Музыки в тестовой базе нет: прошедший проверку запрос
+	// получает 503
+	tests := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"member", unsigned(guest), http.StatusServiceUnavailable},
+		{"not a member", unsigned(stranger), http.StatusForbidden},
+		{"signed link", signedStreamRequest(t, 42, "ABCDE", time.Now().Add(time.Hour), nil), http.StatusServiceUnavailable},
+		{"forged signature", signedStreamRequest(t, 42, "ABCDE", time.Now().Add(time.Hour), func(q url.Values) {
+			q.Set("sig", "AAAA")
+		}), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt.r.SetPathValue("id", "42")
+		rec := httptest.NewRecorder()
+		apiV1StreamTrack(rec, tt.r)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
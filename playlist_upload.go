@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Импорт файлов плейлистов других плееров (M3U, XSPF, JSPF, CSV) в комнату.
+// Импорт идет в два шага: загруженный файл сопоставляется с каталогом и
+// сохраняется на проверку, а треки добавляются после подтверждения.
+//   - ссылки Яндекс.Музыки (и ссылки из наших файлов плейлистов) берутся как есть;
+//   - пары исполнитель/название ищутся в каталоге, каждому найденному треку
+//     ставится уверенность от 0 до 1 по названию, исполнителю и длительности.
+//
+// Уверенные совпадения добавляются сразу, сомнительные ждут выбора из
+// кандидатов, ненайденные пропускаются.
+
+// Статусы записей файла
+const (
+	uploadLink      = "link"      // ссылка на трек в файле
+	uploadMatched   = "matched"   // найден уверенно
+	uploadAmbiguous = "ambiguous" // нужен выбор из кандидатов
+	uploadNotFound  = "not_found"
+)
+
+// skipNotMatched - запись файла не сопоставлена или пропущена при проверке
+const skipNotMatched = "not_matched"
+
+const (
+	autoMatchConfidence = 0.85 // с такой уверенностью трек добавляется без проверки
+	minMatchConfidence  = 0.5  // кандидаты хуже не показываются
+	maxMatchCandidates  = 5
+
+	maxUploadSize       = 2 << 20
+	maxUploadEntries    = 1000
+	uploadSearchWorkers = 4
+	uploadLifetime      = 24 * time.Hour // непроверенные файлы потом удаляются
+)
+
+var (
+	errUploadTooLarge = errors.New("playlist file is too large")
+
+	yandexMusicLinkRe = regexp.MustCompile(`(?i)^https?://music\.yandex\.[a-z]+/`)
+	streamLinkRe      = regexp.MustCompile(`/api/v1/tracks/(\d+)/stream`)
+	trackNumberRe     = regexp.MustCompile(`^\d{1,3}[\s.\-_)]+`)
+	bracketsRe        = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]`)
+)
+
+func createPlaylistUploadsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS playlist_uploads (
+		id INTEGER PRIMARY KEY,
+		room_id INTEGER NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		format TEXT NOT NULL,
+		uploaded_by TEXT NOT NULL DEFAULT '',
+		entries TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	return err
+}
+
+// uploadCandidate - трек каталога, подходящий к записи файла
+type uploadCandidate struct {
+	TrackID    int     `json:"track_id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	DurationMs int     `json:"duration_ms"`
+	Confidence float64 `json:"confidence"`
+}
+
+// uploadEntry - запись файла и результат сопоставления. Match - трек,
+// который будет добавлен; у сомнительных записей это лучший кандидат.
+type uploadEntry struct {
+	Position   int               `json:"position"`
+	Title      string            `json:"title"`
+	Artist     string            `json:"artist"`
+	DurationMs int               `json:"duration_ms"`
+	Location   string            `json:"location,omitempty"`
+	Status     string            `json:"status"`
+	Match      *uploadCandidate  `json:"match,omitempty"`
+	Candidates []uploadCandidate `json:"candidates,omitempty"`
+}
+
+type playlistUpload struct {
+	ID         int            `json:"id"`
+	RoomID     int            `json:"-"`
+	Name       string         `json:"name"`
+	Format     string         `json:"format"`
+	UploadedBy string         `json:"uploaded_by"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Entries    []uploadEntry  `json:"entries"`
+	Summary    map[string]int `json:"summary"` // число записей по статусам
+}
+
+func (u *playlistUpload) summarize() {
+	u.Summary = map[string]int{uploadLink: 0, uploadMatched: 0, uploadAmbiguous: 0, uploadNotFound: 0}
+	for _, e := range u.Entries {
+		u.Summary[e.Status]++
+	}
+}
+
+// isYandexMusicLink - ссылка на music.yandex.ru (.com, .by, .kz)
+func isYandexMusicLink(s string) bool {
+	return yandexMusicLinkRe.MatchString(strings.TrimSpace(s))
+}
+
+// candidateFromTrack - трек каталога как кандидат с уверенностью confidence
+func candidateFromTrack(t *yandexTrack, confidence float64) uploadCandidate {
+	return uploadCandidate{
+		TrackID:    int(t.ID),
+		Title:      t.Title,
+		Artist:     t.ArtistName(),
+		DurationMs: t.DurationMs,
+		Confidence: confidence,
+	}
+}
+
+// resolveUpload сопоставляет записи файла с каталогом: ссылки разбираются
+// сразу, остальное ищется поиском в несколько потоков
+func resolveUpload(ctx context.Context, api *yandexAPI, f *playlistFile) ([]uploadEntry, error) {
+	var entries []uploadEntry
+	var linked, search []int // индексы записей со ссылкой на трек и для поиска
+
+	for _, t := range f.Tracks {
+		e := uploadEntry{Title: t.Title, Artist: t.Artist, DurationMs: t.DurationMs, Location: t.Location}
+
+		link, err := uploadEntryLink(t.Location)
+		switch {
+		case link == nil:
+			if e.Title == "" {
+				e.Artist, e.Title = titleFromLocation(e.Location)
+			}
+			search = append(search, len(entries))
+			entries = append(entries, e)
+		case err != nil:
+			e.Status = uploadNotFound
+			entries = append(entries, e)
+		case link.Kind == musicLinkTrack:
+			linked = append(linked, len(entries))
+			entries = append(entries, uploadEntry{Title: e.Title, Artist: e.Artist, DurationMs: e.DurationMs, Location: e.Location, Match: &uploadCandidate{TrackID: link.ID}})
+		default:
+			// Альбом, исполнитель или плейлист - все его треки по порядку
+			_, tracks, err := expandMusicLink(ctx, api, link)
+			if isYandexNotFound(err) {
+				e.Status = uploadNotFound
+				entries = append(entries, e)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for i := range tracks {
+				entries = append(entries, linkedEntry(&tracks[i], e.Location))
+			}
+		}
+		// Ограничение касается и треков из развернутых ссылок: одна ссылка
+		// на исполнителя может дать тысячи записей
+		if len(entries) > maxUploadEntries {
+			return nil, errUploadTooLarge
+		}
+	}
+
+	if len(linked) > 0 {
+		ids := make([]int, len(linked))
+		for i, idx := range linked {
+			ids[i] = entries[idx].Match.TrackID
+		}
+		tracks, err := api.Tracks(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i, idx := range linked {
+			e := linkedEntry(&tracks[i], entries[idx].Location)
+			if entries[idx].Title != "" {
+				e.Title, e.Artist = entries[idx].Title, entries[idx].Artist
+			}
+			entries[idx] = e
+		}
+	}
+
+	if err := searchUploadEntries(ctx, api, entries, search); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Position = i + 1
+	}
+	return entries, nil
+}
+
+// uploadEntryLink распознает ссылку Яндекс.Музыки или ссылку на наш
+// /api/v1/tracks/{id}/stream. nil - в записи нет ссылки, ее нужно искать.
+func uploadEntryLink(location string) (*musicLink, error) {
+	if m := streamLinkRe.FindStringSubmatch(location); m != nil {
+		id, err := strconv.Atoi(m[1])
+		return &musicLink{Kind: musicLinkTrack, ID: id}, err
+	}
+	if !isYandexMusicLink(location) {
+		return nil, nil
+	}
+	link, err := parseMusicLink(location)
+	if err != nil {
+		return &musicLink{}, err
+	}
+	return link, nil
+}
+
+// linkedEntry - запись для трека по ссылке; недоступный трек не добавить
+func linkedEntry(t *yandexTrack, location string) uploadEntry {
+	e := uploadEntry{Title: t.Title, Artist: t.ArtistName(), DurationMs: t.DurationMs, Location: location, Status: uploadNotFound}
+	if t.IsAvailable() {
+		c := candidateFromTrack(t, 1)
+		e.Status, e.Match = uploadLink, &c
+	}
+	return e
+}
+
+// titleFromLocation достает исполнителя и название из имени файла:
+// "/music/01 - Artist - Title.mp3"
+func titleFromLocation(location string) (artist, title string) {
+	name := strings.ReplaceAll(location, `\`, "/")
+	if u, err := url.Parse(name); err == nil && u.Path != "" {
+		name = u.Path
+	}
+	name = path.Base(name)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.ReplaceAll(name, "_", " ")
+	return splitArtistTitle(trackNumberRe.ReplaceAllString(name, ""))
+}
+
+// searchUploadEntries ищет записи с индексами idx; первая ошибка прерывает поиск
+func searchUploadEntries(ctx context.Context, api *yandexAPI, entries []uploadEntry, idx []int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	jobs := make(chan int)
+	for w := 0; w < uploadSearchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := matchUploadEntry(ctx, api, &entries[i]); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, i := range idx {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// matchUploadEntry ищет запись в каталоге и выбирает кандидатов
+func matchUploadEntry(ctx context.Context, api *yandexAPI, e *uploadEntry) error {
+	e.Status = uploadNotFound
+	query := strings.TrimSpace(e.Artist + " " + e.Title)
+	if query == "" {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	found, err := api.SearchTracks(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool, len(found))
+	var candidates []uploadCandidate
+	for i := range found {
+		t := &found[i]
+		if !t.IsAvailable() || seen[int(t.ID)] {
+			continue
+		}
+		seen[int(t.ID)] = true
+		if c := matchConfidence(e, t); c >= minMatchConfidence {
+			candidates = append(candidates, candidateFromTrack(t, c))
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// При равной уверенности сохраняется порядок поиска
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	e.Candidates = candidates[:min(len(candidates), maxMatchCandidates)]
+	e.Match = &e.Candidates[0]
+	e.Status = uploadAmbiguous
+	if e.Match.Confidence >= autoMatchConfidence {
+		e.Status = uploadMatched
+	}
+	return nil
+}
+
+// matchConfidence - насколько трек каталога похож на запись файла.
+// Без исполнителя совпадение названия не дает уверенности: такие записи
+// всегда попадают на проверку.
+func matchConfidence(e *uploadEntry, t *yandexTrack) float64 {
+	title := textSimilarity(e.Title, t.Title)
+	score := 0.8 * title
+	if e.Artist != "" {
+		score = 0.6*title + 0.4*artistSimilarity(e.Artist, t)
+	}
+	// Длительность отличает, например, концертную запись от студийной
+	if e.DurationMs > 0 && t.DurationMs > 0 {
+		diff := math.Abs(float64(e.DurationMs-t.DurationMs)) / 1000
+		duration := min(1, max(0, 1-(diff-3)/27)) // до 3 с - совпадает, от 30 с - нет
+		score = 0.85*score + 0.15*duration
+	}
+	return math.Round(score*100) / 100
+}
+
+// artistSimilarity сравнивает исполнителя из файла со всеми исполнителями
+// трека вместе и с каждым по отдельности ("A feat. B" и "A")
+func artistSimilarity(artist string, t *yandexTrack) float64 {
+	best := textSimilarity(artist, t.ArtistName())
+	parts := strings.FieldsFunc(strings.ToLower(artist), func(r rune) bool { return r == ',' || r == '&' || r == ';' })
+	for _, a := range t.Artists {
+		best = max(best, textSimilarity(artist, a.Name))
+		if len(parts) > 0 {
+			first, _, _ := strings.Cut(parts[0], " feat")
+			best = max(best, textSimilarity(first, a.Name))
+		}
+	}
+	return best
+}
+
+// textSimilarity - похожесть строк от 0 до 1 без учета регистра и знаков.
+// Уточнения в скобках ("(Remastered)", "[feat. X]") почти не влияют.
+func textSimilarity(a, b string) float64 {
+	full := stringSimilarity(normalizeMatchText(a), normalizeMatchText(b))
+	bare := stringSimilarity(normalizeMatchText(bracketsRe.ReplaceAllString(a, "")), normalizeMatchText(bracketsRe.ReplaceAllString(b, "")))
+	return max(full, 0.95*bare)
+}
+
+func normalizeMatchText(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// stringSimilarity - 1 минус расстояние Левенштейна, деленное на длину большей строки
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		if len(ra) == len(rb) {
+			return 1
+		}
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// saveUpload сохраняет файл на проверку и заодно удаляет устаревшие
+func saveUpload(ctx context.Context, u *playlistUpload) error {
+	entries, err := json.Marshal(u.Entries)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM playlist_uploads WHERE created_at < ?", time.Now().UTC().Add(-uploadLifetime)); err != nil {
+		log.Printf("Error deleting expired playlist uploads: %v", err)
+	}
+
+	u.CreatedAt = time.Now().UTC()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO playlist_uploads (room_id, name, format, uploaded_by, entries, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		u.RoomID, u.Name, u.Format, u.UploadedBy, string(entries), u.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	u.ID = int(id)
+	return err
+}
+
+// getUpload - непросроченный файл комнаты; sql.ErrNoRows, если его нет
+func getUpload(ctx context.Context, roomID, id int) (*playlistUpload, error) {
+	u := &playlistUpload{ID: id, RoomID: roomID}
+	var entries string
+	err := db.QueryRowContext(ctx, `
+		SELECT name, format, uploaded_by, entries, created_at FROM playlist_uploads
+		WHERE id = ? AND room_id = ? AND created_at >= ?`,
+		id, roomID, time.Now().UTC().Add(-uploadLifetime)).Scan(&u.Name, &u.Format, &u.UploadedBy, &entries, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(entries), &u.Entries); err != nil {
+		return nil, fmt.Errorf("invalid upload %d entries: %w", id, err)
+	}
+	u.summarize()
+	return u, nil
+}
+
+// uploadTracks - треки к добавлению с учетом выбора при проверке.
+// choices: позиция записи -> ID трека, 0 - пропустить запись.
+// acceptAmbiguous - взять лучших кандидатов для сомнительных записей без выбора.
+// Треки, выбранные не из кандидатов, запрашиваются у Яндекс.Музыки одним
+// пакетом, недоступные пропускаются.
+func uploadTracks(ctx context.Context, api *yandexAPI, u *playlistUpload, choices map[int]int, acceptAmbiguous bool) ([]yandexTrack, []bulkAddTrack, error) {
+	var tracks []yandexTrack
+	var lookup []int // индексы в tracks треков, выбранных не из кандидатов
+	skipped := []bulkAddTrack{}
+	for _, e := range u.Entries {
+		match := e.Match
+		choice, chosen := choices[e.Position]
+		switch {
+		case chosen && choice <= 0:
+			match = nil
+		case chosen:
+			// Можно выбрать и трек не из кандидатов, например по ссылке
+			match = nil
+			for _, c := range e.Candidates {
+				if c.TrackID == choice {
+					match = &c
+				}
+			}
+			if match == nil {
+				lookup = append(lookup, len(tracks))
+				tracks = append(tracks, yandexTrack{ID: yandexID(choice), Title: entryTitle(e)})
+				continue
+			}
+		case e.Status == uploadAmbiguous && !acceptAmbiguous, e.Status == uploadNotFound:
+			match = nil
+		}
+
+		if match == nil {
+			skipped = append(skipped, bulkAddTrack{Title: entryTitle(e), Artist: e.Artist, Reason: skipNotMatched})
+			continue
+		}
+		t := yandexTrack{ID: yandexID(match.TrackID), Title: match.Title, DurationMs: match.DurationMs}
+		if match.Artist != "" {
+			t.Artists = []yandexArtistRef{{Name: match.Artist}}
+		}
+		tracks = append(tracks, t)
+	}
+	if len(lookup) == 0 {
+		return tracks, skipped, nil
+	}
+
+	ids := make([]int, len(lookup))
+	for i, idx := range lookup {
+		ids[i] = int(tracks[idx].ID)
+	}
+	found, err := api.Tracks(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	unavailable := make(map[int]bool)
+	for i, idx := range lookup {
+		if !found[i].IsAvailable() {
+			skipped = append(skipped, bulkAddTrack{TrackID: ids[i], Title: tracks[idx].Title, Reason: skipUnavailable})
+			unavailable[idx] = true
+			continue
+		}
+		tracks[idx] = found[i]
+	}
+
+	result := make([]yandexTrack, 0, len(tracks)-len(unavailable))
+	for i, t := range tracks {
+		if !unavailable[i] {
+			result = append(result, t)
+		}
+	}
+	return result, skipped, nil
+}
+
+// entryTitle - название записи файла для отчета; без названия - ее адрес
+func entryTitle(e uploadEntry) string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return e.Location
+}
+
+// readUploadedFile читает файл из поля file формы или из тела запроса.
+// Имя - имя файла или ?name=.
+func readUploadedFile(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+64<<10)
+
+	name := r.URL.Query().Get("name")
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return "", nil, errUploadTooLarge
+			}
+			return "", nil, err
+		}
+		defer file.Close()
+		if name == "" {
+			name = header.Filename
+		}
+		src = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(src, maxUploadSize+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || len(data) > maxUploadSize {
+		return "", nil, errUploadTooLarge
+	}
+	return name, data, err
+}
+
+// uploadForRequest находит загруженный файл из пути запроса и проверяет
+// право на действие в его комнате.
+// При ошибке сам отвечает клиенту и возвращает false.
+func uploadForRequest(w http.ResponseWriter, r *http.Request, action string) (*playlistUpload, bool) {
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), action)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "http.upload_not_found", http.StatusNotFound)
+		return nil, false
+	}
+
+	u, err := getUpload(r.Context(), roomID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.upload_not_found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error loading playlist upload %d: %v", id, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// POST /api/v1/rooms/{code}/uploads[?format=csv&name=...] - загрузить файл
+// плейлиста (multipart с полем file или сам файл в теле). Треки не
+// добавляются: в ответе результат сопоставления для проверки.
+func apiV1UploadPlaylist(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionAdd)
+	if !ok {
+		return
+	}
+
+	name, data, err := readUploadedFile(w, r)
+	if errors.Is(err, errUploadTooLarge) {
+		httpError(w, r, "http.upload_too_large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = playlistFileFormat(name, data)
+	}
+	f, err := readPlaylistFile(format, data)
+	if err != nil {
+		log.Printf("Error reading playlist file %q: %v", name, err)
+		httpError(w, r, "http.invalid_upload_file", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(f.Tracks) == 0:
+		httpError(w, r, "http.empty_upload_file", http.StatusBadRequest)
+		return
+	case len(f.Tracks) > maxUploadEntries:
+		httpError(w, r, "http.upload_too_large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	api, ok := roomYandexAPI(w, r, roomID)
+	if !ok {
+		return
+	}
+	entries, err := resolveUpload(r.Context(), api, f)
+	if errors.Is(err, errUploadTooLarge) {
+		httpError(w, r, "http.upload_too_large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("Error matching playlist file %q: %v", name, err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return
+	}
+
+	u := &playlistUpload{RoomID: roomID, Name: f.Title, Format: format, UploadedBy: requestAddedBy(r), Entries: entries}
+	if u.Name == "" {
+		u.Name = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	if err := saveUpload(r.Context(), u); err != nil {
+		log.Printf("Error saving playlist upload: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	u.summarize()
+
+	log.Printf("Room %d: uploaded %s playlist %q, %d entries: %v", roomID, format, u.Name, len(entries), u.Summary)
+	w.Header().Set("Location", fmt.Sprintf("%srooms/%s/uploads/%d", apiV1Prefix, r.PathValue("code"), u.ID))
+	writeJSON(w, http.StatusCreated, u)
+}
+
+// GET /api/v1/rooms/{code}/uploads/{id} - результат сопоставления
+func apiV1GetUpload(w http.ResponseWriter, r *http.Request) {
+	u, ok := uploadForRequest(w, r, roomActionView)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// POST /api/v1/rooms/{code}/uploads/{id}/confirm {"choices": {"3": 12345, "5": 0},
+// "accept_ambiguous": false} - добавить треки в комнату. Файл после этого удаляется.
+func apiV1ConfirmUpload(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Choices         map[int]int `json:"choices"`
+		AcceptAmbiguous bool        `json:"accept_ambiguous"`
+	}
+	// Пустое тело - добавить только уверенные совпадения
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	u, ok := uploadForRequest(w, r, roomActionAdd)
+	if !ok {
+		return
+	}
+
+	api, ok := roomYandexAPI(w, r, u.RoomID)
+	if !ok {
+		return
+	}
+
+	tracks, skipped, err := uploadTracks(r.Context(), api, u, requestData.Choices, requestData.AcceptAmbiguous)
+	if err != nil {
+		log.Printf("Error looking up chosen tracks for upload %d: %v", u.ID, err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return
+	}
+	res, err := bulkInsertTracks(r.Context(), u.RoomID, u.Name, tracks, requestAddedBy(r), requestSource(r))
+	if err != nil {
+		log.Printf("Error adding uploaded playlist %d: %v", u.ID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	res.Skipped = append(res.Skipped, skipped...)
+
+	if _, err := db.ExecContext(r.Context(), "DELETE FROM playlist_uploads WHERE id = ?", u.ID); err != nil {
+		log.Printf("Error deleting playlist upload %d: %v", u.ID, err)
+	}
+	log.Printf("Room %d: added %d tracks from uploaded playlist %q, %d skipped", u.RoomID, len(res.Added), u.Name, len(res.Skipped))
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// DELETE /api/v1/rooms/{code}/uploads/{id} - отменить импорт
+func apiV1DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	u, ok := uploadForRequest(w, r, roomActionAdd)
+	if !ok {
+		return
+	}
+	if _, err := db.ExecContext(r.Context(), "DELETE FROM playlist_uploads WHERE id = ?", u.ID); err != nil {
+		log.Printf("Error deleting playlist upload %d: %v", u.ID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStringSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abc", "", 0},
+		{"", "abc", 0},
+		{"queen", "queen", 1},
+		{"queen", "queer", 0.8},
+		{"abcd", "abdc", 0.5},
+		{"кино", "кина", 0.75},
+		{"abc", "xyz", 0},
+	}
+	for _, tt := range tests {
+		got := stringSimilarity(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("stringSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if back := stringSimilarity(tt.b, tt.a); back != got {
+			t.Errorf("stringSimilarity(%q, %q) = %v, not symmetric with %v", tt.b, tt.a, back, got)
+		}
+	}
+}
+
+func TestTextSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"Bohemian Rhapsody", "bohemian rhapsody", 1},
+		{"Don't Stop Me Now", "DON'T STOP ME NOW!", 1},
+		{"Ёлка", "елка", 1},
+		{"Yesterday (Remastered 2009)", "Yesterday", 0.95},
+		{"Song [feat. X]", "Song", 0.95},
+		{"", "", 1},
+		{"Song", "", 0},
+	}
+	for _, tt := range tests {
+		if got := textSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("textSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchConfidence(t *testing.T) {
+	queen := &yandexTrack{Title: "Bohemian Rhapsody", DurationMs: 355000, Artists: []yandexArtistRef{{Name: "Queen"}}}
+	duet := &yandexTrack{Title: "Under Pressure", Artists: []yandexArtistRef{{Name: "Queen"}, {Name: "David Bowie"}}}
+
+	tests := []struct {
+		name  string
+		entry uploadEntry
+		track *yandexTrack
+		want  float64
+	}{
+		{"exact", uploadEntry{Title: "Bohemian Rhapsody", Artist: "Queen", DurationMs: 355000}, queen, 1},
+		{"duration within 3s", uploadEntry{Title: "Bohemian Rhapsody", Artist: "Queen", DurationMs: 357000}, queen, 1},
+		{"duration off by 30s", uploadEntry{Title: "Bohemian Rhapsody", Artist: "Queen", DurationMs: 400000}, queen, 0.85},
+		{"no duration in file", uploadEntry{Title: "Bohemian Rhapsody", Artist: "Queen"}, queen, 1},
+		{"title only", uploadEntry{Title: "Bohemian Rhapsody"}, queen, 0.8},
+		{"wrong artist", uploadEntry{Title: "Bohemian Rhapsody", Artist: "ABBA"}, queen, 0.6},
+		{"one of the artists", uploadEntry{Title: "Under Pressure", Artist: "David Bowie"}, duet, 1},
+		{"feat in artist", uploadEntry{Title: "Under Pressure", Artist: "Queen feat. David Bowie"}, duet, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchConfidence(&tt.entry, tt.track); got != tt.want {
+				t.Errorf("matchConfidence = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Трек без исполнителя в файле никогда не добавляется без проверки
+func TestMatchConfidenceTitleOnlyIsAmbiguous(t *testing.T) {
+	e := &uploadEntry{Title: "Yesterday", DurationMs: 125000}
+	track := &yandexTrack{Title: "Yesterday", DurationMs: 125000, Artists: []yandexArtistRef{{Name: "The Beatles"}}}
+	if got := matchConfidence(e, track); got >= autoMatchConfidence {
+		t.Errorf("matchConfidence = %v, want below %v", got, autoMatchConfidence)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// roomRole - роль участника внутри комнаты. Как и роли бота, упорядочены:
+// каждая следующая включает права предыдущей.
+type roomRole int
+
+const (
+	roomRoleNone   roomRole = iota
+	roomRoleKicked          // исключен хозяином и не может войти по коду снова
+	roomRoleGuest
+	roomRoleDJ
+	roomRoleHost
+)
+
+func (r roomRole) String() string {
+	switch r {
+	case roomRoleKicked:
+		return "kicked"
+	case roomRoleGuest:
+		return "guest"
+	case roomRoleDJ:
+		return "dj"
+	case roomRoleHost:
+		return "host"
+	default:
+		return "none"
+	}
+}
+
+func parseRoomRole(s string) (roomRole, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "kicked":
+		return roomRoleKicked, nil
+	case "guest":
+		return roomRoleGuest, nil
+	case "dj":
+		return roomRoleDJ, nil
+	case "host":
+		return roomRoleHost, nil
+	default:
+		return roomRoleNone, fmt.Errorf("unknown room role: %s", s)
+	}
+}
+
+// Действия в комнате
+const (
+	roomActionView     = "view" // очередь, участники, синхронизации и файлы комнаты
+	roomActionAdd      = "add"
+	roomActionRemove   = "remove"
+	roomActionReorder  = "reorder"
+	roomActionPlayback = "playback"
+	roomActionManage   = "manage" // смена ролей и исключение участников
+)
+
+// Минимальная роль в комнате для каждого действия
+var roomActionRoles = map[string]roomRole{
+	roomActionView:     roomRoleGuest,
+	roomActionAdd:      roomRoleGuest,
+	roomActionRemove:   roomRoleDJ,
+	roomActionReorder:  roomRoleDJ,
+	roomActionPlayback: roomRoleDJ,
+	roomActionManage:   roomRoleHost,
+}
+
+// Участник комнаты - пользователь веб-интерфейса или пользователь Telegram
+const (
+	memberUser     = "user"
+	memberTelegram = "telegram"
+)
+
+type roomMember struct {
+	Type string `json:"member_type"`
+	ID   int64  `json:"member_id"`
+}
+
+// String - участник в виде "user:2" или "telegram:123456"
+func (m roomMember) String() string {
+	return m.Type + ":" + strconv.FormatInt(m.ID, 10)
+}
+
+func createRoomRolesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS room_roles (
+		room_id INTEGER NOT NULL,
+		member_type TEXT NOT NULL,
+		member_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (room_id, member_type, member_id)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// Создатель комнаты. У комнат, созданных до появления ролей, его нет -
+	// такими комнатами управляют только администраторы.
+	if err := addColumnIfNotExists(tx, "rooms", "creator_type", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "rooms", "creator_id", "INTEGER"); err != nil {
+		return err
+	}
+	return migrateRoomMembers(tx)
+}
+
+// migrateRoomMembers переносит участников из старой таблицы room_members,
+// где хранились только пользователи Telegram, в room_roles гостями.
+// Уже назначенные роли не меняются.
+func migrateRoomMembers(tx *sql.Tx) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'room_members')").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        INSERT OR IGNORE INTO room_roles (room_id, member_type, member_id, role, joined_at)
+        SELECT room_id, ?, telegram_user_id, ?, joined_at FROM room_members`,
+		memberTelegram, roomRoleGuest.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE room_members")
+	return err
+}
+
+// requestMember определяет, от чьего имени пришел запрос
+func requestMember(r *http.Request) (roomMember, bool) {
+	if user := currentUser(r); user != nil {
+		return roomMember{Type: memberUser, ID: int64(user.ID)}, true
+	}
+	if userID, ok := telegramSessionUser(r); ok {
+		return roomMember{Type: memberTelegram, ID: userID}, true
+	}
+	return roomMember{}, false
+}
+
+func getRoomIDByCode(db *sql.DB, code string) (int, error) {
+	var roomID int
+	err := db.QueryRow("SELECT id FROM rooms WHERE code = ?", strings.ToUpper(strings.TrimSpace(code))).Scan(&roomID)
+	return roomID, err
+}
+
+func getRoomRole(db *sql.DB, roomID int, member roomMember) (roomRole, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM room_roles WHERE room_id = ? AND member_type = ? AND member_id = ?",
+		roomID, member.Type, member.ID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return roomRoleNone, nil
+	}
+	if err != nil {
+		return roomRoleNone, err
+	}
+	return parseRoomRole(role)
+}
+
+func setRoomRole(db *sql.DB, roomID int, member roomMember, role roomRole) error {
+	_, err := db.Exec(`
+        INSERT INTO room_roles (room_id, member_type, member_id, role) VALUES (?, ?, ?, ?)
+        ON CONFLICT (room_id, member_type, member_id) DO UPDATE SET role = excluded.role`,
+		roomID, member.Type, member.ID, role.String())
+	return err
+}
+
+// joinRoomAsGuest добавляет участника гостем. Роль уже состоящего в комнате
+// не меняется, поэтому исключенный не вернется, заново введя код.
+func joinRoomAsGuest(db *sql.DB, roomID int, member roomMember) (roomRole, error) {
+	_, err := db.Exec("INSERT OR IGNORE INTO room_roles (room_id, member_type, member_id, role) VALUES (?, ?, ?, ?)",
+		roomID, member.Type, member.ID, roomRoleGuest.String())
+	if err != nil {
+		return roomRoleNone, err
+	}
+	return getRoomRole(db, roomID, member)
+}
+
+// setRoomCreator записывает создателя комнаты и делает его хозяином
+func setRoomCreator(db *sql.DB, roomID int, member roomMember) error {
+	if _, err := db.Exec("UPDATE rooms SET creator_type = ?, creator_id = ? WHERE id = ?",
+		member.Type, member.ID, roomID); err != nil {
+		return err
+	}
+	return setRoomRole(db, roomID, member, roomRoleHost)
+}
+
+// getRoomCreator возвращает создателя комнаты. У старых комнат его нет.
+func getRoomCreator(db *sql.DB, roomID int) (roomMember, bool, error) {
+	var creatorType sql.NullString
+	var creatorID sql.NullInt64
+	err := db.QueryRow("SELECT creator_type, creator_id FROM rooms WHERE id = ?", roomID).Scan(&creatorType, &creatorID)
+	if err != nil {
+		return roomMember{}, false, err
+	}
+	if !creatorType.Valid || !creatorID.Valid {
+		return roomMember{}, false, nil
+	}
+	return roomMember{Type: creatorType.String, ID: creatorID.Int64}, true, nil
+}
+
+// RoomMemberInfo - участник комнаты в ответе API
+type RoomMemberInfo struct {
+	roomMember
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func listRoomMembers(db *sql.DB, roomID int) ([]RoomMemberInfo, error) {
+	rows, err := db.Query(`
+        SELECT room_roles.member_type, room_roles.member_id, COALESCE(users.username, ''),
+            room_roles.role, room_roles.joined_at
+        FROM room_roles
+        LEFT JOIN users ON room_roles.member_type = 'user' AND users.id = room_roles.member_id
+        WHERE room_roles.room_id = ?
+        ORDER BY room_roles.joined_at`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []RoomMemberInfo{}
+	for rows.Next() {
+		var m RoomMemberInfo
+		if err := rows.Scan(&m.Type, &m.ID, &m.Name, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// checkRoomAction проверяет право участника на действие в комнате.
+// Администраторы веб-интерфейса могут все во всех комнатах.
+func checkRoomAction(r *http.Request, roomID int, action string) (bool, error) {
+	if user := currentUser(r); user != nil && user.IsAdmin {
+		return true, nil
+	}
+
+	member, ok := requestMember(r)
+	if !ok {
+		return false, nil
+	}
+	role, err := getRoomRole(db, roomID, member)
+	if err != nil {
+		return false, err
+	}
+	return role >= roomActionRoles[action], nil
+}
+
+// authorizeRoomAction находит комнату по коду и проверяет право на действие.
+// При отказе сам отвечает клиенту ошибкой и возвращает false.
+func authorizeRoomAction(w http.ResponseWriter, r *http.Request, roomCode, action string) (int, bool) {
+	if strings.TrimSpace(roomCode) == "" {
+		httpError(w, r, "http.room_code_required", http.StatusBadRequest)
+		return 0, false
+	}
+
+	roomID, err := getRoomIDByCode(db, roomCode)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.room_not_found", http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Error querying room: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	allowed, err := checkRoomAction(r, roomID, action)
+	if err != nil {
+		log.Printf("Error checking room role: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !allowed {
+		httpError(w, r, "http.room_forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return roomID, true
+}
+
+// changeMemberRole - смена роли или исключение участника хозяином комнаты.
+// Возвращает ключ сообщения об ошибке для клиента.
+func changeMemberRole(r *http.Request, roomID int, target roomMember, role roomRole) (string, error) {
+	if target.Type != memberUser && target.Type != memberTelegram {
+		return "http.invalid_request", nil
+	}
+	if self, ok := requestMember(r); ok && self == target {
+		return "http.room_change_self", nil
+	}
+
+	current, err := getRoomRole(db, roomID, target)
+	if err != nil {
+		return "", err
+	}
+	if current == roomRoleNone {
+		return "http.member_not_found", nil
+	}
+
+	// Создателя комнаты другие хозяева не могут понизить или исключить -
+	// только администратор
+	if user := currentUser(r); user == nil || !user.IsAdmin {
+		creator, ok, err := getRoomCreator(db, roomID)
+		if err != nil {
+			return "", err
+		}
+		if ok && creator == target {
+			return "http.creator_protected", nil
+		}
+	}
+
+	if err := setRoomRole(db, roomID, target, role); err != nil {
+		return "", err
+	}
+	log.Printf("Room %d: %s %d is now %s", roomID, target.Type, target.ID, role)
+
+	// Сообщаем открытым плеерам, чтобы они обновили список участников
+	wsSend(map[string]interface{}{
+		"type":        "memberUpdated",
+		"room_id":     roomID,
+		"member_type": target.Type,
+		"member_id":   target.ID,
+		"role":        role.String(),
+	})
+	return "", nil
+}
+
+// memberRoleErrorStatus - код ответа для ошибки changeMemberRole
+func memberRoleErrorStatus(errKey string) int {
+	switch errKey {
+	case "http.member_not_found":
+		return http.StatusNotFound
+	case "http.creator_protected":
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// Список участников комнаты: GET /api/room/members?room_code=ABCDE
+// или GET /api/v1/rooms/ABCDE/members
+func roomMembersHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := authorizeRoomAction(w, r, roomCodeParam(r, r.URL.Query().Get("room_code")), roomActionView)
+	if !ok {
+		return
+	}
+
+	members, err := listRoomMembers(db, roomID)
+	if err != nil {
+		log.Printf("Error listing room members: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(members); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// Смена роли участника (role: guest, dj, host) или исключение (role: kicked)
+func roomMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		RoomCode string `json:"room_code"`
+		roomMember
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	// Путь /kick - то же самое с ролью kicked
+	role := roomRoleKicked
+	if !strings.HasSuffix(r.URL.Path, "/kick") {
+		var err error
+		if role, err = parseRoomRole(requestData.Role); err != nil {
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	roomID, ok := authorizeRoomAction(w, r, requestData.RoomCode, roomActionManage)
+	if !ok {
+		return
+	}
+
+	errKey, err := changeMemberRole(r, roomID, requestData.roomMember, role)
+	if err != nil {
+		log.Printf("Error changing room role: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if errKey != "" {
+		httpError(w, r, errKey, memberRoleErrorStatus(errKey))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Сохраненные плейлисты: именованные списки треков, которые живут отдельно
+// от комнат. Их можно поставить в очередь любой комнаты, а очередь комнаты -
+// сохранить как новый плейлист. Один трек может быть в нескольких
+// сохраненных плейлистах, но в одном плейлисте - один раз.
+//
+// Смотреть плейлисты могут все, кто вошел, менять и удалять - владелец и
+// администраторы.
+
+const (
+	maxPlaylistName        = 200
+	maxPlaylistDescription = 2000
+	maxPlaylistCover       = 1000
+)
+
+func createSavedPlaylistsTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS playlists (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		cover_uri TEXT NOT NULL DEFAULT '',
+		owner TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS playlist_tracks (
+		playlist_id INTEGER NOT NULL,
+		track_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		artist TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (playlist_id, track_id)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS playlist_tracks_position ON playlist_tracks (playlist_id, position)")
+	return err
+}
+
+// savedPlaylist - сохраненный плейлист. Owner - "user:2" или "telegram:123456".
+type savedPlaylist struct {
+	ID          int                  `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	CoverURI    string               `json:"cover_uri"`
+	Owner       string               `json:"owner"`
+	TrackCount  int                  `json:"track_count"`
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+	Tracks      []savedPlaylistTrack `json:"tracks,omitempty"`
+}
+
+type savedPlaylistTrack struct {
+	TrackID    int       `json:"track_id"`
+	Position   int       `json:"position"`
+	Title      string    `json:"title"`
+	Artist     string    `json:"artist"`
+	DurationMs int       `json:"duration_ms"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+const savedPlaylistColumns = `
+	SELECT p.id, p.name, p.description, p.cover_uri, p.owner, p.created_at, p.updated_at,
+		(SELECT COUNT(*) FROM playlist_tracks t WHERE t.playlist_id = p.id)
+	FROM playlists p`
+
+func scanSavedPlaylist(row interface{ Scan(...interface{}) error }) (*savedPlaylist, error) {
+	var p savedPlaylist
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.CoverURI, &p.Owner, &p.CreatedAt, &p.UpdatedAt, &p.TrackCount)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func listSavedPlaylists(ctx context.Context, owner string) ([]savedPlaylist, error) {
+	query := savedPlaylistColumns
+	var args []interface{}
+	if owner != "" {
+		query += " WHERE p.owner = ?"
+		args = append(args, owner)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY p.name COLLATE NOCASE, p.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []savedPlaylist{}
+	for rows.Next() {
+		p, err := scanSavedPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, *p)
+	}
+	return playlists, rows.Err()
+}
+
+// getSavedPlaylist возвращает плейлист с треками по порядку
+func getSavedPlaylist(ctx context.Context, id int) (*savedPlaylist, error) {
+	p, err := scanSavedPlaylist(db.QueryRowContext(ctx, savedPlaylistColumns+" WHERE p.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT track_id, position, title, artist, duration_ms, added_at
+		FROM playlist_tracks WHERE playlist_id = ? ORDER BY position, added_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p.Tracks = []savedPlaylistTrack{}
+	for rows.Next() {
+		var t savedPlaylistTrack
+		if err := rows.Scan(&t.TrackID, &t.Position, &t.Title, &t.Artist, &t.DurationMs, &t.AddedAt); err != nil {
+			return nil, err
+		}
+		p.Tracks = append(p.Tracks, t)
+	}
+	return p, rows.Err()
+}
+
+// insertSavedPlaylistTracks добавляет треки в конец плейлиста, пропуская
+// те, что в нем уже есть. Возвращает пропущенные.
+func insertSavedPlaylistTracks(ctx context.Context, tx *sql.Tx, playlistID int, tracks []savedPlaylistTrack) ([]savedPlaylistTrack, error) {
+	var skipped []savedPlaylistTrack
+	for _, t := range tracks {
+		result, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO playlist_tracks (playlist_id, track_id, position, title, artist, duration_ms)
+			VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist_tracks WHERE playlist_id = ?), ?, ?, ?)`,
+			playlistID, t.TrackID, playlistID, t.Title, t.Artist, t.DurationMs)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			skipped = append(skipped, t)
+		}
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID)
+	return skipped, err
+}
+
+// addSavedPlaylistTracks - то же в отдельной транзакции
+func addSavedPlaylistTracks(ctx context.Context, playlistID int, tracks []savedPlaylistTrack) ([]savedPlaylistTrack, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	skipped, err := insertSavedPlaylistTracks(ctx, tx, playlistID, tracks)
+	if err != nil {
+		return nil, err
+	}
+	return skipped, tx.Commit()
+}
+
+// createSavedPlaylist создает плейлист с начальными треками
+func createSavedPlaylist(ctx context.Context, p *savedPlaylist, tracks []savedPlaylistTrack) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO playlists (name, description, cover_uri, owner) VALUES (?, ?, ?, ?)",
+		p.Name, p.Description, p.CoverURI, p.Owner)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := insertSavedPlaylistTracks(ctx, tx, int(id), tracks); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+func deleteSavedPlaylist(ctx context.Context, id int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM playlist_tracks WHERE playlist_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM playlists WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// moveSavedPlaylistTrack ставит трек на позицию position (с 1), сдвигая
+// остальные. Позиция за концом списка означает конец.
+func moveSavedPlaylistTrack(ctx context.Context, playlistID, trackID, position int) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT track_id FROM playlist_tracks WHERE playlist_id = ? ORDER BY position, added_at", playlistID)
+	if err != nil {
+		return false, err
+	}
+	var order []int
+	found := false
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if id == trackID {
+			found = true
+			continue
+		}
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if !found {
+		return false, nil
+	}
+
+	at := min(max(position, 1), len(order)+1) - 1
+	order = append(order[:at], append([]int{trackID}, order[at:]...)...)
+	for i, id := range order {
+		if _, err := tx.ExecContext(ctx, "UPDATE playlist_tracks SET position = ? WHERE playlist_id = ? AND track_id = ?", i+1, playlistID, id); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// roomQueueTracks - очередь комнаты по порядку. Названия, которых еще нет в
+// базе, дописывает fillPlaylistMetadata.
+func roomQueueTracks(ctx context.Context, roomID int) ([]savedPlaylistTrack, error) {
+	page, err := queryPlaylist(ctx, &trackQuery{RoomID: roomID, HasRoom: true, Sort: "position"})
+	if err != nil {
+		return nil, err
+	}
+	if err := fillPlaylistMetadata(ctx, page.Entries, yandexAPIForRoom(roomID)); err != nil {
+		log.Printf("Error getting track metadata: %v", err)
+	}
+
+	tracks := make([]savedPlaylistTrack, len(page.Entries))
+	for i, e := range page.Entries {
+		tracks[i] = savedPlaylistTrack{TrackID: e.TrackID, Title: e.Title, Artist: e.Artist, DurationMs: e.DurationMs}
+	}
+	return tracks, nil
+}
+
+// queueSavedPlaylist ставит треки плейлиста в конец очереди комнаты.
+// Треки, которые уже есть в очереди какой-либо комнаты, пропускаются.
+func queueSavedPlaylist(ctx context.Context, p *savedPlaylist, roomID int, addedBy, source string) (*bulkAddResult, error) {
+	tracks := make([]yandexTrack, len(p.Tracks))
+	for i, t := range p.Tracks {
+		tracks[i] = yandexTrack{ID: yandexID(t.TrackID), Title: t.Title, DurationMs: t.DurationMs}
+		if t.Artist != "" {
+			tracks[i].Artists = []yandexArtistRef{{Name: t.Artist}}
+		}
+	}
+	return bulkInsertTracks(ctx, roomID, p.Name, tracks, addedBy, source)
+}
+
+// canEditPlaylist - владелец плейлиста или администратор
+func canEditPlaylist(r *http.Request, p *savedPlaylist) bool {
+	if user := currentUser(r); user != nil && user.IsAdmin {
+		return true
+	}
+	member, ok := requestMember(r)
+	return ok && member.String() == p.Owner
+}
+
+// playlistForRequest находит плейлист из пути запроса; edit - нужно право
+// на изменение. При ошибке сам отвечает клиенту и возвращает false.
+func playlistForRequest(w http.ResponseWriter, r *http.Request, edit bool) (*savedPlaylist, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "http.playlist_not_found", http.StatusNotFound)
+		return nil, false
+	}
+
+	p, err := getSavedPlaylist(r.Context(), id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.playlist_not_found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error loading playlist %d: %v", id, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if edit && !canEditPlaylist(r, p) {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
+// playlistFields - изменяемые поля плейлиста. nil - поле не меняется.
+type playlistFields struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	CoverURI    *string `json:"cover_uri"`
+}
+
+// apply проверяет поля и переносит их в плейлист
+func (f *playlistFields) apply(p *savedPlaylist) bool {
+	if f.Name != nil {
+		p.Name = strings.TrimSpace(*f.Name)
+	}
+	if f.Description != nil {
+		p.Description = strings.TrimSpace(*f.Description)
+	}
+	if f.CoverURI != nil {
+		p.CoverURI = strings.TrimSpace(*f.CoverURI)
+	}
+	return p.Name != "" && len(p.Name) <= maxPlaylistName &&
+		len(p.Description) <= maxPlaylistDescription && len(p.CoverURI) <= maxPlaylistCover
+}
+
+// GET /api/v1/playlists[?owner=me] - сохраненные плейлисты без треков
+func apiV1ListPlaylists(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "me" {
+		member, ok := requestMember(r)
+		if !ok {
+			writeJSON(w, http.StatusOK, []savedPlaylist{})
+			return
+		}
+		owner = member.String()
+	}
+
+	playlists, err := listSavedPlaylists(r.Context(), owner)
+	if err != nil {
+		log.Printf("Error listing playlists: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, playlists)
+}
+
+// POST /api/v1/playlists {"name": "...", "description": "...", "cover_uri": "...",
+// "room_code": "ABCDE"}. С room_code плейлист создается из очереди комнаты.
+func apiV1CreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		playlistFields
+		RoomCode string `json:"room_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	member, ok := requestMember(r)
+	if !ok {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+	p := &savedPlaylist{Owner: member.String()}
+	if !requestData.apply(p) {
+		httpError(w, r, "http.invalid_playlist", http.StatusBadRequest)
+		return
+	}
+
+	var tracks []savedPlaylistTrack
+	if requestData.RoomCode != "" {
+		roomID, ok := authorizeRoomAction(w, r, requestData.RoomCode, roomActionView)
+		if !ok {
+			return
+		}
+		var err error
+		if tracks, err = roomQueueTracks(r.Context(), roomID); err != nil {
+			log.Printf("Error getting room tracks: %v", err)
+			httpError(w, r, "http.room_tracks", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	id, err := createSavedPlaylist(r.Context(), p, tracks)
+	if err != nil {
+		log.Printf("Error creating playlist: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	created, err := getSavedPlaylist(r.Context(), id)
+	if err != nil {
+		log.Printf("Error loading playlist %d: %v", id, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Playlist %d %q created by %s with %d tracks", id, p.Name, p.Owner, len(created.Tracks))
+	w.Header().Set("Location", apiV1Prefix+"playlists/"+strconv.Itoa(id))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// GET /api/v1/playlists/{id} - плейлист с треками
+func apiV1GetPlaylist(w http.ResponseWriter, r *http.Request) {
+	p, ok := playlistForRequest(w, r, false)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// PATCH /api/v1/playlists/{id} {"name": "...", "description": "...", "cover_uri": "..."}
+func apiV1UpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var fields playlistFields
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	p, ok := playlistForRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if !fields.apply(p) {
+		httpError(w, r, "http.invalid_playlist", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.ExecContext(r.Context(), `
+		UPDATE playlists SET name = ?, description = ?, cover_uri = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, p.Name, p.Description, p.CoverURI, p.ID)
+	if err != nil {
+		log.Printf("Error updating playlist %d: %v", p.ID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	p.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, p)
+}
+
+// DELETE /api/v1/playlists/{id}
+func apiV1DeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	p, ok := playlistForRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if err := deleteSavedPlaylist(r.Context(), p.ID); err != nil {
+		log.Printf("Error deleting playlist %d: %v", p.ID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Playlist %d %q deleted", p.ID, p.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/v1/playlists/{id}/tracks {"track_url": "..."} - ссылка на трек,
+// альбом, исполнителя или плейлист Яндекс.Музыки
+func apiV1AddPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		TrackURL string `json:"track_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	p, ok := playlistForRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	link, err := parseMusicLink(requestData.TrackURL)
+	if err != nil {
+		httpError(w, r, "http.invalid_track_url", http.StatusBadRequest)
+		return
+	}
+	api, ok := roomYandexAPI(w, r, 0)
+	if !ok {
+		return
+	}
+
+	var title string
+	var found []yandexTrack
+	if link.Kind == musicLinkTrack {
+		found, err = api.Tracks(r.Context(), []int{link.ID})
+	} else {
+		title, found, err = expandMusicLink(r.Context(), api, link)
+	}
+	if isYandexNotFound(err) {
+		httpError(w, r, "http.link_not_found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error expanding %s %d: %v", link.Kind, link.ID, err)
+		httpError(w, r, "http.track_info", http.StatusBadGateway)
+		return
+	}
+
+	// Тот же итог, что и при добавлении в комнату
+	res := &bulkAddResult{Title: title, Added: []bulkAddTrack{}, Skipped: []bulkAddTrack{}}
+	var tracks []savedPlaylistTrack
+	for _, t := range found {
+		if !t.IsAvailable() {
+			res.Skipped = append(res.Skipped, bulkAddTrack{TrackID: int(t.ID), Title: t.Title, Artist: t.ArtistName(), Reason: skipUnavailable})
+			continue
+		}
+		tracks = append(tracks, savedPlaylistTrack{TrackID: int(t.ID), Title: t.Title, Artist: t.ArtistName(), DurationMs: t.DurationMs})
+	}
+
+	skipped, err := addSavedPlaylistTracks(r.Context(), p.ID, tracks)
+	if err != nil {
+		log.Printf("Error adding playlist tracks: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	duplicate := make(map[int]bool, len(skipped))
+	for _, t := range skipped {
+		duplicate[t.TrackID] = true
+	}
+	for _, t := range tracks {
+		entry := bulkAddTrack{TrackID: t.TrackID, Title: t.Title, Artist: t.Artist}
+		if duplicate[t.TrackID] {
+			entry.Reason = skipDuplicate
+			res.Skipped = append(res.Skipped, entry)
+			continue
+		}
+		res.Added = append(res.Added, entry)
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// PATCH /api/v1/playlists/{id}/tracks/{track_id} {"position": 3}
+func apiV1MovePlaylistTrack(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Position *int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.Position == nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	trackID, err := strconv.Atoi(r.PathValue("track_id"))
+	if err != nil {
+		httpError(w, r, "http.invalid_track_id", http.StatusBadRequest)
+		return
+	}
+	p, ok := playlistForRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	found, err := moveSavedPlaylistTrack(r.Context(), p.ID, trackID, *requestData.Position)
+	if err != nil {
+		log.Printf("Error moving playlist track: %v", err)
+		httpError(w, r, "http.update_position", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		httpError(w, r, "http.track_not_found", http.StatusNotFound)
+		return
+	}
+
+	if p, err = getSavedPlaylist(r.Context(), p.ID); err != nil {
+		log.Printf("Error loading playlist %d: %v", p.ID, err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// DELETE /api/v1/playlists/{id}/tracks/{track_id}
+func apiV1DeletePlaylistTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, err := strconv.Atoi(r.PathValue("track_id"))
+	if err != nil {
+		httpError(w, r, "http.invalid_track_id", http.StatusBadRequest)
+		return
+	}
+	p, ok := playlistForRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), "DELETE FROM playlist_tracks WHERE playlist_id = ? AND track_id = ?", p.ID, trackID)
+	if err != nil {
+		log.Printf("Error deleting playlist track: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "http.track_not_found", http.StatusNotFound)
+		return
+	}
+	if _, err := db.ExecContext(r.Context(), "UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", p.ID); err != nil {
+		log.Printf("Error updating playlist %d: %v", p.ID, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/v1/playlists/{id}/queue {"room_code": "ABCDE"} - поставить
+// плейлист в очередь комнаты
+func apiV1QueuePlaylist(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		RoomCode string `json:"room_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	p, ok := playlistForRequest(w, r, false)
+	if !ok {
+		return
+	}
+	roomID, ok := authorizeRoomAction(w, r, requestData.RoomCode, roomActionAdd)
+	if !ok {
+		return
+	}
+
+	res, err := queueSavedPlaylist(r.Context(), p, roomID, requestAddedBy(r), requestSource(r))
+	if err != nil {
+		log.Printf("Error adding tracks to playlist: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Room %d: queued %d of %d tracks from playlist %d", roomID, len(res.Added), len(p.Tracks), p.ID)
+	writeJSON(w, http.StatusCreated, res)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// Зашифрованные значения отличаются от старых открытых по префиксу
+	encryptedSecretPrefix = "enc:v1:"
+	defaultSecretKeyFile  = "secret.key"
+	secretKeyLen          = 32 // AES-256
+)
+
+var (
+	secretAEAD    cipher.AEAD
+	secretMACKey  []byte // ключ подписи ссылок, выводится из основного ключа
+	secretKeyFrom string // откуда взят ключ - для страницы /debug
+	secretErr     error
+	secretOnce    sync.Once
+)
+
+// parseSecretKey принимает 32-байтный ключ в hex или base64
+func parseSecretKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == secretKeyLen {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == secretKeyLen {
+		return key, nil
+	}
+	return nil, fmt.Errorf("secret key must be %d bytes encoded as hex or base64", secretKeyLen)
+}
+
+// loadSecretKey берет ключ из SECRET_KEY, затем из файла SECRET_KEY_FILE
+// (по умолчанию secret.key). Если файла нет, ключ создается при первом запуске.
+func loadSecretKey() ([]byte, string, error) {
+	if value := os.Getenv("SECRET_KEY"); value != "" {
+		key, err := parseSecretKey(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid SECRET_KEY: %w", err)
+		}
+		return key, "env SECRET_KEY", nil
+	}
+
+	path := os.Getenv("SECRET_KEY_FILE")
+	if path == "" {
+		path = defaultSecretKeyFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := parseSecretKey(string(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid key file %s: %w", path, err)
+		}
+		return key, "file " + path, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+
+	key := make([]byte, secretKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	// O_EXCL - не затираем ключ, если файл появился одновременно
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create key file %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, "", fmt.Errorf("failed to write key file %s: %w", path, err)
+	}
+	log.Printf("Generated new secret key in %s - back it up together with the database", path)
+
+	return key, "file " + path, nil
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	secretOnce.Do(func() {
+		var key []byte
+		key, secretKeyFrom, secretErr = loadSecretKey()
+		if secretErr != nil {
+			return
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			secretErr = err
+			return
+		}
+		secretAEAD, secretErr = cipher.NewGCM(block)
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("musicdirect signed links"))
+		secretMACKey = mac.Sum(nil)
+	})
+	return secretAEAD, secretErr
+}
+
+// signValue подписывает значение ключом сервера. Назначение (purpose) входит
+// в подпись, поэтому подпись одной ссылки не подходит к другой.
+func signValue(purpose, value string) (string, error) {
+	if _, err := secretCipher(); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secretMACKey)
+	mac.Write([]byte(purpose + "\x00" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifySignature проверяет подпись signValue за постоянное время
+func verifySignature(purpose, value, sig string) bool {
+	expected, err := signValue(purpose, value)
+	return err == nil && hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// encryptSecret шифрует значение AES-256-GCM. Имя поля входит в
+// дополнительные данные, поэтому шифротекст нельзя перенести в другое поле.
+func encryptSecret(field, plaintext string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret расшифровывает значение. Строки без префикса остались
+// от старых версий и возвращаются как есть.
+func decryptSecret(field, stored string) (string, error) {
+	if !isEncryptedSecret(stored) {
+		return stored, nil
+	}
+
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedSecretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted %s", field)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		// Текст ошибки GCM ничего не говорит - подсказываем вероятную причину
+		return "", fmt.Errorf("failed to decrypt %s: wrong secret key?", field)
+	}
+	return string(plaintext), nil
+}
+
+func isEncryptedSecret(stored string) bool {
+	return strings.HasPrefix(stored, encryptedSecretPrefix)
+}
+
+// migrateSecrets шифрует токены, сохраненные старыми версиями открытым текстом
+func migrateSecrets(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, access_token FROM settings")
+	if err != nil {
+		return err
+	}
+
+	plain := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return err
+		}
+		if !isEncryptedSecret(token) {
+			plain[id] = token
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for id, token := range plain {
+		encrypted, err := encryptSecret("settings.access_token", token)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("UPDATE settings SET access_token = ? WHERE id = ?", encrypted, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Encrypted %d plaintext access token(s) in settings", len(plain))
+	return nil
+}
+
+// redactingWriter вырезает из логов известные секреты: токены могут
+// попасть в текст ошибки, например в URL запроса к Bot API
+type redactingWriter struct {
+	mu      sync.RWMutex
+	out     io.Writer
+	secrets []string
+}
+
+var logRedactor = &redactingWriter{out: os.Stderr}
+
+// registerLogSecret добавляет значение, которое никогда не должно попасть в лог
+func registerLogSecret(secret string) {
+	if len(secret) < 8 {
+		return
+	}
+	logRedactor.mu.Lock()
+	defer logRedactor.mu.Unlock()
+	for _, s := range logRedactor.secrets {
+		if s == secret {
+			return
+		}
+	}
+	logRedactor.secrets = append(logRedactor.secrets, secret)
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	line := string(p)
+	for _, secret := range w.secrets {
+		line = strings.ReplaceAll(line, secret, "[REDACTED]")
+	}
+	if _, err := io.WriteString(w.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
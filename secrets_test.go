@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// Ключ задается до первого обращения к secretCipher, чтобы тесты не
+// создавали secret.key в каталоге пакета
+func TestMain(m *testing.M) {
+	os.Setenv("SECRET_KEY", strings.Repeat("ab", secretKeyLen))
+	os.Exit(m.Run())
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"empty", ""},
+		{"token", "y0_AgAAAAAAbcdef-123456"},
+		{"unicode", "токен с пробелами и ♫"},
+		{"long", strings.Repeat("x", 4096)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, err := encryptSecret("access_token", tt.plaintext)
+			if err != nil {
+				t.Fatalf("encryptSecret: %v", err)
+			}
+			if !isEncryptedSecret(stored) {
+				t.Fatalf("encrypted value %q has no %q prefix", stored, encryptedSecretPrefix)
+			}
+			if tt.plaintext != "" && strings.Contains(stored, tt.plaintext) {
+				t.Fatalf("encrypted value contains the plaintext")
+			}
+
+			got, err := decryptSecret("access_token", stored)
+			if err != nil {
+				t.Fatalf("decryptSecret: %v", err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("decryptSecret = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptSecretNonceIsRandom(t *testing.T) {
+	a, err := encryptSecret("access_token", "same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := encryptSecret("access_token", "same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("two encryptions of the same value are equal")
+	}
+}
+
+func TestDecryptSecretRejects(t *testing.T) {
+	stored, err := encryptSecret("access_token", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.TrimPrefix(stored, encryptedSecretPrefix)
+	flipped := []byte(body)
+	if flipped[10] == 'A' {
+		flipped[10] = 'B'
+	} else {
+		flipped[10] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		field  string
+		stored string
+	}{
+		{"other field", "telegram_token", stored},
+		{"tampered", "access_token", encryptedSecretPrefix + string(flipped)},
+		{"not base64", "access_token", encryptedSecretPrefix + "%%%"},
+		{"too short", "access_token", encryptedSecretPrefix + "AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decryptSecret(tt.field, tt.stored); err == nil {
+				t.Errorf("decryptSecret(%q) = %q, want error", tt.stored, got)
+			}
+		})
+	}
+}
+
+// Значения без префикса остались от старых версий и возвращаются как есть
+func TestDecryptSecretLegacyPlaintext(t *testing.T) {
+	got, err := decryptSecret("access_token", "y0_plain")
+	if err != nil || got != "y0_plain" {
+		t.Errorf("decryptSecret(plain) = %q, %v; want the value unchanged", got, err)
+	}
+}
+
+func TestParseSecretKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"hex", strings.Repeat("0f", secretKeyLen), true},
+		{"hex with newline", strings.Repeat("0f", secretKeyLen) + "\n", true},
+		{"base64", "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", true},
+		{"short hex", strings.Repeat("0f", secretKeyLen-1), false},
+		{"garbage", "not a key", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseSecretKey(tt.input)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseSecretKey(%q) error = %v, want ok=%v", tt.input, err, tt.ok)
+			}
+			if tt.ok && len(key) != secretKeyLen {
+				t.Errorf("key length = %d, want %d", len(key), secretKeyLen)
+			}
+		})
+	}
+}
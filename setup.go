@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Шаги первоначальной настройки. Шаг определяется по содержимому базы,
+// поэтому мастер можно прервать и продолжить после перезапуска.
+const (
+	setupStepAdmin = "admin" // создать первого администратора
+	setupStepToken = "token" // подключить аккаунт Яндекс.Музыки
+	setupStepDone  = ""
+)
+
+// setupComplete переключает сервер в обычный режим без перезапуска
+var setupComplete atomic.Bool
+
+func currentSetupStep(db *sql.DB) (string, error) {
+	users, err := countUsers(db)
+	if err != nil {
+		return "", err
+	}
+	if users == 0 {
+		return setupStepAdmin, nil
+	}
+
+	var accounts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM yandex_accounts").Scan(&accounts); err != nil {
+		return "", err
+	}
+	if accounts == 0 {
+		return setupStepToken, nil
+	}
+	return setupStepDone, nil
+}
+
+// refreshSetupState перечитывает шаг настройки из базы
+func refreshSetupState(db *sql.DB) (string, error) {
+	step, err := currentSetupStep(db)
+	if err != nil {
+		return "", err
+	}
+	setupComplete.Store(step == setupStepDone)
+	return step, nil
+}
+
+// requireSetup, пока настройка не завершена, отправляет все запросы на /setup.
+// Вход остается доступен: шаг с токеном выполняет вошедший администратор.
+func requireSetup(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if setupComplete.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/setup", "/login", "/lang", "/api/openapi.json", "/api/docs":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/ws" {
+			httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, "/setup", http.StatusFound)
+	})
+}
+
+// Мастер первоначальной настройки: администратор, затем токен Яндекс.Музыки
+func setupHandler(w http.ResponseWriter, r *http.Request) {
+	step, err := refreshSetupState(db)
+	if err != nil {
+		log.Printf("Error checking setup state: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if step == setupStepDone {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	// Пока нет пользователей, /setup открыт всем. Токен подключает
+	// только созданный на первом шаге администратор.
+	if step == setupStepToken {
+		user, err := sessionUser(r)
+		if err != nil {
+			log.Printf("Error loading session: %v", err)
+			httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			http.Redirect(w, r, "/login?next=/setup", http.StatusFound)
+			return
+		}
+	}
+
+	data := struct {
+		Step  string
+		Error string
+	}{
+		Step: step,
+	}
+
+	if r.Method != http.MethodPost {
+		loadTemplate(w, r, "setup.html", data)
+		return
+	}
+
+	switch step {
+	case setupStepAdmin:
+		id, err := createUser(db, r.FormValue("username"), r.FormValue("password"), true)
+		if err != nil {
+			log.Printf("Error creating first user: %v", err)
+			data.Error = tr(r, "page.user_create_error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			loadTemplate(w, r, "setup.html", data)
+			return
+		}
+		log.Printf("Created first admin user %q", r.FormValue("username"))
+
+		// Сразу входим, чтобы следующий шаг был доступен только администратору
+		token, expires, err := createSession(db, int(id))
+		if err != nil {
+			log.Printf("Error creating session: %v", err)
+			httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, r, token, expires)
+
+	case setupStepToken:
+		// ID пользователя берется из статуса аккаунта
+		token := r.FormValue("token")
+		status, errKey := checkYandexToken(r.Context(), 0, token)
+		if errKey != "" {
+			data.Error = tr(r, errKey)
+			w.WriteHeader(http.StatusBadRequest)
+			loadTemplate(w, r, "setup.html", data)
+			return
+		}
+
+		label := strings.TrimSpace(r.FormValue("label"))
+		if label == "" {
+			label = status.Name
+		}
+		if label == "" {
+			label = "default"
+		}
+		if _, err := addYandexAccount(db, label, status.UID, token, true); err != nil {
+			log.Printf("Error saving Yandex account: %v", err)
+			httpError(w, r, "http.save_settings", http.StatusInternalServerError)
+			return
+		}
+		if err := loadYandexAccounts(db); err != nil {
+			log.Printf("Error loading Yandex accounts: %v", err)
+			httpError(w, r, "http.save_settings", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Linked Yandex Music account %q (uid %d)", label, status.UID)
+	}
+
+	if _, err := refreshSetupState(db); err != nil {
+		log.Printf("Error checking setup state: %v", err)
+	}
+	http.Redirect(w, r, "/setup", http.StatusFound)
+}
@@ -317,6 +317,17 @@ function playTrack(index) {
   document.documentElement.style.setProperty('--accent-color', `hsl(${hue}, 84%, 60%)`);
 
   updatePlayPauseIcon(true);
+  sendNowPlaying(track);
+}
+
+// Сообщаем серверу о смене трека, чтобы бот мог анонсировать его в Telegram
+function sendNowPlaying(track) {
+  if (typeof socket === 'undefined' || socket.readyState !== WebSocket.OPEN) return;
+  socket.send(JSON.stringify({
+    type: 'nowPlaying',
+    room_code: getRoomCode(),
+    track_id: track.track_id
+  }));
 }
 
 function playNext() {
@@ -420,6 +431,11 @@ document.getElementById('add-track-btn').addEventListener('click', async () => {
         body: JSON.stringify({ track_url: trackUrl, room_code: getRoomCode() }),
       });
       if (response.ok) {
+        // Для альбома, исполнителя или плейлиста сервер возвращает итог
+        if ((response.headers.get('Content-Type') || '').includes('application/json')) {
+          const summary = await response.json();
+          showNotification(summary.message);
+        }
         loadTrackList();
         const modalElement = document.getElementById('addTrackModal');
         const modal = bootstrap.Modal.getInstance(modalElement);
@@ -433,6 +449,142 @@ document.getElementById('add-track-btn').addEventListener('click', async () => {
   }
 });
 
+// Сохранение плейлиста комнаты в Яндекс.Музыку
+document.getElementById('export-btn')?.addEventListener('click', async (event) => {
+  const button = event.currentTarget;
+  button.disabled = true;
+  try {
+    const response = await fetch(`/api/v1/rooms/${getRoomCode()}/export`, { method: 'POST' });
+    const result = await response.json();
+    if (response.ok) {
+      showNotification(button.dataset.done
+        .replace('{exported}', result.exported)
+        .replace('{failed}', result.failed.length));
+    } else {
+      showNotification(result.error.message);
+    }
+  } catch (error) {
+    console.error('Ошибка сохранения в Яндекс.Музыку:', error);
+  } finally {
+    button.disabled = false;
+  }
+});
+
+// Импорт плейлиста из файла: загрузка, проверка сомнительных совпадений и добавление
+let pendingUpload = null;
+
+function fillTemplate(template, values) {
+  return Object.entries(values).reduce((text, [key, value]) => text.replace(`{${key}}`, value), template);
+}
+
+function renderUploadReview(upload) {
+  const modal = document.getElementById('uploadModal');
+  const review = document.getElementById('upload-review');
+  review.replaceChildren();
+
+  const summary = document.createElement('p');
+  summary.textContent = fillTemplate(modal.dataset.summary, upload.summary);
+  review.appendChild(summary);
+
+  // Для сомнительных записей - выбор из кандидатов, по умолчанию лучший
+  upload.entries.filter((entry) => entry.status === 'ambiguous').forEach((entry) => {
+    const label = document.createElement('label');
+    label.className = 'form-label mt-2';
+    label.textContent = [entry.artist, entry.title].filter(Boolean).join(' - ');
+    const select = document.createElement('select');
+    select.className = 'form-select';
+    select.dataset.position = entry.position;
+    entry.candidates.forEach((candidate) => {
+      const option = document.createElement('option');
+      option.value = candidate.track_id;
+      option.textContent = `${candidate.artist} - ${candidate.title} (${Math.round(candidate.confidence * 100)}%)`;
+      select.appendChild(option);
+    });
+    const skip = document.createElement('option');
+    skip.value = 0;
+    skip.textContent = modal.dataset.skip;
+    select.appendChild(skip);
+    review.append(label, select);
+  });
+
+  const missing = upload.entries.filter((entry) => entry.status === 'not_found');
+  if (missing.length > 0) {
+    const list = document.createElement('ul');
+    list.className = 'text-muted mt-3';
+    list.textContent = modal.dataset.notFound;
+    missing.forEach((entry) => {
+      const item = document.createElement('li');
+      item.textContent = [entry.artist, entry.title].filter(Boolean).join(' - ') || entry.location;
+      list.appendChild(item);
+    });
+    review.appendChild(list);
+  }
+}
+
+document.getElementById('upload-file')?.addEventListener('change', async (event) => {
+  const file = event.target.files[0];
+  if (!file) {
+    return;
+  }
+  const form = new FormData();
+  form.append('file', file);
+  document.getElementById('upload-confirm-btn').disabled = true;
+  try {
+    const response = await fetch(`/api/v1/rooms/${getRoomCode()}/uploads`, { method: 'POST', body: form });
+    const result = await response.json();
+    if (!response.ok) {
+      showNotification(result.error.message);
+      return;
+    }
+    pendingUpload = result;
+    renderUploadReview(result);
+    document.getElementById('upload-confirm-btn').disabled = false;
+  } catch (error) {
+    console.error('Ошибка загрузки файла плейлиста:', error);
+  }
+});
+
+document.getElementById('upload-confirm-btn')?.addEventListener('click', async (event) => {
+  if (!pendingUpload) {
+    return;
+  }
+  const choices = {};
+  document.querySelectorAll('#upload-review select').forEach((select) => {
+    choices[select.dataset.position] = Number(select.value);
+  });
+  event.currentTarget.disabled = true;
+  try {
+    const response = await fetch(`/api/v1/rooms/${getRoomCode()}/uploads/${pendingUpload.id}/confirm`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ choices }),
+    });
+    const result = await response.json();
+    if (!response.ok) {
+      showNotification(result.error.message);
+      return;
+    }
+    pendingUpload = null;
+    const modalElement = document.getElementById('uploadModal');
+    showNotification(fillTemplate(modalElement.dataset.done, { added: result.added.length, skipped: result.skipped.length }));
+    bootstrap.Modal.getInstance(modalElement).hide();
+    loadTrackList();
+  } catch (error) {
+    console.error('Ошибка импорта плейлиста:', error);
+  }
+});
+
+// Закрыли окно без добавления - загруженный файл больше не нужен
+document.getElementById('uploadModal')?.addEventListener('hidden.bs.modal', () => {
+  if (pendingUpload) {
+    fetch(`/api/v1/rooms/${getRoomCode()}/uploads/${pendingUpload.id}`, { method: 'DELETE' });
+    pendingUpload = null;
+  }
+  document.getElementById('upload-file').value = '';
+  document.getElementById('upload-review').replaceChildren();
+  document.getElementById('upload-confirm-btn').disabled = true;
+});
+
 loadTrackList();
 // Проверка обновлений плейлиста каждые 5 секунд
 setInterval(checkForPlaylistUpdates, 5000);
@@ -566,6 +718,39 @@ function joinRoom(roomCode) {
 }
 
 
+// Авторизация, если плеер открыт как Telegram Mini App
+async function authTelegramWebApp() {
+  const webApp = window.Telegram?.WebApp;
+  if (!webApp || !webApp.initData) return;
+
+  webApp.ready();
+  try {
+    const response = await fetch('/api/telegram/auth', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ init_data: webApp.initData }),
+    });
+    if (!response.ok) {
+      throw new Error(response.status === 403 ? 'Нет доступа к боту' : 'Ошибка авторизации');
+    }
+    const data = await response.json();
+
+    // Если комната еще не выбрана, берем последнюю из комнат пользователя
+    if (!getRoomCode() && data.rooms.length > 0) {
+      setRoomCode(data.rooms[0]);
+      if (oopsElement) {
+        oopsElement.style.display = 'none';
+      }
+      loadTrackList();
+    }
+  } catch (error) {
+    console.error('Telegram auth error:', error);
+    showNotification('Ошибка авторизации Telegram: ' + error.message, 'error');
+  }
+}
+
+authTelegramWebApp();
+
 document.querySelector('.create')?.addEventListener('click', createRoom);
 
 document.querySelector('.join')?.addEventListener('click', () => {
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Сколько живет initData, выданный Telegram при открытии Mini App
+	webAppInitDataMaxAge = 24 * time.Hour
+	telegramSessionTTL   = 7 * 24 * time.Hour
+	telegramSessionName  = "tg_session"
+	// Заголовок для клиентов, которым недоступны cookie (Telegram Web в iframe)
+	telegramSessionHeader = "X-Telegram-Session"
+)
+
+// webAppUser - пользователь Telegram из поля user в initData
+type webAppUser struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+type webAppInitData struct {
+	User       webAppUser
+	AuthDate   time.Time
+	StartParam string
+}
+
+// validateInitData проверяет подпись initData по алгоритму Telegram:
+// secret = HMAC_SHA256("WebAppData", bot_token),
+// hash = hex(HMAC_SHA256(secret, data_check_string)).
+func validateInitData(initData, botToken string, maxAge time.Duration) (*webAppInitData, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid init data: %w", err)
+	}
+
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, fmt.Errorf("init data is not signed")
+	}
+
+	// data_check_string - отсортированные пары key=value без hash, через \n
+	var pairs []string
+	for key := range values {
+		if key == "hash" {
+			continue
+		}
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+	dataCheckString := strings.Join(pairs, "\n")
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(dataCheckString))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(hash)) {
+		return nil, fmt.Errorf("init data signature mismatch")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth_date")
+	}
+
+	data := &webAppInitData{
+		AuthDate:   time.Unix(authDate, 0),
+		StartParam: values.Get("start_param"),
+	}
+	if time.Since(data.AuthDate) > maxAge {
+		return nil, fmt.Errorf("init data expired")
+	}
+
+	if err := json.Unmarshal([]byte(values.Get("user")), &data.User); err != nil {
+		return nil, fmt.Errorf("invalid user in init data: %w", err)
+	}
+	if data.User.ID == 0 {
+		return nil, fmt.Errorf("init data has no user")
+	}
+
+	return data, nil
+}
+
+// Как и для веб-сессий, в базе хранится только хэш токена. Таблица старых
+// версий с токенами открытым текстом переносится в новую.
+func createTelegramSessionTables(tx *sql.Tx) error {
+	legacy, err := tableHasColumn(tx, "telegram_sessions", "token")
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if _, err := tx.Exec("ALTER TABLE telegram_sessions RENAME TO telegram_sessions_legacy"); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS telegram_sessions (
+		token_hash TEXT PRIMARY KEY,
+		telegram_user_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil || !legacy {
+		return err
+	}
+	return migrateTelegramSessions(tx)
+}
+
+// migrateTelegramSessions хэширует токены действующих сессий из старой
+// таблицы; истекшие сессии не переносятся
+func migrateTelegramSessions(tx *sql.Tx) error {
+	type session struct {
+		token            string
+		userID           int64
+		created, expires time.Time
+	}
+	rows, err := tx.Query("SELECT token, telegram_user_id, created_at, expires_at FROM telegram_sessions_legacy WHERE expires_at > ?", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	var sessions []session
+	for rows.Next() {
+		var s session
+		if err := rows.Scan(&s.token, &s.userID, &s.created, &s.expires); err != nil {
+			rows.Close()
+			return err
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		_, err := tx.Exec("INSERT INTO telegram_sessions (token_hash, telegram_user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+			hashSessionToken(s.token), s.userID, s.created, s.expires)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DROP TABLE telegram_sessions_legacy")
+	return err
+}
+
+func generateSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func createTelegramSession(db *sql.DB, telegramUserID int64) (string, time.Time, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expires := time.Now().Add(telegramSessionTTL).UTC()
+	_, err = db.Exec("INSERT INTO telegram_sessions (token_hash, telegram_user_id, expires_at) VALUES (?, ?, ?)",
+		hashSessionToken(token), telegramUserID, expires)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expires, nil
+}
+
+// telegramSessionUser возвращает ID пользователя Telegram, если запрос пришел
+// из Mini App с действующей сессией
+func telegramSessionUser(r *http.Request) (int64, bool) {
+	token := r.Header.Get(telegramSessionHeader)
+	if token == "" {
+		cookie, err := r.Cookie(telegramSessionName)
+		if err != nil {
+			return 0, false
+		}
+		token = cookie.Value
+	}
+
+	var userID int64
+	var expires time.Time
+	err := db.QueryRow("SELECT telegram_user_id, expires_at FROM telegram_sessions WHERE token_hash = ?", hashSessionToken(token)).
+		Scan(&userID, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error loading telegram session: %v", err)
+		}
+		return 0, false
+	}
+	if time.Now().After(expires) {
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// requireBotRole пропускает запросы из Mini App только с нужной ролью бота.
+// Запросы без сессии Telegram обрабатываются как раньше.
+func requireBotRole(min botRole, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := telegramSessionUser(r); ok {
+			role, err := resolveRole(db, userID, 0)
+			if err != nil {
+				log.Printf("Error resolving bot role: %v", err)
+				httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+				return
+			}
+			if role < min {
+				httpError(w, r, "http.forbidden", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), botRoleContextKey, role))
+		}
+		next(w, r)
+	}
+}
+
+// botRoleFromContext - роль бота, которую для этого запроса проверил requireBotRole
+func botRoleFromContext(r *http.Request) (botRole, bool) {
+	role, ok := r.Context().Value(botRoleContextKey).(botRole)
+	return role, ok
+}
+
+// getMemberRoomCodes возвращает коды комнат пользователя, последние - первыми.
+// Комнаты, из которых его исключили, не показываются.
+func getMemberRoomCodes(db *sql.DB, telegramUserID int64) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT rooms.code FROM room_roles
+        JOIN rooms ON rooms.id = room_roles.room_id
+        WHERE room_roles.member_type = ? AND room_roles.member_id = ? AND room_roles.role != ?
+        ORDER BY room_roles.joined_at DESC`, memberTelegram, telegramUserID, roomRoleKicked.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+// Точка входа Mini App: тот же плеер, но без входа по паролю -
+// пользователь авторизуется через initData уже на странице
+func miniAppHandler(w http.ResponseWriter, r *http.Request) {
+	loadTemplate(w, r, "playlist.html", nil)
+}
+
+// Авторизация веб-плеера, открытого как Telegram Mini App
+func telegramAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if botCfg == nil || botCfg.TelegramToken == "" {
+		httpError(w, r, "http.bot_not_configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var requestData struct {
+		InitData string `json:"init_data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	initData, err := validateInitData(requestData.InitData, botCfg.TelegramToken, webAppInitDataMaxAge)
+	if err != nil {
+		log.Printf("Rejected Mini App init data: %v", err)
+		httpError(w, r, "http.invalid_init_data", http.StatusUnauthorized)
+		return
+	}
+
+	role, err := resolveRole(db, initData.User.ID, 0)
+	if err != nil {
+		log.Printf("Error resolving bot role: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	if role == roleNone {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	token, expires, err := createTelegramSession(db, initData.User.ID)
+	if err != nil {
+		log.Printf("Error creating telegram session: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	// Mini App можно открыть сразу в комнате: t.me/<bot>/<app>?startapp=<код>
+	if initData.StartParam != "" {
+		var roomID int
+		err := db.QueryRow("SELECT id FROM rooms WHERE code = ?", strings.ToUpper(initData.StartParam)).Scan(&roomID)
+		if err == nil {
+			if _, err := joinRoomAsGuest(db, roomID, roomMember{Type: memberTelegram, ID: initData.User.ID}); err != nil {
+				log.Printf("Error saving room membership: %v", err)
+			}
+		} else if err != sql.ErrNoRows {
+			log.Printf("Error querying room: %v", err)
+		}
+	}
+
+	rooms, err := getMemberRoomCodes(db, initData.User.ID)
+	if err != nil {
+		log.Printf("Error fetching user rooms: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     telegramSessionName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	response := struct {
+		Token     string   `json:"token"`
+		UserID    int64    `json:"user_id"`
+		FirstName string   `json:"first_name"`
+		Role      string   `json:"role"`
+		Rooms     []string `json:"rooms"`
+	}{
+		Token:     token,
+		UserID:    initData.User.ID,
+		FirstName: initData.User.FirstName,
+		Role:      role.String(),
+		Rooms:     rooms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// handleJoinCommand добавляет пользователя в комнату - она появится и в Mini App
+func handleJoinCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	code := strings.ToUpper(strings.TrimSpace(message.CommandArguments()))
+	if code == "" {
+		return T(lang, "bot.join_usage")
+	}
+	if message.From == nil {
+		return T(lang, "bot.unknown_user")
+	}
+
+	var roomID int
+	err := cfg.Database.QueryRow("SELECT id FROM rooms WHERE code = ?", code).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return T(lang, "bot.room_not_found", code)
+	}
+	if err != nil {
+		return T(lang, "bot.room_check_error", err)
+	}
+
+	role, err := joinRoomAsGuest(cfg.Database, roomID, roomMember{Type: memberTelegram, ID: message.From.ID})
+	if err != nil {
+		return T(lang, "bot.join_error", err)
+	}
+	if role == roomRoleKicked {
+		return T(lang, "bot.room_kicked", code)
+	}
+
+	return T(lang, "bot.joined", code)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer = "MusicDirect"
+	totpPeriod = 30
+	// Допускаем расхождение часов на один период в каждую сторону
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// Сессия между вводом пароля и вводом кода
+	mfaPendingTTL  = 5 * time.Minute
+	maxMFAAttempts = 5
+
+	settingRequire2FA = "require_2fa"
+)
+
+var errInvalidCode = fmt.Errorf("invalid code")
+
+func createTOTPTables(tx *sql.Tx) error {
+	// last_step - последний принятый период, чтобы один код нельзя было ввести дважды
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+		enabled INTEGER DEFAULT 0,
+		last_step INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS auth_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// pending = 1 - пароль введен, но второй фактор еще не подтвержден
+	if err := addColumnIfNotExists(tx, "sessions", "pending", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumnIfNotExists(tx, "sessions", "attempts", "INTEGER DEFAULT 0")
+}
+
+func getAuthSetting(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM auth_settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func setAuthSetting(db *sql.DB, key, value string) error {
+	_, err := db.Exec(`
+        INSERT INTO auth_settings (key, value) VALUES (?, ?)
+        ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		key, value)
+	return err
+}
+
+// require2FA - включил ли администратор обязательную двухфакторную аутентификацию
+func require2FA(db *sql.DB) (bool, error) {
+	value, err := getAuthSetting(db, settingRequire2FA)
+	return value == "1", err
+}
+
+func totpEnabled(db *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT enabled FROM user_totp WHERE user_id = ?", userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// beginTOTPEnrollment создает новый секрет. Он начинает действовать только
+// после подтверждения кодом из приложения.
+func beginTOTPEnrollment(db *sql.DB, user *User) (*otp.Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO user_totp (user_id, secret, enabled, last_step) VALUES (?, ?, 0, 0)
+        ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, enabled = 0, last_step = 0,
+            created_at = CURRENT_TIMESTAMP`,
+		user.ID, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// matchTOTPStep ищет период, для которого код совпадает
+func matchTOTPStep(secret, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		want, err := totp.GenerateCode(secret, time.Unix(step*totpPeriod, 0))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// confirmTOTPEnrollment включает 2FA и возвращает новые коды восстановления
+func confirmTOTPEnrollment(db *sql.DB, userID int, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	err := db.QueryRow("SELECT secret, enabled FROM user_totp WHERE user_id = ?", userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows || (err == nil && enabled) {
+		return nil, errInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := matchTOTPStep(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, errInvalidCode
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE user_totp SET enabled = 1, last_step = ? WHERE user_id = ?", step, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// verifyTOTP проверяет код из приложения и запоминает его период
+func verifyTOTP(db *sql.DB, userID int, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := db.QueryRow("SELECT secret, last_step FROM user_totp WHERE user_id = ? AND enabled = 1", userID).
+		Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := matchTOTPStep(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	// Условие на last_step защищает от одновременного ввода одного кода
+	result, err := db.Exec("UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ReplaceAll(code, "-", "")
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCode возвращает код вида abcde-fghij
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	return s[:5] + "-" + s[5:10], nil
+}
+
+// replaceRecoveryCodes удаляет старые коды восстановления и создает новые.
+// В базе хранятся только хэши, сами коды показываются пользователю один раз.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func regenerateRecoveryCodes(db *sql.DB, userID int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// useRecoveryCode принимает код восстановления и сразу помечает его использованным
+func useRecoveryCode(db *sql.DB, userID int, code string) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func countRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+// verifySecondFactor принимает как код из приложения, так и код восстановления
+func verifySecondFactor(db *sql.DB, userID int, code string) (bool, error) {
+	code = normalizeCode(code)
+	if code == "" {
+		return false, nil
+	}
+	if _, err := strconv.Atoi(code); err == nil && len(code) == 6 {
+		return verifyTOTP(db, userID, code)
+	}
+	return useRecoveryCode(db, userID, code)
+}
+
+func disableTOTP(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// totpQRCode возвращает QR-код с otpauth-ссылкой в виде data URI
+func totpQRCode(key *otp.Key) (template.URL, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// pendingSessionUser возвращает пользователя, который ввел пароль и ждет проверки кода
+func pendingSessionUser(r *http.Request) (*User, string, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, "", nil
+	}
+
+	tokenHash := hashSessionToken(cookie.Value)
+	var userID int
+	var expires time.Time
+	err = db.QueryRow("SELECT user_id, expires_at FROM sessions WHERE token_hash = ? AND pending = 1", tokenHash).
+		Scan(&userID, &expires)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if time.Now().After(expires) {
+		return nil, "", nil
+	}
+
+	user, err := getUserByID(db, userID)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	return user, tokenHash, err
+}
+
+// recordFailedMFAAttempt считает неудачные попытки и после лимита удаляет сессию
+func recordFailedMFAAttempt(db *sql.DB, tokenHash string) (bool, error) {
+	if _, err := db.Exec("UPDATE sessions SET attempts = attempts + 1 WHERE token_hash = ?", tokenHash); err != nil {
+		return false, err
+	}
+
+	var attempts int
+	if err := db.QueryRow("SELECT attempts FROM sessions WHERE token_hash = ?", tokenHash).Scan(&attempts); err != nil {
+		return false, err
+	}
+	if attempts < maxMFAAttempts {
+		return false, nil
+	}
+
+	_, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return true, err
+}
+
+// requireTOTP не пускает к настройкам и управлению комнатами пользователей
+// без 2FA, если администратор сделал ее обязательной
+func requireTOTP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if user == nil {
+			// Без входа по паролю пускаем только сессии Telegram с ролью
+			// не ниже DJ: слушатель в Mini App ничем не управляет
+			if role, ok := botRoleFromContext(r); !ok || role < roleDJ {
+				httpError(w, r, "http.forbidden", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		required, err := require2FA(db)
+		if err != nil {
+			log.Printf("Error reading 2FA settings: %v", err)
+			httpError(w, r, "http.database_error", http.StatusInternalServerError)
+			return
+		}
+		if !required {
+			next(w, r)
+			return
+		}
+
+		enabled, err := totpEnabled(db, user.ID)
+		if err != nil {
+			log.Printf("Error reading 2FA status: %v", err)
+			httpError(w, r, "http.database_error", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			next(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.Method != http.MethodGet {
+			httpError(w, r, "http.totp_required", http.StatusForbidden)
+			return
+		}
+		http.Redirect(w, r, "/account/2fa", http.StatusFound)
+	}
+}
+
+// Второй шаг входа: код из приложения или код восстановления
+func loginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, tokenHash, err := pendingSessionUser(r)
+	if err != nil {
+		log.Printf("Error loading pending session: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	data := struct {
+		Next  string
+		Error string
+	}{
+		Next: safeRedirect(r.FormValue("next")),
+	}
+
+	if r.Method != http.MethodPost {
+		loadTemplate(w, r, "login_2fa.html", data)
+		return
+	}
+
+	ok, err := verifySecondFactor(db, user.ID, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		log.Printf("Failed 2FA attempt for %q from %s", user.Username, r.RemoteAddr)
+		locked, err := recordFailedMFAAttempt(db, tokenHash)
+		if err != nil {
+			log.Printf("Error recording failed 2FA attempt: %v", err)
+		}
+		if locked {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(data.Next), http.StatusFound)
+			return
+		}
+		data.Error = tr(r, "page.totp_invalid")
+		w.WriteHeader(http.StatusUnauthorized)
+		loadTemplate(w, r, "login_2fa.html", data)
+		return
+	}
+
+	// Промежуточную сессию заменяем полноценной с новым токеном
+	if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash); err != nil {
+		log.Printf("Error deleting pending session: %v", err)
+	}
+	token, expires, err := createSession(db, user.ID)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, token, expires)
+
+	http.Redirect(w, r, data.Next, http.StatusFound)
+}
+
+// Подключение и отключение двухфакторной аутентификации
+func accountTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		httpError(w, r, "http.forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := struct {
+		Enabled        bool
+		Required       bool
+		QRCode         template.URL
+		OTPAuthURL     template.URL
+		Secret         string
+		RecoveryCodes  []string
+		RemainingCodes int
+		Error          string
+	}{}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "begin":
+			key, err := beginTOTPEnrollment(db, user)
+			if err != nil {
+				log.Printf("Error starting 2FA enrollment: %v", err)
+				httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+				return
+			}
+			data.QRCode, err = totpQRCode(key)
+			if err != nil {
+				log.Printf("Error rendering QR code: %v", err)
+			}
+			data.OTPAuthURL = template.URL(key.URL())
+			data.Secret = key.Secret()
+
+		case "confirm":
+			codes, err := confirmTOTPEnrollment(db, user.ID, r.FormValue("code"))
+			if err == errInvalidCode {
+				data.Error = tr(r, "page.totp_invalid")
+				break
+			}
+			if err != nil {
+				log.Printf("Error confirming 2FA: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("User %q enabled 2FA", user.Username)
+			data.RecoveryCodes = codes
+
+		case "recovery", "disable":
+			ok, err := verifySecondFactor(db, user.ID, r.FormValue("code"))
+			if err != nil {
+				log.Printf("Error verifying second factor: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				data.Error = tr(r, "page.totp_invalid")
+				break
+			}
+
+			if r.FormValue("action") == "disable" {
+				if err := disableTOTP(db, user.ID); err != nil {
+					log.Printf("Error disabling 2FA: %v", err)
+					httpError(w, r, "http.database_error", http.StatusInternalServerError)
+					return
+				}
+				log.Printf("User %q disabled 2FA", user.Username)
+				break
+			}
+
+			data.RecoveryCodes, err = regenerateRecoveryCodes(db, user.ID)
+			if err != nil {
+				log.Printf("Error regenerating recovery codes: %v", err)
+				httpError(w, r, "http.database_error", http.StatusInternalServerError)
+				return
+			}
+
+		default:
+			httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	if data.Enabled, err = totpEnabled(db, user.ID); err == nil {
+		if data.Required, err = require2FA(db); err == nil {
+			data.RemainingCodes, err = countRecoveryCodes(db, user.ID)
+		}
+	}
+	if err != nil {
+		log.Printf("Error reading 2FA status: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	loadTemplate(w, r, "account_2fa.html", data)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB создает пустую базу со всеми таблицами во временном каталоге
+// и подставляет ее в глобальную db
+func openTestDB(t *testing.T) {
+	t.Helper()
+	oldConfig, oldDB := appConfig, db
+	appConfig = defaultServerConfig()
+	appConfig.DBPath = filepath.Join(t.TempDir(), "test.db")
+	if err := createTableIfNotExists(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	db, err = openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		appConfig, db = oldConfig, oldDB
+	})
+}
+
+// Сессия Mini App проходит requireTOTP только с ролью бота не ниже DJ
+func TestRequireTOTPMiniAppRoles(t *testing.T) {
+	openTestDB(t)
+
+	sessions := map[string]string{}
+	for name, role := range map[string]botRole{"listener": roleListener, "dj": roleDJ, "owner": roleOwner} {
+		userID := int64(100 + role)
+		if err := grantAccess(db, accessSubjectUser, userID, role, 0); err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := createTelegramSession(db, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[name] = token
+	}
+	// Сессия есть, а роль в боте уже отозвана
+	revoked, _, err := createTelegramSession(db, 999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions["revoked"] = revoked
+
+	handler := requireLogin(requireTOTP(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		session string
+		want    int
+	}{
+		{"listener", http.StatusForbidden},
+		{"dj", http.StatusOK},
+		{"owner", http.StatusOK},
+		{"revoked", http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/room/account", nil)
+		if tt.session != "" {
+			r.Header.Set(telegramSessionHeader, sessions[tt.session])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("session %q: status %d, want %d", tt.session, rec.Code, tt.want)
+		}
+	}
+}
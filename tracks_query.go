@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.botr.me/yamusic"
+)
+
+// Источники, из которых трек попал в плейлист
+const (
+	trackSourceWeb      = "web"
+	trackSourceAPI      = "api"
+	trackSourceTelegram = "telegram"
+	trackSourceSync     = "sync" // синхронизация с плейлистом Яндекс.Музыки
+)
+
+const (
+	defaultTrackPageSize = 50
+	maxTrackPageSize     = 500
+)
+
+// Поля для фильтров и сортировки: кто и откуда добавил трек, а также
+// название и исполнитель, сохраненные при первом запросе к Яндекс.Музыке
+func createPlaylistColumns(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"added_by", "TEXT"}, // "user:2" или "telegram:123456"
+		{"source", "TEXT"},
+		{"title", "TEXT"},
+		{"artist", "TEXT"},
+		{"duration_ms", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfNotExists(tx, "playlist", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("CREATE INDEX IF NOT EXISTS playlist_room ON playlist (room_id, position)")
+	return err
+}
+
+// trackSortKeys - выражения SQL для сортировки. Ключ курсора берется из
+// того же выражения, поэтому значения сравниваются так же, как сортируются.
+var trackSortKeys = map[string]string{
+	"position":   "position",
+	"date_added": "strftime('%Y-%m-%d %H:%M:%S', date_added)",
+	"title":      "COALESCE(title, '') COLLATE NOCASE",
+}
+
+// trackQuery - фильтры, сортировка и страница списка треков
+type trackQuery struct {
+	RoomID   int
+	HasRoom  bool
+	Artist   string
+	AddedBy  string
+	Source   string
+	From, To time.Time
+	Sort     string
+	Desc     bool
+	Limit    int // 0 - все треки
+	Cursor   *trackCursor
+}
+
+// trackCursor - позиция после последнего трека предыдущей страницы.
+// Клиенту отдается непрозрачной строкой.
+type trackCursor struct {
+	Sort string      `json:"s"`
+	Desc bool        `json:"d,omitempty"`
+	Key  interface{} `json:"k"`
+	ID   int64       `json:"id"`
+}
+
+func (c *trackCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTrackCursor(s string) (*trackCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c trackCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if _, ok := trackSortKeys[c.Sort]; !ok {
+		return nil, fmt.Errorf("unknown sort %q", c.Sort)
+	}
+	return &c, nil
+}
+
+// parseTrackTime принимает дату (2024-05-01) или время в RFC 3339.
+// Для конца периода дата означает весь день включительно.
+func parseTrackTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseTrackQuery разбирает параметры списка треков:
+//
+//	artist, added_by, source, from, to - фильтры
+//	sort=position|date_added|title, order=asc|desc
+//	limit, cursor - страница
+//
+// defaultLimit 0 означает, что без limit возвращаются все треки.
+// Возвращает ключ сообщения об ошибке для клиента.
+func parseTrackQuery(values url.Values, defaultLimit int) (*trackQuery, string) {
+	q := &trackQuery{
+		Artist:  strings.TrimSpace(values.Get("artist")),
+		AddedBy: strings.TrimSpace(values.Get("added_by")),
+		Source:  strings.TrimSpace(values.Get("source")),
+		Sort:    values.Get("sort"),
+		Limit:   defaultLimit,
+	}
+
+	if q.Sort == "" {
+		q.Sort = "position"
+	}
+	if _, ok := trackSortKeys[q.Sort]; !ok {
+		return nil, "http.invalid_query"
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, "http.invalid_query"
+	}
+
+	var err error
+	if s := values.Get("from"); s != "" {
+		if q.From, err = parseTrackTime(s, false); err != nil {
+			return nil, "http.invalid_query"
+		}
+	}
+	if s := values.Get("to"); s != "" {
+		if q.To, err = parseTrackTime(s, true); err != nil {
+			return nil, "http.invalid_query"
+		}
+	}
+
+	if s := values.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, "http.invalid_query"
+		}
+		q.Limit = min(n, maxTrackPageSize)
+	}
+
+	if s := values.Get("cursor"); s != "" {
+		cursor, err := decodeTrackCursor(s)
+		if err != nil || cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return nil, "http.invalid_cursor"
+		}
+		q.Cursor = cursor
+		if q.Limit == 0 {
+			q.Limit = defaultTrackPageSize
+		}
+	}
+	return q, ""
+}
+
+// playlistEntry - строка плейлиста без данных из Яндекс.Музыки
+type playlistEntry struct {
+	ID         int64
+	TrackID    int
+	RoomID     int
+	Position   int
+	DateAdded  string
+	AddedBy    string
+	Source     string
+	Title      string
+	Artist     string
+	DurationMs int
+	sortKey    interface{}
+}
+
+type trackPage struct {
+	Entries    []playlistEntry
+	Total      int
+	NextCursor string
+}
+
+// queryPlaylist выбирает страницу плейлиста. Курсор - ключ сортировки и id
+// последней строки, поэтому страницы не сдвигаются, когда треки добавляют
+// или удаляют между запросами.
+func queryPlaylist(ctx context.Context, q *trackQuery) (*trackPage, error) {
+	var where []string
+	var args []interface{}
+	if q.HasRoom {
+		where = append(where, "room_id = ?")
+		args = append(args, q.RoomID)
+	}
+	if q.Artist != "" {
+		where = append(where, "artist LIKE ?")
+		args = append(args, "%"+q.Artist+"%")
+	}
+	if q.AddedBy != "" {
+		where = append(where, "added_by = ?")
+		args = append(args, q.AddedBy)
+	}
+	if q.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, q.Source)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "strftime('%Y-%m-%d %H:%M:%S', date_added) >= ?")
+		args = append(args, q.From.Format("2006-01-02 15:04:05"))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "strftime('%Y-%m-%d %H:%M:%S', date_added) < ?")
+		args = append(args, q.To.Format("2006-01-02 15:04:05"))
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := &trackPage{}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM playlist"+filter, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	key := trackSortKeys[q.Sort]
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	if q.Cursor != nil {
+		cond := fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", key, op, key, op)
+		if filter == "" {
+			filter = " WHERE " + cond
+		} else {
+			filter += " AND " + cond
+		}
+		args = append(args, q.Cursor.Key, q.Cursor.Key, q.Cursor.ID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, track_id, room_id, position, strftime('%%Y-%%m-%%d %%H:%%M:%%S', date_added),
+			COALESCE(added_by, ''), COALESCE(source, ''), COALESCE(title, ''), COALESCE(artist, ''),
+			COALESCE(duration_ms, 0), %s
+		FROM playlist%s ORDER BY %s %s, id %s`, key, filter, key, dir, dir)
+	if q.Limit > 0 {
+		// Лишняя строка показывает, есть ли следующая страница
+		query += " LIMIT " + strconv.Itoa(q.Limit+1)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e playlistEntry
+		var dateAdded sql.NullString
+		if err := rows.Scan(&e.ID, &e.TrackID, &e.RoomID, &e.Position, &dateAdded,
+			&e.AddedBy, &e.Source, &e.Title, &e.Artist, &e.DurationMs, &e.sortKey); err != nil {
+			return nil, err
+		}
+		e.DateAdded = dateAdded.String
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 && len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+		last := page.Entries[len(page.Entries)-1]
+		cursor := &trackCursor{Sort: q.Sort, Desc: q.Desc, Key: last.sortKey, ID: last.ID}
+		page.NextCursor = cursor.encode()
+	}
+	return page, nil
+}
+
+// fillPlaylistMetadata дописывает название, исполнителя и длительность трекам
+// страницы, у которых их еще нет. Данные запрашиваются одним пакетом и
+// сохраняются в базе, чтобы следующие запросы не ходили за ними снова, а
+// фильтр и сортировка по исполнителю и названию их учитывали.
+func fillPlaylistMetadata(ctx context.Context, entries []playlistEntry, api *yandexAPI) error {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, e := range entries {
+		if e.Title == "" && !seen[e.TrackID] {
+			seen[e.TrackID] = true
+			ids = append(ids, e.TrackID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if api == nil {
+		return fmt.Errorf("yandex music client is not initialized")
+	}
+
+	tracks, err := api.Tracks(ctx, ids)
+	if err != nil {
+		return err
+	}
+	found := make(map[int]yandexTrack, len(tracks))
+	for _, t := range tracks {
+		// Недоступные треки приходят без названия - спросим в следующий раз
+		if t.Title == "" {
+			continue
+		}
+		_, err := db.ExecContext(ctx,
+			"UPDATE playlist SET title = ?, artist = ?, duration_ms = ? WHERE track_id = ? AND title IS NULL",
+			t.Title, t.ArtistName(), t.DurationMs, int(t.ID))
+		if err != nil {
+			return err
+		}
+		found[int(t.ID)] = t
+	}
+
+	for i := range entries {
+		if t, ok := found[entries[i].TrackID]; ok && entries[i].Title == "" {
+			entries[i].Title, entries[i].Artist, entries[i].DurationMs = t.Title, t.ArtistName(), t.DurationMs
+		}
+	}
+	return nil
+}
+
+// backfillPlaylistMetadata при запуске дописывает название и исполнителя
+// трекам, добавленным до того, как их стали сохранять при добавлении. Без
+// этого фильтр, сортировка и общее число не учитывают такие треки.
+func backfillPlaylistMetadata() {
+	defer wg.Done()
+
+	ctx, cancel := shutdownContext()
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT COALESCE(room_id, 0), track_id FROM playlist WHERE title IS NULL")
+	if err != nil {
+		log.Printf("Error loading tracks without metadata: %v", err)
+		return
+	}
+	byRoom := make(map[int][]playlistEntry)
+	for rows.Next() {
+		var e playlistEntry
+		if err := rows.Scan(&e.RoomID, &e.TrackID); err != nil {
+			rows.Close()
+			log.Printf("Error loading tracks without metadata: %v", err)
+			return
+		}
+		byRoom[e.RoomID] = append(byRoom[e.RoomID], e)
+	}
+	rows.Close()
+
+	for roomID, entries := range byRoom {
+		api := yandexAPIForRoom(roomID)
+		if api == nil {
+			continue
+		}
+		if err := fillPlaylistMetadata(ctx, entries, api); err != nil {
+			log.Printf("Error filling track metadata for room %d: %v", roomID, err)
+		}
+	}
+}
+
+func savePlaylistMetadata(ctx context.Context, info *TrackInfo) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE playlist SET title = ?, artist = ?, duration_ms = ? WHERE track_id = ?",
+		info.Title, info.Artist, info.DurationMs, info.TrackID)
+	return err
+}
+
+// pageTracks дополняет строки страницы данными из Яндекс.Музыки.
+// Треки, которые не удалось получить, пропускаются, как и раньше.
+func pageTracks(ctx context.Context, entries []playlistEntry, client *yamusic.Client) []TrackInfo {
+	tracks := make([]TrackInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := getTrackInfo(ctx, e.TrackID, client)
+		if err != nil {
+			log.Printf("Error getting track info for ID %d: %v", e.TrackID, err)
+			continue
+		}
+		if e.Title == "" {
+			if err := savePlaylistMetadata(ctx, info); err != nil {
+				log.Printf("Warning: failed to save track metadata: %v", err)
+			}
+		}
+
+		info.Position = e.Position
+		info.DateAdded = e.DateAdded
+		info.AddedBy = e.AddedBy
+		info.Source = e.Source
+		tracks = append(tracks, *info)
+	}
+	return tracks
+}
+
+// trackQueryForRequest собирает запрос из параметров и room_code (или кода
+// комнаты в пути /api/v1). Без комнаты выбираются треки всех комнат.
+// При ошибке сам отвечает клиенту и возвращает false.
+func trackQueryForRequest(w http.ResponseWriter, r *http.Request, defaultLimit int) (*trackQuery, bool) {
+	q, errKey := parseTrackQuery(r.URL.Query(), defaultLimit)
+	if errKey != "" {
+		httpError(w, r, errKey, http.StatusBadRequest)
+		return nil, false
+	}
+
+	code := roomCodeParam(r, r.URL.Query().Get("room_code"))
+	if code == "" {
+		return q, true
+	}
+	roomID, err := getRoomIDByCode(db, code)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.room_not_found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error checking room existence: %v", err)
+		httpError(w, r, "http.room_check", http.StatusInternalServerError)
+		return nil, false
+	}
+	q.RoomID, q.HasRoom = roomID, true
+	return q, true
+}
+
+// setPageHeaders - общее число треков и курсор следующей страницы для
+// старых адресов, которые отдают просто массив
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page *trackPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor == "" {
+		return
+	}
+	w.Header().Set("X-Next-Cursor", page.NextCursor)
+
+	next := *r.URL
+	values := next.Query()
+	values.Set("cursor", page.NextCursor)
+	next.RawQuery = values.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
+
+// requestSource - откуда пришел запрос на добавление трека
+func requestSource(r *http.Request) string {
+	if currentAPIToken(r) != nil {
+		return trackSourceAPI
+	}
+	if member, ok := requestMember(r); ok && member.Type == memberTelegram {
+		return trackSourceTelegram
+	}
+	return trackSourceWeb
+}
+
+// requestAddedBy - участник, добавивший трек, в виде "user:2"
+func requestAddedBy(r *http.Request) string {
+	if member, ok := requestMember(r); ok {
+		return member.String()
+	}
+	return ""
+}
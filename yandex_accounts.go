@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pkg.botr.me/yamusic"
+)
+
+// YandexAccount - подключенный аккаунт Яндекс.Музыки. Токен в структуру
+// не попадает: он нужен только для создания клиента.
+type YandexAccount struct {
+	ID        int       `json:"id"`
+	Label     string    `json:"label"`
+	UserID    int       `json:"user_id"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Клиенты всех аккаунтов. Комнаты без выбранного аккаунта используют
+// аккаунт по умолчанию. После изменения настроек клиенты пересоздаются
+// и подменяются целиком под блокировкой.
+var (
+	accountClientsMu sync.RWMutex
+	accountClients   = make(map[int]*yamusic.Client)
+	accountAPIs      = make(map[int]*yandexAPI) // запросы, которых нет в yamusic
+	defaultAccountID int
+)
+
+func createYandexAccountsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS yandex_accounts (
+		id INTEGER PRIMARY KEY,
+		label TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		access_token TEXT NOT NULL,
+		is_default INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// NULL - комната использует аккаунт по умолчанию
+	return addColumnIfNotExists(tx, "rooms", "account_id", "INTEGER")
+}
+
+// migrateLegacySettings переносит токен из таблицы settings, где раньше
+// хранился единственный аккаунт
+func migrateLegacySettings(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM yandex_accounts").Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// Строка с id = 2 - та, которую читала прежняя версия
+	var userID int
+	var stored string
+	err := db.QueryRow("SELECT user_id, access_token FROM settings ORDER BY id = 2 DESC, id DESC LIMIT 1").
+		Scan(&userID, &stored)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := decryptSecret("settings.access_token", stored)
+	if err != nil {
+		return err
+	}
+	if _, err := addYandexAccount(db, "default", userID, token, true); err != nil {
+		return err
+	}
+
+	log.Printf("Moved Yandex Music account from settings to yandex_accounts")
+	return nil
+}
+
+func addYandexAccount(db *sql.DB, label string, userID int, token string, makeDefault bool) (int, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return 0, fmt.Errorf("account label is required")
+	}
+	if strings.TrimSpace(token) == "" {
+		return 0, fmt.Errorf("access token is required")
+	}
+
+	registerLogSecret(token)
+	encrypted, err := encryptSecret("yandex_accounts.access_token", token)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Первый аккаунт всегда становится аккаунтом по умолчанию
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM yandex_accounts").Scan(&n); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	makeDefault = makeDefault || n == 0
+	if makeDefault {
+		if _, err := tx.Exec("UPDATE yandex_accounts SET is_default = 0"); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec("INSERT INTO yandex_accounts (label, user_id, access_token, is_default) VALUES (?, ?, ?, ?)",
+		label, userID, encrypted, makeDefault)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// updateYandexAccountToken меняет данные входа у существующего аккаунта
+func updateYandexAccountToken(db *sql.DB, id, userID int, token string) error {
+	registerLogSecret(token)
+	encrypted, err := encryptSecret("yandex_accounts.access_token", token)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE yandex_accounts SET user_id = ?, access_token = ? WHERE id = ?", userID, encrypted, id)
+	return err
+}
+
+func listYandexAccounts(db *sql.DB) ([]YandexAccount, error) {
+	rows, err := db.Query("SELECT id, label, user_id, is_default, created_at FROM yandex_accounts ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []YandexAccount{}
+	for rows.Next() {
+		var account YandexAccount
+		if err := rows.Scan(&account.ID, &account.Label, &account.UserID, &account.IsDefault, &account.CreatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func setDefaultYandexAccount(db *sql.DB, id int) error {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM yandex_accounts WHERE id = ?)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	_, err := db.Exec("UPDATE yandex_accounts SET is_default = (id = ?)", id)
+	return err
+}
+
+// deleteYandexAccount удаляет аккаунт. Комнаты, которые им пользовались,
+// возвращаются на аккаунт по умолчанию.
+func deleteYandexAccount(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE rooms SET account_id = NULL WHERE account_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM yandex_accounts WHERE id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Если удалили аккаунт по умолчанию, им становится самый старый из оставшихся
+	if _, err := tx.Exec(`
+        UPDATE yandex_accounts SET is_default = 1
+        WHERE id = (SELECT MIN(id) FROM yandex_accounts)
+            AND NOT EXISTS (SELECT 1 FROM yandex_accounts WHERE is_default = 1)`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setRoomAccount выбирает аккаунт комнаты, 0 - аккаунт по умолчанию
+func setRoomAccount(db *sql.DB, roomID, accountID int) error {
+	var value interface{}
+	if accountID != 0 {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM yandex_accounts WHERE id = ?)", accountID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		value = accountID
+	}
+	_, err := db.Exec("UPDATE rooms SET account_id = ? WHERE id = ?", value, roomID)
+	return err
+}
+
+// loadYandexAccounts создает клиентов для всех аккаунтов из базы
+func loadYandexAccounts(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, user_id, access_token, is_default FROM yandex_accounts ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	clients := make(map[int]*yamusic.Client)
+	apis := make(map[int]*yandexAPI)
+	defaultID := 0
+	for rows.Next() {
+		var id, userID int
+		var stored string
+		var isDefault bool
+		if err := rows.Scan(&id, &userID, &stored, &isDefault); err != nil {
+			return err
+		}
+
+		token, err := decryptSecret("yandex_accounts.access_token", stored)
+		if err != nil {
+			log.Printf("Warning: Could not decrypt token of Yandex account %d: %v", id, err)
+			continue
+		}
+		registerLogSecret(token)
+
+		clients[id] = yamusic.NewClient(yamusic.AccessToken(userID, token))
+		apis[id] = newYandexAPI(userID, token)
+		if isDefault || defaultID == 0 {
+			defaultID = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	accountClientsMu.Lock()
+	accountClients = clients
+	accountAPIs = apis
+	defaultAccountID = defaultID
+	accountClientsMu.Unlock()
+	return nil
+}
+
+// defaultClient возвращает клиента аккаунта по умолчанию или nil, если
+// аккаунтов нет. Обработчик берет клиента один раз в начале запроса.
+func defaultClient() *yamusic.Client {
+	accountClientsMu.RLock()
+	defer accountClientsMu.RUnlock()
+	return accountClients[defaultAccountID]
+}
+
+// yandexAccountStatus - владелец токена по данным Яндекс.Музыки
+type yandexAccountStatus struct {
+	UID  int
+	Name string
+}
+
+// checkYandexToken проверяет токен запросом статуса аккаунта до сохранения.
+// userID 0 означает, что ID берется из ответа. Возвращает ключ сообщения
+// об ошибке для пользователя.
+func checkYandexToken(ctx context.Context, userID int, token string) (*yandexAccountStatus, string) {
+	if strings.TrimSpace(token) == "" {
+		return nil, "http.invalid_request"
+	}
+
+	accountStatus, resp, err := yamusic.NewClient(yamusic.AccessToken(userID, token)).Account().GetStatus(ctx)
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		return nil, "page.token_invalid"
+	}
+	if err != nil || resp == nil || resp.StatusCode != http.StatusOK {
+		log.Printf("Error checking Yandex Music token: %v", err)
+		return nil, "page.token_check_failed"
+	}
+
+	// Без авторизации Яндекс отвечает 200, но без аккаунта
+	account := accountStatus.Result.Account
+	if account.UID == 0 {
+		return nil, "page.token_invalid"
+	}
+	if userID != 0 && account.UID != userID {
+		return nil, "page.token_mismatch"
+	}
+
+	name := account.FullName
+	if name == "" {
+		name = account.DisplayName
+	}
+	if name == "" {
+		name = account.Login
+	}
+	return &yandexAccountStatus{UID: account.UID, Name: name}, ""
+}
+
+// parseOptionalUserID разбирает ID пользователя из формы; пустое поле - 0
+func parseOptionalUserID(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, true
+	}
+	userID, err := strconv.Atoi(s)
+	return userID, err == nil
+}
+
+// roomAccountID - аккаунт, выбранный в комнате; NULL - аккаунт по умолчанию
+func roomAccountID(roomID int) sql.NullInt64 {
+	var accountID sql.NullInt64
+	if roomID != 0 {
+		if err := db.QueryRow("SELECT account_id FROM rooms WHERE id = ?", roomID).Scan(&accountID); err != nil && err != sql.ErrNoRows {
+			log.Printf("Error loading room account: %v", err)
+		}
+	}
+	return accountID
+}
+
+// clientForRoom возвращает клиента аккаунта, выбранного в комнате
+func clientForRoom(roomID int) *yamusic.Client {
+	accountID := roomAccountID(roomID)
+
+	accountClientsMu.RLock()
+	defer accountClientsMu.RUnlock()
+	if c, ok := accountClients[int(accountID.Int64)]; ok && accountID.Valid {
+		return c
+	}
+	return accountClients[defaultAccountID]
+}
+
+// yandexAPIForRoom - то же для запросов к каталогу и плейлистам
+func yandexAPIForRoom(roomID int) *yandexAPI {
+	accountID := roomAccountID(roomID)
+
+	accountClientsMu.RLock()
+	defer accountClientsMu.RUnlock()
+	if a, ok := accountAPIs[int(accountID.Int64)]; ok && accountID.Valid {
+		return a
+	}
+	return accountAPIs[defaultAccountID]
+}
+
+// clientForRoomCode - то же по коду комнаты; без кода - аккаунт по умолчанию
+func clientForRoomCode(code string) *yamusic.Client {
+	roomID := 0
+	if code != "" {
+		if id, err := getRoomIDByCode(db, code); err == nil {
+			roomID = id
+		}
+	}
+	return clientForRoom(roomID)
+}
+
+// Аккаунты для API: GET - список, POST - выбор аккаунта для комнаты
+func yandexAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := listYandexAccounts(db)
+	if err != nil {
+		log.Printf("Error listing Yandex accounts: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(accounts); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// Выбор аккаунта комнаты: {"room_code": "ABCDE", "account_id": 2}, 0 - по умолчанию
+func roomAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		httpError(w, r, "http.invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		RoomCode  string `json:"room_code"`
+		AccountID int    `json:"account_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		log.Printf("Error decoding JSON: %v", err)
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	roomID, ok := authorizeRoomAction(w, r, roomCodeParam(r, requestData.RoomCode), roomActionManage)
+	if !ok {
+		return
+	}
+
+	err := setRoomAccount(db, roomID, requestData.AccountID)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "http.account_not_found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving room account: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RoomAccount - комната и выбранный в ней аккаунт для страницы настроек
+type RoomAccount struct {
+	ID        int
+	Code      string
+	AccountID int
+}
+
+func listRoomAccounts(db *sql.DB) ([]RoomAccount, error) {
+	rows, err := db.Query("SELECT id, code, COALESCE(account_id, 0) FROM rooms ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []RoomAccount
+	for rows.Next() {
+		var room RoomAccount
+		if err := rows.Scan(&room.ID, &room.Code, &room.AccountID); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// handleAccountsForm обрабатывает формы аккаунтов на странице настроек.
+// Возвращает имя проверенного аккаунта Яндекса, если форма меняла токен,
+// и ключ сообщения об ошибке для пользователя.
+// Аккаунты общие для всего сервера, поэтому добавлять, удалять и выбирать
+// аккаунт по умолчанию может только администратор, а аккаунт комнаты -
+// ее хозяин.
+func handleAccountsForm(r *http.Request) (string, string, error) {
+	accountName := ""
+	action := r.FormValue("action")
+
+	switch action {
+	case "add_account", "default_account", "delete_account":
+		if user := currentUser(r); user == nil || !user.IsAdmin {
+			return "", "http.forbidden", nil
+		}
+	}
+
+	switch action {
+	case "add_account":
+		userID, ok := parseOptionalUserID(r.FormValue("userID"))
+		if !ok {
+			return "", "page.invalid_user_id", nil
+		}
+		token := r.FormValue("token")
+		status, errKey := checkYandexToken(r.Context(), userID, token)
+		if errKey != "" {
+			return "", errKey, nil
+		}
+		if _, err := addYandexAccount(db, r.FormValue("label"), status.UID, token, r.FormValue("is_default") == "on"); err != nil {
+			return "", "", err
+		}
+		accountName = status.Name
+
+	case "default_account", "delete_account":
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			return "", "http.invalid_request", nil
+		}
+		if action == "delete_account" {
+			err = deleteYandexAccount(db, id)
+		} else {
+			err = setDefaultYandexAccount(db, id)
+		}
+		if err == sql.ErrNoRows {
+			return "", "http.account_not_found", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+	case "room_account":
+		roomID, err := strconv.Atoi(r.FormValue("room_id"))
+		if err != nil {
+			return "", "http.invalid_request", nil
+		}
+		allowed, err := checkRoomAction(r, roomID, roomActionManage)
+		if err != nil {
+			return "", "", err
+		}
+		if !allowed {
+			return "", "http.room_forbidden", nil
+		}
+		accountID, err := strconv.Atoi(r.FormValue("account_id"))
+		if err != nil {
+			return "", "http.invalid_request", nil
+		}
+		err = setRoomAccount(db, roomID, accountID)
+		if err == sql.ErrNoRows {
+			return "", "http.account_not_found", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+	default:
+		return "", "http.invalid_request", nil
+	}
+
+	return accountName, "", loadYandexAccounts(db)
+}
+
+// saveDefaultAccount меняет токен аккаунта по умолчанию, а если аккаунтов
+// еще нет - создает его. Токен сохраняется только после проверки.
+func saveDefaultAccount(r *http.Request) (string, string, error) {
+	if user := currentUser(r); user == nil || !user.IsAdmin {
+		return "", "http.forbidden", nil
+	}
+	userID, ok := parseOptionalUserID(r.FormValue("userID"))
+	if !ok {
+		return "", "page.invalid_user_id", nil
+	}
+	token := r.FormValue("token")
+	status, errKey := checkYandexToken(r.Context(), userID, token)
+	if errKey != "" {
+		return "", errKey, nil
+	}
+
+	var id int
+	err := db.QueryRow("SELECT id FROM yandex_accounts WHERE is_default = 1").Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = addYandexAccount(db, "default", status.UID, token, true)
+	} else if err == nil {
+		err = updateYandexAccountToken(db, id, status.UID, token)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return status.Name, "", loadYandexAccounts(db)
+}
+
+// renderSettings показывает страницу настроек с сообщением об успехе или ошибке
+func renderSettings(w http.ResponseWriter, r *http.Request, notice, errMsg string) {
+	accounts, err := listYandexAccounts(db)
+	if err != nil {
+		log.Printf("Error listing Yandex accounts: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	rooms, err := listRoomAccounts(db)
+	if err != nil {
+		log.Printf("Error listing rooms: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+
+	user := currentUser(r)
+	loadTemplate(w, r, "settings.html", struct {
+		Notice   string
+		Error    string
+		IsAdmin  bool
+		Accounts []YandexAccount
+		Rooms    []RoomAccount
+	}{
+		Notice:   notice,
+		Error:    errMsg,
+		IsAdmin:  user != nil && user.IsAdmin,
+		Accounts: accounts,
+		Rooms:    rooms,
+	})
+}
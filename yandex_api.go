@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Запросы к API Яндекс.Музыки, которых нет в библиотеке yamusic: альбомы,
+// исполнители, плейлисты пользователей. Авторизация - тем же токеном
+// аккаунта, что и у клиента yamusic.
+const yandexAPIBase = "https://api.music.yandex.net"
+
+var yandexHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+type yandexAPI struct {
+	userID int
+	token  string
+}
+
+func newYandexAPI(userID int, token string) *yandexAPI {
+	return &yandexAPI{userID: userID, token: token}
+}
+
+// yandexAPIError - ошибка, которую вернул сам API
+type yandexAPIError struct {
+	Status  int
+	Name    string
+	Message string
+}
+
+func (e *yandexAPIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("yandex music API: %d %s: %s", e.Status, e.Name, e.Message)
+	}
+	return fmt.Sprintf("yandex music API: %d %s", e.Status, e.Name)
+}
+
+// isYandexNotFound - объекта нет или он недоступен этому аккаунту
+func isYandexNotFound(err error) bool {
+	apiErr, ok := err.(*yandexAPIError)
+	return ok && (apiErr.Status == http.StatusNotFound || apiErr.Name == "not-found")
+}
+
+// call выполняет запрос и раскладывает поле result ответа в result.
+// Для GET form передается в строке запроса, для POST - в теле.
+func (a *yandexAPI) call(ctx context.Context, method, path string, form url.Values, result interface{}) error {
+	endpoint := yandexAPIBase + path
+	var body io.Reader
+	if method == http.MethodGet {
+		if len(form) > 0 {
+			endpoint += "?" + form.Encode()
+		}
+	} else if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "OAuth "+a.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := yandexHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("yandex music API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read yandex music API response: %w", err)
+	}
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Name    string `json:"name"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("invalid yandex music API response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || envelope.Error != nil {
+		apiErr := &yandexAPIError{Status: resp.StatusCode, Name: http.StatusText(resp.StatusCode)}
+		if envelope.Error != nil {
+			apiErr.Name, apiErr.Message = envelope.Error.Name, envelope.Error.Message
+		}
+		return apiErr
+	}
+
+	if result == nil || len(envelope.Result) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(envelope.Result))
+	if err := dec.Decode(result); err != nil {
+		return fmt.Errorf("invalid yandex music API result: %w", err)
+	}
+	return nil
+}
+
+// yandexID - идентификатор, который API отдает то числом, то строкой,
+// иногда вместе с альбомом: "12345:678"
+type yandexID int
+
+func (id *yandexID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*id = 0
+		return nil
+	}
+	s, _, _ = strings.Cut(s, ":")
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid yandex id %q", s)
+	}
+	*id = yandexID(n)
+	return nil
+}
+
+type yandexArtistRef struct {
+	ID   yandexID `json:"id"`
+	Name string   `json:"name"`
+}
+
+type yandexAlbumRef struct {
+	ID yandexID `json:"id"`
+}
+
+// yandexTrack - трек в ответах API каталога
+type yandexTrack struct {
+	ID         yandexID          `json:"id"`
+	Title      string            `json:"title"`
+	DurationMs int               `json:"durationMs"`
+	Available  *bool             `json:"available"`
+	Artists    []yandexArtistRef `json:"artists"`
+	Albums     []yandexAlbumRef  `json:"albums"`
+}
+
+// IsAvailable - трек можно слушать; API не всегда присылает поле
+func (t *yandexTrack) IsAvailable() bool {
+	return t.Available == nil || *t.Available
+}
+
+func (t *yandexTrack) ArtistName() string {
+	names := make([]string, 0, len(t.Artists))
+	for _, a := range t.Artists {
+		names = append(names, a.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// AlbumID - первый альбом трека, нужен для изменения плейлистов
+func (t *yandexTrack) AlbumID() int {
+	if len(t.Albums) == 0 {
+		return 0
+	}
+	return int(t.Albums[0].ID)
+}
+
+// Album возвращает название альбома и его треки по порядку (все диски)
+func (a *yandexAPI) Album(ctx context.Context, albumID int) (string, []yandexTrack, error) {
+	var album struct {
+		Title   string          `json:"title"`
+		Volumes [][]yandexTrack `json:"volumes"`
+	}
+	if err := a.call(ctx, http.MethodGet, fmt.Sprintf("/albums/%d/with-tracks", albumID), nil, &album); err != nil {
+		return "", nil, err
+	}
+	var tracks []yandexTrack
+	for _, volume := range album.Volumes {
+		tracks = append(tracks, volume...)
+	}
+	return album.Title, tracks, nil
+}
+
+// ArtistTopTracks возвращает имя исполнителя и его популярные треки
+func (a *yandexAPI) ArtistTopTracks(ctx context.Context, artistID int) (string, []yandexTrack, error) {
+	var info struct {
+		Artist        yandexArtistRef `json:"artist"`
+		PopularTracks []yandexTrack   `json:"popularTracks"`
+	}
+	if err := a.call(ctx, http.MethodGet, fmt.Sprintf("/artists/%d/brief-info", artistID), nil, &info); err != nil {
+		return "", nil, err
+	}
+	return info.Artist.Name, info.PopularTracks, nil
+}
+
+// yandexPlaylist - плейлист пользователя. Revision растет при каждом
+// изменении, и правки принимаются только с актуальной ревизией.
+type yandexPlaylist struct {
+	Kind       int    `json:"kind"`
+	Title      string `json:"title"`
+	Revision   int    `json:"revision"`
+	TrackCount int    `json:"trackCount"`
+	Owner      struct {
+		UID   int    `json:"uid"`
+		Login string `json:"login"`
+	} `json:"owner"`
+	Tracks []struct {
+		ID      yandexID    `json:"id"`
+		AlbumID yandexID    `json:"albumId"`
+		Track   yandexTrack `json:"track"`
+	} `json:"tracks"`
+}
+
+// TrackList - треки плейлиста по порядку
+func (p *yandexPlaylist) TrackList() []yandexTrack {
+	tracks := make([]yandexTrack, 0, len(p.Tracks))
+	for _, item := range p.Tracks {
+		track := item.Track
+		if track.ID == 0 {
+			track.ID = item.ID
+		}
+		if len(track.Albums) == 0 && item.AlbumID != 0 {
+			track.Albums = []yandexAlbumRef{{ID: item.AlbumID}}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// Playlist возвращает плейлист пользователя с треками. owner - логин или UID.
+func (a *yandexAPI) Playlist(ctx context.Context, owner string, kind int) (*yandexPlaylist, error) {
+	var playlist yandexPlaylist
+	path := fmt.Sprintf("/users/%s/playlists/%d", url.PathEscape(owner), kind)
+	if err := a.call(ctx, http.MethodGet, path, nil, &playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// UserPlaylists - плейлисты пользователя без треков
+func (a *yandexAPI) UserPlaylists(ctx context.Context, ownerUID int) ([]yandexPlaylist, error) {
+	var playlists []yandexPlaylist
+	if err := a.call(ctx, http.MethodGet, fmt.Sprintf("/users/%d/playlists/list", ownerUID), nil, &playlists); err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// LikedTrackIDs возвращает ревизию коллекции "Мне нравится" и ID ее
+// треков, последние добавленные - первыми
+func (a *yandexAPI) LikedTrackIDs(ctx context.Context, ownerUID int) (int, []int, error) {
+	var likes struct {
+		Library struct {
+			Revision int `json:"revision"`
+			Tracks   []struct {
+				ID yandexID `json:"id"`
+			} `json:"tracks"`
+		} `json:"library"`
+	}
+	if err := a.call(ctx, http.MethodGet, fmt.Sprintf("/users/%d/likes/tracks", ownerUID), nil, &likes); err != nil {
+		return 0, nil, err
+	}
+
+	ids := make([]int, 0, len(likes.Library.Tracks))
+	for _, t := range likes.Library.Tracks {
+		ids = append(ids, int(t.ID))
+	}
+	return likes.Library.Revision, ids, nil
+}
+
+// Tracks возвращает данные треков в порядке ids. Треки, которых API не
+// вернул, остаются с одним ID и считаются недоступными.
+func (a *yandexAPI) Tracks(ctx context.Context, ids []int) ([]yandexTrack, error) {
+	const batchSize = 200
+
+	byID := make(map[int]yandexTrack, len(ids))
+	for start := 0; start < len(ids); start += batchSize {
+		batch := ids[start:min(start+batchSize, len(ids))]
+		parts := make([]string, len(batch))
+		for i, id := range batch {
+			parts[i] = strconv.Itoa(id)
+		}
+
+		var found []yandexTrack
+		form := url.Values{"track-ids": {strings.Join(parts, ",")}}
+		if err := a.call(ctx, http.MethodPost, "/tracks", form, &found); err != nil {
+			return nil, err
+		}
+		for _, t := range found {
+			byID[int(t.ID)] = t
+		}
+	}
+
+	unavailable := false
+	tracks := make([]yandexTrack, 0, len(ids))
+	for _, id := range ids {
+		t, ok := byID[id]
+		if !ok {
+			t = yandexTrack{ID: yandexID(id), Available: &unavailable}
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+// SearchTracks ищет треки по строке запроса; лучшие совпадения идут первыми
+func (a *yandexAPI) SearchTracks(ctx context.Context, text string) ([]yandexTrack, error) {
+	var found struct {
+		Tracks *struct {
+			Results []yandexTrack `json:"results"`
+		} `json:"tracks"`
+	}
+	form := url.Values{"text": {text}, "type": {"track"}, "page": {"0"}}
+	if err := a.call(ctx, http.MethodGet, "/search", form, &found); err != nil {
+		return nil, err
+	}
+	if found.Tracks == nil {
+		return nil, nil
+	}
+	return found.Tracks.Results, nil
+}
+
+// isYandexWrongRevision - плейлист успели изменить после того, как мы
+// прочитали его ревизию
+func isYandexWrongRevision(err error) bool {
+	apiErr, ok := err.(*yandexAPIError)
+	return ok && (apiErr.Status == http.StatusPreconditionFailed || apiErr.Name == "wrong-revision")
+}
+
+// yandexTrackRef - трек в операциях над плейлистом: API требует и альбом
+type yandexTrackRef struct {
+	ID      string `json:"id"`
+	AlbumID string `json:"albumId"`
+}
+
+// yandexPlaylistOp - операция change-relative. Операции применяются по
+// порядку, индексы - после предыдущих операций.
+type yandexPlaylistOp map[string]interface{}
+
+func insertTracksOp(at int, tracks []yandexTrackRef) yandexPlaylistOp {
+	return yandexPlaylistOp{"op": "insert", "at": at, "tracks": tracks}
+}
+
+func deleteTracksOp(from, to int) yandexPlaylistOp {
+	return yandexPlaylistOp{"op": "delete", "from": from, "to": to}
+}
+
+// CreatePlaylist создает приватный плейлист владельца токена
+func (a *yandexAPI) CreatePlaylist(ctx context.Context, title string) (*yandexPlaylist, error) {
+	var playlist yandexPlaylist
+	form := url.Values{"title": {title}, "visibility": {"private"}}
+	if err := a.call(ctx, http.MethodPost, fmt.Sprintf("/users/%d/playlists/create", a.userID), form, &playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// ChangePlaylist применяет операции к плейлисту владельца токена. revision -
+// ревизия, от которой построены операции; если плейлист с тех пор менялся,
+// API отвечает ошибкой (isYandexWrongRevision).
+func (a *yandexAPI) ChangePlaylist(ctx context.Context, kind, revision int, ops []yandexPlaylistOp) (*yandexPlaylist, error) {
+	diff, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist yandexPlaylist
+	form := url.Values{"diff": {string(diff)}, "revision": {strconv.Itoa(revision)}}
+	path := fmt.Sprintf("/users/%d/playlists/%d/change-relative", a.userID, kind)
+	if err := a.call(ctx, http.MethodPost, path, form, &playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Импорт плейлистов аккаунта Яндекс.Музыки в комнату:
+//   - append  - треки добавляются в конец плейлиста комнаты;
+//   - replace - плейлист комнаты заменяется треками источника;
+//   - sync    - как append, а затем по расписанию подтягиваются изменения:
+//     новые треки добавляются, удаленные из источника - убираются.
+//
+// Синхронизация удаляет только те треки, которые добавила сама (import_id),
+// поэтому треки, добавленные в комнату вручную, она не трогает.
+const (
+	importModeAppend  = "append"
+	importModeReplace = "replace"
+	importModeSync    = "sync"
+)
+
+// likedPlaylistID - коллекция "Мне нравится" в списке плейлистов
+const likedPlaylistID = "likes"
+
+const (
+	defaultSyncInterval = 60 // минут
+	minSyncInterval     = 15
+	syncCheckInterval   = time.Minute
+)
+
+func createPlaylistImportsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS playlist_imports (
+		id INTEGER PRIMARY KEY,
+		room_id INTEGER NOT NULL,
+		owner_uid INTEGER NOT NULL,
+		playlist TEXT NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		interval_minutes INTEGER NOT NULL,
+		revision INTEGER NOT NULL DEFAULT -1,
+		added_by TEXT,
+		last_sync_at TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (room_id, owner_uid, playlist)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// Какая синхронизация добавила трек; NULL - добавлен не ею
+	return addColumnIfNotExists(tx, "playlist", "import_id", "INTEGER")
+}
+
+// importablePlaylist - плейлист аккаунта, который можно импортировать
+type importablePlaylist struct {
+	ID         string `json:"id"` // номер плейлиста или "likes"
+	Title      string `json:"title"`
+	TrackCount int    `json:"track_count"`
+}
+
+// listImportablePlaylists возвращает "Мне нравится" и плейлисты владельца токена
+func listImportablePlaylists(ctx context.Context, api *yandexAPI, likesTitle string) ([]importablePlaylist, error) {
+	_, likedIDs, err := api.LikedTrackIDs(ctx, api.userID)
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := api.UserPlaylists(ctx, api.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []importablePlaylist{{ID: likedPlaylistID, Title: likesTitle, TrackCount: len(likedIDs)}}
+	for _, p := range playlists {
+		result = append(result, importablePlaylist{ID: strconv.Itoa(p.Kind), Title: p.Title, TrackCount: p.TrackCount})
+	}
+	return result, nil
+}
+
+// validImportPlaylist - "likes" или номер плейлиста
+func validImportPlaylist(id string) bool {
+	if id == likedPlaylistID {
+		return true
+	}
+	kind, err := strconv.Atoi(id)
+	return err == nil && kind > 0
+}
+
+// importSource - треки источника импорта на момент запроса
+type importSource struct {
+	Title    string
+	Revision int
+	Tracks   []yandexTrack
+}
+
+// fetchImportSource загружает плейлист или "Мне нравится" пользователя ownerUID
+func fetchImportSource(ctx context.Context, api *yandexAPI, ownerUID int, playlist, likesTitle string) (*importSource, error) {
+	if playlist == likedPlaylistID {
+		revision, ids, err := api.LikedTrackIDs(ctx, ownerUID)
+		if err != nil {
+			return nil, err
+		}
+		tracks, err := api.Tracks(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		return &importSource{Title: likesTitle, Revision: revision, Tracks: tracks}, nil
+	}
+
+	kind, err := strconv.Atoi(playlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid playlist id %q", playlist)
+	}
+	p, err := api.Playlist(ctx, strconv.Itoa(ownerUID), kind)
+	if err != nil {
+		return nil, err
+	}
+	return &importSource{Title: p.Title, Revision: p.Revision, Tracks: p.TrackList()}, nil
+}
+
+// playlistImport - расписание синхронизации плейлиста с комнатой
+type playlistImport struct {
+	ID              int        `json:"id"`
+	RoomID          int        `json:"-"`
+	OwnerUID        int        `json:"owner_uid"`
+	Playlist        string     `json:"playlist"`
+	Title           string     `json:"title"`
+	IntervalMinutes int        `json:"interval_minutes"`
+	Revision        int        `json:"revision"`
+	AddedBy         string     `json:"-"`
+	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// importOptions - что и как импортировать
+type importOptions struct {
+	Playlist string
+	Mode     string
+	Interval int // минут между синхронизациями, только для sync
+	AddedBy  string
+	Source   string
+}
+
+// importResult - итог импорта: добавленные и пропущенные треки, число
+// удаленных (replace и sync) и расписание для sync
+type importResult struct {
+	bulkAddResult
+	Mode    string          `json:"mode"`
+	Removed int             `json:"removed"`
+	Sync    *playlistImport `json:"sync,omitempty"`
+}
+
+// importTracks записывает треки источника в комнату в выбранном режиме
+func importTracks(ctx context.Context, roomID, ownerUID int, src *importSource, opts importOptions) (*importResult, error) {
+	switch opts.Mode {
+	case importModeAppend:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		added, err := bulkInsertTracksTx(ctx, tx, roomID, src.Title, src.Tracks, opts.AddedBy, opts.Source, 0, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &importResult{bulkAddResult: *added, Mode: opts.Mode}, nil
+
+	case importModeReplace:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		deleted, err := tx.ExecContext(ctx, "DELETE FROM playlist WHERE room_id = ?", roomID)
+		if err != nil {
+			return nil, err
+		}
+		removed, _ := deleted.RowsAffected()
+		added, err := bulkInsertTracksTx(ctx, tx, roomID, src.Title, src.Tracks, opts.AddedBy, opts.Source, 0, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &importResult{bulkAddResult: *added, Mode: opts.Mode, Removed: int(removed)}, nil
+
+	case importModeSync:
+		job, err := savePlaylistImport(ctx, roomID, ownerUID, src.Title, opts)
+		if err != nil {
+			return nil, err
+		}
+		res, err := syncPlaylistImport(ctx, job, src)
+		if err != nil {
+			return nil, err
+		}
+		res.Sync = job
+		return res, nil
+
+	default:
+		return nil, fmt.Errorf("unknown import mode %q", opts.Mode)
+	}
+}
+
+// savePlaylistImport создает расписание или обновляет интервал существующего
+func savePlaylistImport(ctx context.Context, roomID, ownerUID int, title string, opts importOptions) (*playlistImport, error) {
+	interval := opts.Interval
+	if interval == 0 {
+		interval = defaultSyncInterval
+	}
+	interval = max(interval, minSyncInterval)
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO playlist_imports (room_id, owner_uid, playlist, title, interval_minutes, added_by)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))
+		ON CONFLICT (room_id, owner_uid, playlist) DO UPDATE SET title = excluded.title, interval_minutes = excluded.interval_minutes`,
+		roomID, ownerUID, opts.Playlist, title, interval, opts.AddedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := queryPlaylistImports(ctx, "WHERE room_id = ? AND owner_uid = ? AND playlist = ?", roomID, ownerUID, opts.Playlist)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &jobs[0], nil
+}
+
+func queryPlaylistImports(ctx context.Context, where string, args ...interface{}) ([]playlistImport, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, room_id, owner_uid, playlist, title, interval_minutes, revision,
+			COALESCE(added_by, ''), last_sync_at, COALESCE(last_error, '')
+		FROM playlist_imports `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []playlistImport{}
+	for rows.Next() {
+		var job playlistImport
+		var lastSync sql.NullTime
+		if err := rows.Scan(&job.ID, &job.RoomID, &job.OwnerUID, &job.Playlist, &job.Title, &job.IntervalMinutes,
+			&job.Revision, &job.AddedBy, &lastSync, &job.LastError); err != nil {
+			return nil, err
+		}
+		if lastSync.Valid {
+			job.LastSyncAt = &lastSync.Time
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// deletePlaylistImport останавливает синхронизацию. Добавленные ею треки
+// остаются в комнате как обычные.
+func deletePlaylistImport(roomID int, where string, args ...interface{}) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM playlist_imports WHERE room_id = ? AND "+where, append([]interface{}{roomID}, args...)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("UPDATE playlist SET import_id = NULL WHERE import_id = ?", id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM playlist_imports WHERE id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// syncPlaylistImport приводит комнату в соответствие с источником: добавляет
+// новые треки и удаляет добавленные синхронизацией, которых в источнике больше нет
+func syncPlaylistImport(ctx context.Context, job *playlistImport, src *importSource) (*importResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	added, err := bulkInsertTracksTx(ctx, tx, job.RoomID, src.Title, src.Tracks, job.AddedBy, trackSourceSync, job.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	inSource := make(map[int]bool, len(src.Tracks))
+	for _, t := range src.Tracks {
+		inSource[int(t.ID)] = true
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT track_id FROM playlist WHERE room_id = ? AND import_id = ?", job.RoomID, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	var stale []int
+	for rows.Next() {
+		var trackID int
+		if err := rows.Scan(&trackID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !inSource[trackID] {
+			stale = append(stale, trackID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, trackID := range stale {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM playlist WHERE room_id = ? AND track_id = ? AND import_id = ?", job.RoomID, trackID, job.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	title := job.Title
+	if src.Title != "" {
+		title = src.Title
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE playlist_imports SET title = ?, revision = ?, last_sync_at = CURRENT_TIMESTAMP, last_error = NULL
+		WHERE id = ?`, title, src.Revision, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	job.Title, job.Revision, job.LastError = title, src.Revision, ""
+	return &importResult{bulkAddResult: *added, Mode: importModeSync, Removed: len(stale)}, nil
+}
+
+// runPlaylistSync раз в минуту синхронизирует плейлисты, у которых подошло время
+func runPlaylistSync() {
+	defer wg.Done()
+
+	// Запрос к Яндекс.Музыке прерывается при остановке сервера
+	ctx, cancel := shutdownContext()
+	defer cancel()
+
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		jobs, err := queryPlaylistImports(ctx, `
+			WHERE last_sync_at IS NULL
+				OR datetime(last_sync_at, '+' || interval_minutes || ' minutes') <= datetime('now')`)
+		if err != nil {
+			log.Printf("Error loading playlist imports: %v", err)
+			continue
+		}
+		for i := range jobs {
+			select {
+			case <-shutdownCh:
+				return
+			default:
+			}
+			runScheduledSync(ctx, &jobs[i])
+		}
+	}
+}
+
+// runScheduledSync синхронизирует один плейлист. Если ревизия источника не
+// изменилась, комната не трогается. Ошибка сохраняется в last_error и
+// видна в списке синхронизаций.
+func runScheduledSync(ctx context.Context, job *playlistImport) {
+	err := func() error {
+		api := yandexAPIForRoom(job.RoomID)
+		if api == nil {
+			return fmt.Errorf("no Yandex Music account")
+		}
+		src, err := fetchImportSource(ctx, api, job.OwnerUID, job.Playlist, job.Title)
+		if err != nil {
+			return err
+		}
+		if src.Revision == job.Revision {
+			_, err := db.ExecContext(ctx, "UPDATE playlist_imports SET last_sync_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = ?", job.ID)
+			return err
+		}
+
+		res, err := syncPlaylistImport(ctx, job, src)
+		if err != nil {
+			return err
+		}
+		log.Printf("Room %d: synced %q, added %d, removed %d", job.RoomID, job.Title, len(res.Added), res.Removed)
+		return nil
+	}()
+	if err == nil {
+		return
+	}
+
+	log.Printf("Error syncing playlist import %d: %v", job.ID, err)
+	_, err = db.ExecContext(ctx, "UPDATE playlist_imports SET last_sync_at = CURRENT_TIMESTAMP, last_error = ? WHERE id = ?", err.Error(), job.ID)
+	if err != nil {
+		log.Printf("Error saving playlist import status: %v", err)
+	}
+}
+
+// roomYandexAPI - клиент API аккаунта комнаты; без аккаунта отвечает 503
+func roomYandexAPI(w http.ResponseWriter, r *http.Request, roomID int) (*yandexAPI, bool) {
+	api := yandexAPIForRoom(roomID)
+	if api == nil {
+		httpError(w, r, "http.service_unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return api, true
+}
+
+// GET /api/v1/rooms/{code}/yandex/playlists - "Мне нравится" и плейлисты
+// аккаунта Яндекс.Музыки комнаты
+func apiV1YandexPlaylists(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionView)
+	if !ok {
+		return
+	}
+	api, ok := roomYandexAPI(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	playlists, err := listImportablePlaylists(r.Context(), api, tr(r, "http.liked_tracks"))
+	if err != nil {
+		log.Printf("Error listing Yandex playlists: %v", err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, playlists)
+}
+
+// POST /api/v1/rooms/{code}/imports {"playlist": "likes", "mode": "sync", "interval_minutes": 60}
+func apiV1ImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Playlist        string `json:"playlist"`
+		Mode            string `json:"mode"`
+		IntervalMinutes int    `json:"interval_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		httpError(w, r, "http.invalid_request", http.StatusBadRequest)
+		return
+	}
+	if requestData.Mode == "" {
+		requestData.Mode = importModeAppend
+	}
+	switch requestData.Mode {
+	case importModeAppend, importModeReplace, importModeSync:
+	default:
+		httpError(w, r, "http.invalid_import", http.StatusBadRequest)
+		return
+	}
+	if !validImportPlaylist(requestData.Playlist) || requestData.IntervalMinutes < 0 {
+		httpError(w, r, "http.invalid_import", http.StatusBadRequest)
+		return
+	}
+
+	// Замена и синхронизация удаляют треки - нужны права на удаление
+	action := roomActionAdd
+	if requestData.Mode != importModeAppend {
+		action = roomActionRemove
+	}
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), action)
+	if !ok {
+		return
+	}
+	api, ok := roomYandexAPI(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	src, err := fetchImportSource(r.Context(), api, api.userID, requestData.Playlist, tr(r, "http.liked_tracks"))
+	if isYandexNotFound(err) {
+		httpError(w, r, "http.link_not_found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading Yandex playlist %s: %v", requestData.Playlist, err)
+		httpError(w, r, "http.yandex_error", http.StatusBadGateway)
+		return
+	}
+
+	res, err := importTracks(r.Context(), roomID, api.userID, src, importOptions{
+		Playlist: requestData.Playlist,
+		Mode:     requestData.Mode,
+		Interval: requestData.IntervalMinutes,
+		AddedBy:  requestAddedBy(r),
+		Source:   requestSource(r),
+	})
+	if err != nil {
+		log.Printf("Error importing playlist: %v", err)
+		httpError(w, r, "http.internal_error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Room %d: imported %q (%s), added %d, removed %d", roomID, src.Title, res.Mode, len(res.Added), res.Removed)
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// GET /api/v1/rooms/{code}/imports - синхронизируемые плейлисты комнаты
+func apiV1ListImports(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionView)
+	if !ok {
+		return
+	}
+	jobs, err := queryPlaylistImports(r.Context(), "WHERE room_id = ?", roomID)
+	if err != nil {
+		log.Printf("Error listing playlist imports: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// DELETE /api/v1/rooms/{code}/imports/{id} - остановить синхронизацию
+func apiV1DeleteImport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "http.import_not_found", http.StatusNotFound)
+		return
+	}
+	roomID, ok := authorizeRoomAction(w, r, r.PathValue("code"), roomActionRemove)
+	if !ok {
+		return
+	}
+
+	found, err := deletePlaylistImport(roomID, "id = ?", id)
+	if err != nil {
+		log.Printf("Error deleting playlist import: %v", err)
+		httpError(w, r, "http.database_error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		httpError(w, r, "http.import_not_found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleImportCommand - импорт в плейлист бота:
+// "/import" - список плейлистов, "/import <id> [append|replace|sync]" - импорт,
+// "/import stop <id>" - остановить синхронизацию
+func handleImportCommand(message *tgbotapi.Message, cfg *Config) string {
+	lang := telegramLang(message.From)
+	args := strings.Fields(message.CommandArguments())
+
+	api := yandexAPIForRoom(0)
+	if api == nil {
+		return T(lang, "bot.no_account")
+	}
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		playlists, err := listImportablePlaylists(ctx, api, T(lang, "bot.liked_tracks"))
+		if err != nil {
+			log.Printf("Error listing Yandex playlists: %v", err)
+			return T(lang, "bot.import_error", err)
+		}
+		var sb strings.Builder
+		for _, p := range playlists {
+			sb.WriteString(fmt.Sprintf("%s - %s (%d)\n", p.ID, p.Title, p.TrackCount))
+		}
+		return T(lang, "bot.import_list", sb.String())
+	}
+
+	if strings.EqualFold(args[0], "stop") {
+		if len(args) != 2 {
+			return T(lang, "bot.import_usage")
+		}
+		found, err := deletePlaylistImport(0, "owner_uid = ? AND playlist = ?", api.userID, args[1])
+		if err != nil {
+			return T(lang, "bot.import_error", err)
+		}
+		if !found {
+			return T(lang, "bot.sync_not_found")
+		}
+		return T(lang, "bot.sync_off")
+	}
+
+	opts := importOptions{Playlist: strings.ToLower(args[0]), Mode: importModeAppend, Source: trackSourceTelegram}
+	if len(args) > 1 {
+		opts.Mode = strings.ToLower(args[1])
+	}
+	switch opts.Mode {
+	case importModeAppend, importModeReplace, importModeSync:
+	default:
+		return T(lang, "bot.import_usage")
+	}
+	if len(args) > 2 || !validImportPlaylist(opts.Playlist) {
+		return T(lang, "bot.import_usage")
+	}
+	if message.From != nil {
+		opts.AddedBy = roomMember{Type: memberTelegram, ID: message.From.ID}.String()
+	}
+
+	src, err := fetchImportSource(ctx, api, api.userID, opts.Playlist, T(lang, "bot.liked_tracks"))
+	if isYandexNotFound(err) {
+		return T(lang, "bot.link_not_found")
+	}
+	if err != nil {
+		log.Printf("Error loading Yandex playlist %s: %v", opts.Playlist, err)
+		return T(lang, "bot.import_error", err)
+	}
+
+	res, err := importTracks(ctx, 0, api.userID, src, opts)
+	if err != nil {
+		log.Printf("Error importing playlist: %v", err)
+		return T(lang, "bot.import_error", err)
+	}
+
+	reply := T(lang, "bot.imported", res.Title, len(res.Added), res.Removed,
+		res.skippedBy(skipDuplicate), res.skippedBy(skipUnavailable))
+	if res.Sync != nil {
+		reply += "\n\n" + T(lang, "bot.sync_on", res.Sync.IntervalMinutes)
+	}
+	return reply
+}